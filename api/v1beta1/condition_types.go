@@ -29,6 +29,10 @@ const (
 	// artifact download of the Konfiguration failed.
 	ArtifactFailedReason string = "ArtifactFailed"
 
+	// GitOperationFailedReason represents the fact that the
+	// checkout of the git repository of the Konfiguration failed.
+	GitOperationFailedReason string = "GitOperationFailed"
+
 	// BuildFailedReason represents the fact that the
 	// kustomize build of the Konfiguration failed.
 	BuildFailedReason string = "BuildFailed"
@@ -40,4 +44,24 @@ const (
 	// ValidationFailedReason represents the fact that the
 	// validation of the Konfiguration manifests has failed.
 	ValidationFailedReason string = "ValidationFailed"
+
+	// GenerationFailedReason represents the fact that the generators
+	// or template of a KonfigurationSet failed to produce Konfigurations.
+	GenerationFailedReason string = "GenerationFailed"
+
+	// AccessDeniedReason represents the fact that the Konfiguration
+	// violates the multi-tenancy restrictions of the controller.
+	AccessDeniedReason string = "AccessDenied"
+
+	// PolicyViolationReason represents the fact that the objects rendered
+	// by the Konfiguration violate a KonfigurationPolicy.
+	PolicyViolationReason string = "PolicyViolation"
+
+	// InsufficientPermissionsReason represents the fact that the identity
+	// the Konfiguration is reconciled as lacks permissions it needs.
+	InsufficientPermissionsReason string = "InsufficientPermissions"
+
+	// DependencyCycleReason represents the fact that the Konfiguration
+	// depends on itself through its dependencies.
+	DependencyCycleReason string = "DependencyCycle"
 )
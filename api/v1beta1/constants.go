@@ -20,6 +20,37 @@ const (
 	// BucketIndexKey is the key used for indexing kustomizations
 	// based on their S3 sources.
 	BucketIndexKey string = ".metadata.bucket"
+	// HelmChartIndexKey is the key used for indexing konfigurations
+	// based on their HelmChart sources.
+	HelmChartIndexKey string = ".metadata.helmChart"
+	// ArtifactSourceIndexKey is the key used for indexing konfigurations
+	// based on sources of any other kind publishing an artifact, such as
+	// OCIRepositories.
+	ArtifactSourceIndexKey string = ".metadata.artifactSource"
+	// KonfigurationSetSourceIndexKey is the key used for indexing KonfigurationSets
+	// based on the sources referenced by their generators.
+	KonfigurationSetSourceIndexKey string = ".metadata.generatorSource"
+	// DependsOnIndexKey is the key used for indexing Konfigurations
+	// based on the Konfigurations they depend on.
+	DependsOnIndexKey string = ".metadata.dependsOn"
+	// LibraryIndexKey is the key used for indexing Konfigurations based on the
+	// JsonnetLibraries they imported.
+	LibraryIndexKey string = ".metadata.jsonnetLibrary"
+	// LibraryVersionIndexKey is the key used for indexing JsonnetLibraries based
+	// on the library and version they publish, as <library>/<version>.
+	LibraryVersionIndexKey string = ".metadata.libraryVersion"
+	// LibrarySourceIndexKey is the key used for indexing JsonnetLibraries based
+	// on the sources they publish the artifacts of.
+	LibrarySourceIndexKey string = ".metadata.librarySource"
+)
+
+const (
+	// OutputsField is the reserved top-level field of a build that holds the values
+	// the Konfiguration publishes to its dependants.
+	OutputsField string = "__outputs__"
+	// OutputsExtVar is the external variable the outputs of a Konfiguration's
+	// dependencies are made available in, keyed by dependency.
+	OutputsExtVar string = "outputs"
 )
 
 // ServerSideApplyOwner is the FieldOwner used for Server-Side Apply.
@@ -49,6 +80,14 @@ const (
 	// PruningDisabledValue is the value set to ResourceSkipPruningLabel to exclude an object from
 	// pruning.
 	PruningDisabledValue string = "disabled"
+
+	// KonfigurationSetNameLabel is the label added to Konfigurations to denote the
+	// KonfigurationSet that generated them.
+	KonfigurationSetNameLabel string = "jsonnet.io/konfigurationset-name"
+
+	// ClusterNameLabel is the label that can be placed on kubeconfig Secrets to name
+	// the cluster they point at. Used by the KonfigurationSet clusters generator.
+	ClusterNameLabel string = "jsonnet.io/cluster-name"
 )
 
 // KonfigurationFinalizer is the finalizer placed on Konfiguration resources
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// JsonnetLibrarySpec defines a version of a library of jsonnet files, imported by
+// Konfigurations as lib://<library>/<version>/<path>. The files are published from
+// exactly one of Files, ConfigMapRef, or SourceRef.
+type JsonnetLibrarySpec struct {
+	// Library is the name the library is imported by. It defaults to the name of the
+	// JsonnetLibrary, so that several JsonnetLibraries may publish versions of the
+	// same library.
+	// +kubebuilder:validation:Pattern="^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$"
+	// +optional
+	Library string `json:"library,omitempty"`
+
+	// Version is the version the files are published under.
+	// +kubebuilder:validation:Pattern="^[a-zA-Z0-9][a-zA-Z0-9._-]*$"
+	// +required
+	Version string `json:"version"`
+
+	// Files are the files of the library, keyed by path.
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
+
+	// ConfigMapRef publishes the keys of a ConfigMap in the namespace of the
+	// JsonnetLibrary as files.
+	// +optional
+	ConfigMapRef *meta.LocalObjectReference `json:"configMapRef,omitempty"`
+
+	// SourceRef publishes the files of a source artifact.
+	// +optional
+	SourceRef *meta.NamespacedObjectKindReference `json:"sourceRef,omitempty"`
+
+	// Path is the directory of the source artifact that is published, defaulting
+	// to its root.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Interval is the interval at which the ConfigMap or source artifact is checked
+	// for changes.
+	// +kubebuilder:default:="5m"
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// JsonnetLibraryStatus defines the observed state of a JsonnetLibrary
+type JsonnetLibraryStatus struct {
+	// ObservedGeneration is the last reconciled generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Revision is the digest of the published files, in the form
+	// <version>@sha256:<digest>. Konfigurations importing the library are
+	// reconciled whenever it changes.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=jsonnetlib;jsonnetlibs
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".spec.version"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// JsonnetLibrary is the Schema for the jsonnetlibraries API
+type JsonnetLibrary struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   JsonnetLibrarySpec   `json:"spec,omitempty"`
+	Status JsonnetLibraryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// JsonnetLibraryList contains a list of JsonnetLibrary
+type JsonnetLibraryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []JsonnetLibrary `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&JsonnetLibrary{}, &JsonnetLibraryList{})
+}
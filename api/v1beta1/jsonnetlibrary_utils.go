@@ -0,0 +1,114 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetNamespacedName returns the namespaced name for this JsonnetLibrary.
+func (l *JsonnetLibrary) GetNamespacedName() types.NamespacedName {
+	return types.NamespacedName{
+		Name:      l.GetName(),
+		Namespace: l.GetNamespace(),
+	}
+}
+
+// GetLibrary returns the name the library is imported by.
+func (l *JsonnetLibrary) GetLibrary() string {
+	if l.Spec.Library != "" {
+		return l.Spec.Library
+	}
+	return l.GetName()
+}
+
+// GetInterval returns the interval at which to check the published files for changes.
+func (l *JsonnetLibrary) GetInterval() time.Duration { return l.Spec.Interval.Duration }
+
+// GetSourceRef returns the source ref for this library.
+func (l *JsonnetLibrary) GetSourceRef() *meta.NamespacedObjectKindReference {
+	if l.Spec.SourceRef != nil {
+		if l.Spec.SourceRef.Namespace == "" {
+			l.Spec.SourceRef.Namespace = l.GetNamespace()
+		}
+		return l.Spec.SourceRef
+	}
+	return nil
+}
+
+// Validate returns an error if the library does not publish its files from exactly
+// one of inline files, a ConfigMap, or a source artifact.
+func (l *JsonnetLibrary) Validate() error {
+	var sources int
+	if len(l.Spec.Files) > 0 {
+		sources++
+	}
+	if l.Spec.ConfigMapRef != nil {
+		sources++
+	}
+	if l.Spec.SourceRef != nil {
+		sources++
+	}
+	if sources != 1 {
+		return errors.New("exactly one of files, configMapRef, or sourceRef must be set")
+	}
+	if l.Spec.Path != "" && l.Spec.SourceRef == nil {
+		return errors.New("path may only be set together with sourceRef")
+	}
+	return nil
+}
+
+// GetStatusConditions returns the status conditions for this resource.
+func (l *JsonnetLibrary) GetStatusConditions() *[]metav1.Condition {
+	return &l.Status.Conditions
+}
+
+// SetReady registers the revision of the files published by this JsonnetLibrary.
+func (l *JsonnetLibrary) SetReady(ctx context.Context, cl client.Client, revision, message string) error {
+	meta.SetResourceCondition(l, meta.ReadyCondition, metav1.ConditionTrue, meta.ReconciliationSucceededReason, trimString(message, MaxConditionMessageLength))
+	l.Status.ObservedGeneration = l.Generation
+	l.Status.Revision = revision
+	return l.patchStatus(ctx, cl, l.Status)
+}
+
+// SetNotReady registers a failed attempt to read the files of this JsonnetLibrary. The
+// revision last published is left untouched.
+func (l *JsonnetLibrary) SetNotReady(ctx context.Context, cl client.Client, reason, message string) error {
+	meta.SetResourceCondition(l, meta.ReadyCondition, metav1.ConditionFalse, reason, trimString(message, MaxConditionMessageLength))
+	l.Status.ObservedGeneration = l.Generation
+	return l.patchStatus(ctx, cl, l.Status)
+}
+
+func (l *JsonnetLibrary) patchStatus(ctx context.Context, cl client.Client, newStatus JsonnetLibraryStatus) error {
+	var lib JsonnetLibrary
+	if err := cl.Get(ctx, l.GetNamespacedName(), &lib); err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(lib.DeepCopy())
+	lib.Status = newStatus
+
+	return cl.Status().Patch(ctx, &lib, patch)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+)
+
+func TestJsonnetLibraryValidate(t *testing.T) {
+	files := map[string]string{"main.libsonnet": "{}"}
+	configMapRef := &meta.LocalObjectReference{Name: "lib"}
+	sourceRef := &meta.NamespacedObjectKindReference{Kind: "GitRepository", Name: "repo"}
+
+	tcs := []struct {
+		name  string
+		spec  JsonnetLibrarySpec
+		valid bool
+	}{
+		{"files", JsonnetLibrarySpec{Files: files}, true},
+		{"configMapRef", JsonnetLibrarySpec{ConfigMapRef: configMapRef}, true},
+		{"sourceRef", JsonnetLibrarySpec{SourceRef: sourceRef}, true},
+		{"sourceRef with path", JsonnetLibrarySpec{SourceRef: sourceRef, Path: "lib"}, true},
+		{"no source", JsonnetLibrarySpec{}, false},
+		{"files and configMapRef", JsonnetLibrarySpec{Files: files, ConfigMapRef: configMapRef}, false},
+		{"configMapRef and sourceRef", JsonnetLibrarySpec{ConfigMapRef: configMapRef, SourceRef: sourceRef}, false},
+		{"path without sourceRef", JsonnetLibrarySpec{ConfigMapRef: configMapRef, Path: "lib"}, false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.spec.Version = "v1"
+			lib := &JsonnetLibrary{Spec: tc.spec}
+			if err := lib.Validate(); (err == nil) != tc.valid {
+				t.Errorf("expected valid=%v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
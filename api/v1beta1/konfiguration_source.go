@@ -19,45 +19,145 @@ package v1beta1
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// OCIRepositoryKind is the kind of the Flux OCIRepository source.
+	OCIRepositoryKind = "OCIRepository"
+	// OCIRepositoryAPIVersion is the API version used for OCIRepository sources
+	// that do not declare one.
+	OCIRepositoryAPIVersion = "source.toolkit.fluxcd.io/v1beta2"
+)
+
+// SupportedSourceKinds are the source kinds that may be referenced without an
+// apiVersion. Any other kind must declare its apiVersion and is read as an
+// ArtifactSource.
+var SupportedSourceKinds = []string{
+	sourcev1.GitRepositoryKind,
+	sourcev1.BucketKind,
+	sourcev1.HelmChartKind,
+	OCIRepositoryKind,
+}
+
+// ArtifactSourceGVK returns the group, version, and kind of the given source reference
+// when it is read as an ArtifactSource, or false when it refers to one of the sources
+// built into the source-controller API. An error is returned when the kind is not
+// known and no apiVersion is given.
+func ArtifactSourceGVK(sref *meta.NamespacedObjectKindReference) (schema.GroupVersionKind, bool, error) {
+	switch sref.Kind {
+	case sourcev1.GitRepositoryKind, sourcev1.BucketKind, sourcev1.HelmChartKind:
+		if sref.APIVersion == "" {
+			return schema.GroupVersionKind{}, false, nil
+		}
+		if gv, err := schema.ParseGroupVersion(sref.APIVersion); err == nil && gv == sourcev1.GroupVersion {
+			return schema.GroupVersionKind{}, false, nil
+		}
+	case OCIRepositoryKind:
+		if sref.APIVersion == "" {
+			return schema.FromAPIVersionAndKind(OCIRepositoryAPIVersion, sref.Kind), true, nil
+		}
+	}
+	if sref.APIVersion == "" || sref.Kind == "" {
+		return schema.GroupVersionKind{}, false, fmt.Errorf("source `%s` kind '%s' not supported, an apiVersion is required for kinds other than %v",
+			sref.Name, sref.Kind, SupportedSourceKinds)
+	}
+	gv, err := schema.ParseGroupVersion(sref.APIVersion)
+	if err != nil {
+		return schema.GroupVersionKind{}, false, fmt.Errorf("source `%s` has an invalid apiVersion: %w", sref.Name, err)
+	}
+	return gv.WithKind(sref.Kind), true, nil
+}
+
 // GetSource will retrieve the source object referenced by sref.
 func GetSource(ctx context.Context, c client.Client, sref *meta.NamespacedObjectKindReference) (sourcev1.Source, error) {
-	var source sourcev1.Source
 	namespacedName := types.NamespacedName{
 		Namespace: sref.Namespace,
 		Name:      sref.Name,
 	}
-	switch sref.Kind {
-	case sourcev1.GitRepositoryKind:
-		var repository sourcev1.GitRepository
-		err := c.Get(ctx, namespacedName, &repository)
-		if err != nil {
-			if client.IgnoreNotFound(err) == nil {
-				return source, err
-			}
-			return source, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
+	gvk, generic, err := ArtifactSourceGVK(sref)
+	if err != nil {
+		return nil, err
+	}
+	var obj client.Object
+	switch {
+	case generic:
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		obj = u
+	case sref.Kind == sourcev1.GitRepositoryKind:
+		obj = &sourcev1.GitRepository{}
+	case sref.Kind == sourcev1.BucketKind:
+		obj = &sourcev1.Bucket{}
+	case sref.Kind == sourcev1.HelmChartKind:
+		obj = &sourcev1.HelmChart{}
+	}
+	if err := c.Get(ctx, namespacedName, obj); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil, err
 		}
-		source = &repository
-	case sourcev1.BucketKind:
-		var bucket sourcev1.Bucket
-		err := c.Get(ctx, namespacedName, &bucket)
-		if err != nil {
-			if client.IgnoreNotFound(err) == nil {
-				return source, err
-			}
-			return source, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
+		return nil, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return &ArtifactSource{Unstructured: u}, nil
+	}
+	return obj.(sourcev1.Source), nil
+}
+
+// ArtifactSource is a source of any kind that publishes a source-controller artifact
+// in its status, such as an OCIRepository.
+// +kubebuilder:object:generate=false
+type ArtifactSource struct {
+	*unstructured.Unstructured
+}
+
+var _ sourcev1.Source = &ArtifactSource{}
+
+// GetArtifact returns the artifact in the status of the source, if any. Sources that
+// only advertise a digest have it used as the checksum.
+func (a *ArtifactSource) GetArtifact() *sourcev1.Artifact {
+	fields, ok, err := unstructured.NestedMap(a.Object, "status", "artifact")
+	if !ok || err != nil {
+		return nil
+	}
+	str := func(key string) string {
+		s, _, _ := unstructured.NestedString(fields, key)
+		return s
+	}
+	artifact := &sourcev1.Artifact{
+		Path:     str("path"),
+		URL:      str("url"),
+		Revision: str("revision"),
+		Checksum: str("checksum"),
+	}
+	if artifact.URL == "" {
+		return nil
+	}
+	if artifact.Checksum == "" {
+		artifact.Checksum = str("digest")
+	}
+	if t, err := time.Parse(time.RFC3339, str("lastUpdateTime")); err == nil {
+		artifact.LastUpdateTime = metav1.NewTime(t)
+	}
+	return artifact
+}
+
+// GetInterval returns the interval at which the source is updated.
+func (a *ArtifactSource) GetInterval() metav1.Duration {
+	var interval metav1.Duration
+	if s, ok, _ := unstructured.NestedString(a.Object, "spec", "interval"); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			interval.Duration = d
 		}
-		source = &bucket
-	default:
-		return source, fmt.Errorf("source `%s` kind '%s' not supported",
-			sref.Name, sref.Kind)
 	}
-	return source, nil
+	return interval
 }
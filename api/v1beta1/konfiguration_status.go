@@ -21,6 +21,7 @@ import (
 
 	"github.com/fluxcd/pkg/apis/meta"
 
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -47,6 +48,7 @@ func (k *Konfiguration) GetStatusConditions() *[]metav1.Condition {
 // SetProgressing resets the conditions of this Konfiguration to a single
 // ReadyCondition with status ConditionUnknown.
 func (k *Konfiguration) SetProgressing(ctx context.Context, cl client.Client) error {
+	apimeta.RemoveStatusCondition(k.GetStatusConditions(), meta.StalledCondition)
 	meta.SetResourceCondition(k, meta.ReadyCondition, metav1.ConditionUnknown, meta.ProgressingReason, "reconciliation in progress")
 	return k.patchStatus(ctx, cl, k.Status)
 }
@@ -65,6 +67,7 @@ func (k *Konfiguration) SetHealthiness(ctx context.Context, cl client.Client, st
 // SetReadiness sets the ReadyCondition, ObservedGeneration, and LastAttemptedRevision,
 // on the Konfiguration.
 func (k *Konfiguration) SetReadiness(ctx context.Context, cl client.Client, status metav1.ConditionStatus, statusMeta *StatusMeta) error {
+	apimeta.RemoveStatusCondition(k.GetStatusConditions(), meta.StalledCondition)
 	meta.SetResourceCondition(k, meta.ReadyCondition, status, statusMeta.Reason, trimString(statusMeta.Message, MaxConditionMessageLength))
 	k.Status.ObservedGeneration = k.Generation
 	if statusMeta.Revision != "" {
@@ -78,6 +81,19 @@ func (k *Konfiguration) SetNotReady(ctx context.Context, cl client.Client, meta
 	return k.SetReadiness(ctx, cl, metav1.ConditionFalse, meta)
 }
 
+// SetStalled marks this Konfiguration as unable to make progress until its spec,
+// or the spec of a related Konfiguration, changes.
+func (k *Konfiguration) SetStalled(ctx context.Context, cl client.Client, statusMeta *StatusMeta) error {
+	msg := trimString(statusMeta.Message, MaxConditionMessageLength)
+	meta.SetResourceCondition(k, meta.StalledCondition, metav1.ConditionTrue, statusMeta.Reason, msg)
+	meta.SetResourceCondition(k, meta.ReadyCondition, metav1.ConditionFalse, statusMeta.Reason, msg)
+	k.Status.ObservedGeneration = k.Generation
+	if statusMeta.Revision != "" {
+		k.Status.LastAttemptedRevision = statusMeta.Revision
+	}
+	return k.patchStatus(ctx, cl, k.Status)
+}
+
 // SetNotReadySnapshot registers a failed apply attempt of this Konfiguration,
 // including a Snapshot.
 func (k *Konfiguration) SetNotReadySnapshot(ctx context.Context, cl client.Client, snapshot *Snapshot, meta *StatusMeta) error {
@@ -89,9 +105,39 @@ func (k *Konfiguration) SetNotReadySnapshot(ctx context.Context, cl client.Clien
 	return k.SetReadiness(ctx, cl, metav1.ConditionFalse, meta)
 }
 
-// SetReady registers a successful apply attempt of this Konfiguration.
-func (k *Konfiguration) SetReady(ctx context.Context, cl client.Client, snapshot *Snapshot, meta *StatusMeta) error {
-	k.Status.Snapshot = snapshot
+// BuildResult is what a successful apply of a Konfiguration records in its status.
+// +kubebuilder:object:generate=false
+type BuildResult struct {
+	Snapshot      *Snapshot
+	Outputs       *extv1.JSON
+	Inputs        *BuildInputs
+	RemoteImports []RemoteImport
+	Libraries     []LibraryImport
+	Lookups       []ObjectLookup
+}
+
+// BuildResult returns the results of the last successful apply recorded in the
+// status of this Konfiguration.
+func (k *Konfiguration) BuildResult() *BuildResult {
+	return &BuildResult{
+		Snapshot:      k.Status.Snapshot,
+		Outputs:       k.Status.Outputs,
+		Inputs:        k.Status.Inputs,
+		RemoteImports: k.Status.RemoteImports,
+		Libraries:     k.Status.Libraries,
+		Lookups:       k.Status.Lookups,
+	}
+}
+
+// SetReady registers a successful apply attempt of this Konfiguration, including
+// the outputs of the build and what it was built from.
+func (k *Konfiguration) SetReady(ctx context.Context, cl client.Client, result *BuildResult, meta *StatusMeta) error {
+	k.Status.Snapshot = result.Snapshot
+	k.Status.Outputs = result.Outputs
+	k.Status.Inputs = result.Inputs
+	k.Status.RemoteImports = result.RemoteImports
+	k.Status.Libraries = result.Libraries
+	k.Status.Lookups = result.Lookups
 	k.Status.LastAppliedRevision = meta.Revision
 	if err := k.SetHealthiness(ctx, cl, metav1.ConditionTrue, meta); err != nil {
 		return err
@@ -18,7 +18,6 @@ package v1beta1
 
 import (
 	"github.com/fluxcd/pkg/apis/meta"
-	"github.com/fluxcd/pkg/runtime/dependency"
 
 	corev1 "k8s.io/api/core/v1"
 	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
@@ -29,11 +28,17 @@ import (
 
 // KonfigurationSpec defines the desired state of a Konfiguration
 type KonfigurationSpec struct {
-	// DependsOn may contain a dependency.CrossNamespaceDependencyReference slice
-	// with references to Konfiguration resources that must be ready before this
-	// Konfiguration can be reconciled.
+	// DependsOn may contain references to objects that must be ready before this
+	// Konfiguration can be reconciled. References without an apiVersion and kind
+	// point at other Konfigurations.
 	// +optional
-	DependsOn []dependency.CrossNamespaceDependencyReference `json:"dependsOn,omitempty"`
+	DependsOn []DependencyReference `json:"dependsOn,omitempty"`
+
+	// DependsOnSameRevision requires the Konfigurations in DependsOn to be ready
+	// at the same source revision as this Konfiguration. This is useful when they
+	// are built from the same source.
+	// +optional
+	DependsOnSameRevision bool `json:"dependsOnSameRevision,omitempty"`
 
 	// The interval at which to reconcile the Konfiguration.
 	// +required
@@ -62,10 +67,19 @@ type KonfigurationSpec struct {
 	// +optional
 	JsonnetPaths []string `json:"jsonnetPaths,omitempty"`
 
-	// Additional HTTP(S) URLs to add to the jsonnet importer.
+	// Additional URLs to add to the jsonnet importer. They are HTTP(S) URLs,
+	// oci://registry/repository:tag/ URLs of OCI artifacts, or k8s://namespace/name/
+	// URLs of ConfigMaps readable by the Konfiguration's identity.
 	// +optional
 	JsonnetURLs []string `json:"jsonnetURLs,omitempty"`
 
+	// ImportLock pins the contents of files imported over HTTP(S), including those
+	// found through JsonnetURLs and an HTTP(S) Path. When it is set, or the source
+	// contains a jsonnet.lock.json at its root, a build fails on any remote import
+	// that is not pinned or whose contents do not match their digest.
+	// +optional
+	ImportLock *ImportLock `json:"importLock,omitempty"`
+
 	// External variables and top-level arguments to supply to the jsonnet
 	// at `path`.
 	// +optional
@@ -84,6 +98,18 @@ type KonfigurationSpec struct {
 	// +optional
 	SourceRef *meta.NamespacedObjectKindReference `json:"sourceRef,omitempty"`
 
+	// An HTTP(S) tarball the jsonnet, json, or yaml file(s) are extracted from. Path
+	// is relative to the root of the archive. It may not be set together with
+	// SourceRef or Git.
+	// +optional
+	Archive *HTTPArchive `json:"archive,omitempty"`
+
+	// A git repository the jsonnet, json, or yaml file(s) are checked out from by
+	// the controller itself, without requiring source-controller. It may not be set
+	// together with SourceRef.
+	// +optional
+	Git *GitSource `json:"git,omitempty"`
+
 	// Prune enables garbage collection. This means that when newly rendered
 	// jsonnet does not contain objects that were applied previously, they will
 	// be removed. When a Konfiguration is removed that had this value set to
@@ -119,6 +145,95 @@ type KonfigurationSpec struct {
 	Force bool `json:"force,omitempty"`
 }
 
+// DependencyReference is a reference to an object that must be ready before a
+// Konfiguration is reconciled. Flux resources are ready when their Ready condition
+// is true for their current generation, and all other objects when kstatus reports
+// them as current.
+type DependencyReference struct {
+	// API version of the referent, defaults to that of Konfigurations.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind of the referent, defaults to Konfiguration.
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Name of the referent.
+	// +required
+	Name string `json:"name"`
+
+	// Namespace of the referent, defaults to the namespace of the Konfiguration.
+	// Ignored for cluster-scoped objects.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// HTTPArchive is a tarball fetched over HTTP(S) and verified against a checksum.
+type HTTPArchive struct {
+	// URL of the gzipped tarball.
+	// +required
+	URL string `json:"url"`
+
+	// Checksum is the expected checksum of the tarball, as a sha256 hex digest
+	// optionally prefixed with 'sha256:'.
+	// +required
+	Checksum string `json:"checksum"`
+}
+
+// ImportLock pins remote imports to the sha256 digests of their contents.
+type ImportLock struct {
+	// File is the path of a lockfile relative to the root of the source, as written
+	// by 'konfig lock'. Defaults to jsonnet.lock.json when the source contains it.
+	// +optional
+	File string `json:"file,omitempty"`
+
+	// Imports maps the URLs of remote imports to the sha256 hex digests of their
+	// contents, optionally prefixed with 'sha256:'. They take precedence over the
+	// digests in File.
+	// +optional
+	Imports map[string]string `json:"imports,omitempty"`
+}
+
+// GitSource is a git repository checked out by the controller.
+type GitSource struct {
+	// URL of the repository, over HTTP(S) or SSH. SSH URLs may be given in the
+	// scp-like form, e.g. git@github.com:org/repo.git.
+	// +required
+	URL string `json:"url"`
+
+	// The reference to check out. Defaults to the branch the remote HEAD points at.
+	// +optional
+	Ref *GitReference `json:"ref,omitempty"`
+
+	// SecretRef holds the name of a secret in the same namespace as the
+	// Konfiguration with the credentials for the repository. For HTTPS these are
+	// the 'username' and 'password' keys, and for SSH the 'identity' and
+	// 'known_hosts' keys.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// SparsePath restricts the checkout to the given path of the repository. The
+	// Path of the Konfiguration remains relative to the root of the repository.
+	// +optional
+	SparsePath string `json:"sparsePath,omitempty"`
+}
+
+// GitReference is a reference to check out of a git repository. At most one of its
+// fields may be set.
+type GitReference struct {
+	// Branch to check out.
+	// +optional
+	Branch string `json:"branch,omitempty"`
+
+	// Tag to check out.
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Commit to check out, as a full commit SHA.
+	// +optional
+	Commit string `json:"commit,omitempty"`
+}
+
 // KubeConfig holds the configuration for where to fetch the contents of a
 // kubeconfig file.
 type KubeConfig struct {
@@ -169,18 +284,119 @@ type KonfigurationStatus struct {
 
 	// The last successfully applied revision.
 	// The revision format for Git sources is <branch|tag>/<commit-sha>.
-	// For HTTP(S) paths it will just be the URL.
+	// For HTTP(S) paths and archives it is <url>@sha256:<digest>.
 	// +optional
 	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
 
 	// LastAttemptedRevision is the revision of the last reconciliation attempt.
-	// For HTTP(S) paths it will just be the URL.
+	// For HTTP(S) paths and archives it is <url>@sha256:<digest>.
 	// +optional
 	LastAttemptedRevision string `json:"lastAttemptedRevision,omitempty"`
 
 	// The last successfully applied revision metadata.
 	// +optional
 	Snapshot *Snapshot `json:"snapshot,omitempty"`
+
+	// Outputs are the values published by the last successful build through the
+	// reserved __outputs__ field. They are made available to dependant Konfigurations
+	// in the outputs external variable.
+	// +optional
+	Outputs *extv1.JSON `json:"outputs,omitempty"`
+
+	// Inputs records what the last successful build was evaluated from. It is used
+	// to skip builds at new source revisions that did not change any imported file.
+	// +optional
+	Inputs *BuildInputs `json:"inputs,omitempty"`
+
+	// RemoteImports are the files imported over HTTP(S) by the last successful
+	// build. They are polled for changes, which trigger a reconcile.
+	// +optional
+	RemoteImports []RemoteImport `json:"remoteImports,omitempty"`
+
+	// Libraries are the JsonnetLibraries imported by the last successful build. A
+	// new revision of any of them triggers a reconcile.
+	// +optional
+	Libraries []LibraryImport `json:"libraries,omitempty"`
+
+	// Lookups are the objects read by the lookup native function during the last
+	// successful build. They are polled for changes, which trigger a reconcile.
+	// +optional
+	Lookups []ObjectLookup `json:"lookups,omitempty"`
+}
+
+// BuildInputs records what a build was evaluated from.
+type BuildInputs struct {
+	// Generation is the generation of the Konfiguration that was built.
+	Generation int64 `json:"generation"`
+
+	// OutputsChecksum is the checksum of the outputs of dependencies the build
+	// received.
+	// +optional
+	OutputsChecksum string `json:"outputsChecksum,omitempty"`
+
+	// Imports are the files imported by the build.
+	// +optional
+	Imports []ImportedFile `json:"imports,omitempty"`
+}
+
+// ImportedFile is a file imported by a build.
+type ImportedFile struct {
+	// Path is the path of the file relative to the root of the source.
+	Path string `json:"path"`
+
+	// Checksum is the sha256 checksum of the contents of the file.
+	Checksum string `json:"checksum"`
+
+	// Missing is set for paths that were searched for an import and did not exist.
+	// Their checksum is empty, and creating them would shadow the import.
+	// +optional
+	Missing bool `json:"missing,omitempty"`
+}
+
+// RemoteImport is a file imported over HTTP(S) by a build.
+type RemoteImport struct {
+	// URL of the file.
+	URL string `json:"url"`
+
+	// Checksum is the sha256 checksum of the contents of the file.
+	Checksum string `json:"checksum"`
+}
+
+// LibraryImport is a JsonnetLibrary imported by a build.
+type LibraryImport struct {
+	// Namespace of the JsonnetLibrary.
+	Namespace string `json:"namespace"`
+
+	// Name of the JsonnetLibrary.
+	Name string `json:"name"`
+
+	// Revision of the JsonnetLibrary when it was imported.
+	Revision string `json:"revision"`
+}
+
+// ObjectLookup is an object, or a list of objects when the name is empty, read by
+// the lookup native function during a build.
+type ObjectLookup struct {
+	// APIVersion of the object.
+	APIVersion string `json:"apiVersion"`
+
+	// Kind of the object.
+	Kind string `json:"kind"`
+
+	// Namespace of the object, empty for cluster-scoped objects or lists across
+	// all namespaces.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the object, empty for a list.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Checksum is the sha256 checksum of the UIDs and contents of what was read,
+	// ignoring status, resource versions and managed fields, empty if the object
+	// was not found.
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -28,6 +28,7 @@ import (
 	"github.com/fluxcd/pkg/runtime/dependency"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -143,6 +144,32 @@ func (v *Variables) InjectIntoVM(vm *jsonnet.VM) error {
 	return nil
 }
 
+// TLACodes returns the code of every configured top-level argument, keyed by name,
+// with string arguments quoted.
+func (v *Variables) TLACodes() (map[string]string, error) {
+	codes := make(map[string]string)
+	for k, v := range v.TLAStr {
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		codes[k] = string(j)
+	}
+	for k, v := range v.TLACode {
+		codes[k] = v
+	}
+	if v.TLAVars != nil {
+		var vars map[string]interface{}
+		if err := json.Unmarshal(v.TLAVars.Raw, &vars); err != nil {
+			return nil, err
+		}
+		if err := iterVarsIntoVM(vars, func(k, code string) { codes[k] = code }); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
 func iterVarsIntoVM(vars map[string]interface{}, codeFunc func(string, string)) error {
 	for k, v := range vars {
 		j, err := json.Marshal(v)
@@ -177,9 +204,52 @@ func (k *Konfiguration) IsSuspended() bool { return k.Spec.Suspend }
 // when patching fails due to an immutable field change.
 func (k *Konfiguration) ForceCreate() bool { return k.Spec.Force }
 
-// GetDependsOn returns the konfigurations this one depends on.
+// GetDependsOn returns the konfigurations this one depends on. Dependencies on
+// other kinds of objects are not included.
 func (k Konfiguration) GetDependsOn() (types.NamespacedName, []dependency.CrossNamespaceDependencyReference) {
-	return k.GetNamespacedName(), k.Spec.DependsOn
+	var deps []dependency.CrossNamespaceDependencyReference
+	for _, dep := range k.Spec.DependsOn {
+		if dep.IsKonfiguration() {
+			deps = append(deps, dependency.CrossNamespaceDependencyReference{Namespace: dep.Namespace, Name: dep.Name})
+		}
+	}
+	return k.GetNamespacedName(), deps
+}
+
+// IsKonfiguration returns whether the reference points at a Konfiguration.
+func (d *DependencyReference) IsKonfiguration() bool {
+	if d.Kind == "" && d.APIVersion == "" {
+		return true
+	}
+	gv, err := schema.ParseGroupVersion(d.APIVersion)
+	if err != nil {
+		return false
+	}
+	return d.Kind == "Konfiguration" && gv.Group == GroupVersion.Group
+}
+
+// GroupVersionKind returns the GroupVersionKind of the referent.
+func (d *DependencyReference) GroupVersionKind() (schema.GroupVersionKind, error) {
+	if d.IsKonfiguration() {
+		return GroupVersion.WithKind("Konfiguration"), nil
+	}
+	gv, err := schema.ParseGroupVersion(d.APIVersion)
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	return gv.WithKind(d.Kind), nil
+}
+
+// String returns a human readable representation of the reference.
+func (d DependencyReference) String() string {
+	name := d.Name
+	if d.Namespace != "" {
+		name = d.Namespace + "/" + d.Name
+	}
+	if d.IsKonfiguration() {
+		return name
+	}
+	return fmt.Sprintf("%s '%s'", d.Kind, name)
 }
 
 // GetHealthChecks returns the health checks for this Konfiguration.
@@ -187,6 +257,16 @@ func (k *Konfiguration) GetHealthChecks() []meta.NamespacedObjectKindReference {
 	return k.Spec.HealthChecks
 }
 
+// GetArchive returns the HTTP(S) archive for this konfiguration, if any.
+func (k *Konfiguration) GetArchive() *HTTPArchive { return k.Spec.Archive }
+
+// GetImportLock returns the lock pinning the remote imports of this konfiguration,
+// if any.
+func (k *Konfiguration) GetImportLock() *ImportLock { return k.Spec.ImportLock }
+
+// GetGit returns the git repository for this konfiguration, if any.
+func (k *Konfiguration) GetGit() *GitSource { return k.Spec.Git }
+
 // GetSourceRef returns the source ref for this konfiguration.
 func (k *Konfiguration) GetSourceRef() *meta.NamespacedObjectKindReference {
 	if k.Spec.SourceRef != nil {
@@ -0,0 +1,312 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks for
+// Konfigurations with the manager.
+func (k *Konfiguration) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(k).Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-jsonnet-io-v1beta1-konfiguration,mutating=true,failurePolicy=fail,sideEffects=None,groups=jsonnet.io,resources=konfigurations,verbs=create;update,versions=v1beta1,name=mkonfiguration.jsonnet.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &Konfiguration{}
+
+// Default sets the values the controller would otherwise assume at reconcile time,
+// so they are visible on the object.
+func (k *Konfiguration) Default() {
+	if k.Spec.SourceRef != nil && k.Spec.SourceRef.Namespace == "" {
+		k.Spec.SourceRef.Namespace = k.GetNamespace()
+	}
+	if k.Spec.RetryInterval == nil {
+		k.Spec.RetryInterval = &metav1.Duration{Duration: k.GetInterval()}
+	}
+	if k.Spec.Timeout == nil {
+		k.Spec.Timeout = &metav1.Duration{Duration: k.GetInterval()}
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-jsonnet-io-v1beta1-konfiguration,mutating=false,failurePolicy=fail,sideEffects=None,groups=jsonnet.io,resources=konfigurations,verbs=create;update,versions=v1beta1,name=vkonfiguration.jsonnet.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &Konfiguration{}
+
+// ValidateCreate implements webhook.Validator.
+func (k *Konfiguration) ValidateCreate() error { return k.invalid(k.validate()) }
+
+// ValidateUpdate implements webhook.Validator. Only errors the update introduces are
+// rejected, so that objects created before a rule was added can still be updated,
+// and updates of Konfigurations being deleted or leaving the spec unchanged, such as
+// the removal of finalizers, are always allowed.
+func (k *Konfiguration) ValidateUpdate(old runtime.Object) error {
+	if !k.GetDeletionTimestamp().IsZero() {
+		return nil
+	}
+	oldKonfig, ok := old.(*Konfiguration)
+	if !ok {
+		return k.invalid(k.validate())
+	}
+	if equality.Semantic.DeepEqual(k.Spec, oldKonfig.Spec) {
+		return nil
+	}
+	return k.invalid(newErrors(k.validate(), oldKonfig.validate()))
+}
+
+// ValidateDelete implements webhook.Validator. Deletes are always allowed.
+func (k *Konfiguration) ValidateDelete() error { return nil }
+
+// validate checks the spec for errors that would otherwise only surface at
+// reconcile time.
+func (k *Konfiguration) validate() field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if sourceRef := k.GetSourceRef(); sourceRef != nil {
+		if _, _, err := ArtifactSourceGVK(sourceRef); err != nil {
+			if sourceRef.APIVersion == "" {
+				errs = append(errs, field.NotSupported(specPath.Child("sourceRef", "kind"), sourceRef.Kind, SupportedSourceKinds))
+			} else {
+				errs = append(errs, field.Invalid(specPath.Child("sourceRef", "apiVersion"), sourceRef.APIVersion, err.Error()))
+			}
+		}
+		if sourceRef.Name == "" {
+			errs = append(errs, field.Required(specPath.Child("sourceRef", "name"), ""))
+		}
+		if k.GetGit() != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("git"), "may not be set together with sourceRef"))
+		}
+		if k.GetArchive() != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("archive"), "may not be set together with sourceRef"))
+		}
+	} else if git := k.GetGit(); git != nil {
+		errs = append(errs, validateGit(specPath.Child("git"), git)...)
+		if k.GetArchive() != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("archive"), "may not be set together with git"))
+		}
+	} else if archive := k.GetArchive(); archive != nil {
+		if !isHTTPURL(archive.URL) {
+			errs = append(errs, field.Invalid(specPath.Child("archive", "url"), archive.URL, "must be an HTTP(S) URL"))
+		}
+		if !sha256DigestRegex.MatchString(archive.Checksum) {
+			errs = append(errs, field.Invalid(specPath.Child("archive", "checksum"), archive.Checksum, "must be a sha256 hex digest"))
+		}
+	} else if !isHTTPURL(k.GetPath()) {
+		errs = append(errs, field.Invalid(specPath.Child("path"), k.GetPath(),
+			"must be an HTTP(S) URL when no sourceRef, git repository, or archive is given"))
+	}
+
+	if kubeConfig := k.GetKubeConfig(); kubeConfig != nil {
+		if kubeConfig.SecretRef.Name == "" {
+			errs = append(errs, field.Required(specPath.Child("kubeConfig", "secretRef", "name"), ""))
+		}
+		if k.GetServiceAccountName() != "" {
+			errs = append(errs, field.Forbidden(specPath.Child("serviceAccountName"),
+				"may not be set together with kubeConfig"))
+		}
+	}
+
+	for i, u := range k.GetJsonnetURLs() {
+		if !isHTTPURL(u) && !isSchemeURL(u, "oci", "k8s") {
+			errs = append(errs, field.Invalid(specPath.Child("jsonnetURLs").Index(i), u, "must be an HTTP(S), oci:// or k8s:// URL"))
+		}
+	}
+
+	if lock := k.GetImportLock(); lock != nil {
+		lockPath := specPath.Child("importLock")
+		if lock.File != "" {
+			if k.GetSourceRef() == nil && k.GetGit() == nil && k.GetArchive() == nil {
+				errs = append(errs, field.Forbidden(lockPath.Child("file"), "requires a sourceRef, git repository, or archive"))
+			} else if filepath.IsAbs(lock.File) {
+				errs = append(errs, field.Invalid(lockPath.Child("file"), lock.File, "must be relative to the root of the source"))
+			}
+		}
+		for u, digest := range lock.Imports {
+			if !isHTTPURL(u) {
+				errs = append(errs, field.Invalid(lockPath.Child("imports").Key(u), u, "must be keyed by HTTP(S) URLs"))
+			}
+			if !sha256DigestRegex.MatchString(digest) {
+				errs = append(errs, field.Invalid(lockPath.Child("imports").Key(u), digest, "must be a sha256 hex digest"))
+			}
+		}
+	}
+
+	if vars := k.GetVariables(); vars != nil {
+		varsPath := specPath.Child("variables")
+		errs = append(errs, validateCode(varsPath.Child("extCode"), vars.ExtCode)...)
+		errs = append(errs, validateCode(varsPath.Child("tlaCode"), vars.TLACode)...)
+		if vars.ExtVars != nil {
+			errs = append(errs, validateVarsObject(varsPath.Child("extVars"), vars.ExtVars.Raw)...)
+		}
+		if vars.TLAVars != nil {
+			errs = append(errs, validateVarsObject(varsPath.Child("tlaVars"), vars.TLAVars.Raw)...)
+		}
+	}
+
+	for i, dep := range k.Spec.DependsOn {
+		depPath := specPath.Child("dependsOn").Index(i)
+		if !dep.IsKonfiguration() {
+			if dep.APIVersion == "" || dep.Kind == "" {
+				errs = append(errs, field.Required(depPath, "apiVersion and kind must be set together"))
+			} else if _, err := schema.ParseGroupVersion(dep.APIVersion); err != nil {
+				errs = append(errs, field.Invalid(depPath.Child("apiVersion"), dep.APIVersion, err.Error()))
+			}
+			continue
+		}
+		ns := dep.Namespace
+		if ns == "" {
+			ns = k.GetNamespace()
+		}
+		if dep.Name == k.GetName() && ns == k.GetNamespace() {
+			errs = append(errs, field.Invalid(depPath, dep.Name,
+				"a Konfiguration may not depend on itself"))
+		}
+	}
+
+	if k.Spec.Inject != "" {
+		// The snippet is appended to the evaluated path, so parse it after a
+		// placeholder expression.
+		if _, err := jsonnet.SnippetToAST("inject", "{}"+k.GetInjectSnippet()); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("inject"), k.Spec.Inject, strings.TrimSpace(err.Error())))
+		}
+	}
+
+	return errs
+}
+
+// invalid returns the error rejecting the Konfiguration for the given errors, if any.
+func (k *Konfiguration) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Konfiguration").GroupKind(), k.GetName(), errs)
+}
+
+// newErrors returns the errors that are not in old.
+func newErrors(errs, old field.ErrorList) field.ErrorList {
+	var out field.ErrorList
+Errors:
+	for _, err := range errs {
+		for _, o := range old {
+			if err.Type == o.Type && err.Field == o.Field && reflect.DeepEqual(err.BadValue, o.BadValue) {
+				continue Errors
+			}
+		}
+		out = append(out, err)
+	}
+	return out
+}
+
+var (
+	scpLikeURLRegex   = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/]`)
+	commitRegex       = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+	sha256DigestRegex = regexp.MustCompile(`^(sha256:)?[0-9a-f]{64}$`)
+)
+
+func validateGit(path *field.Path, git *GitSource) field.ErrorList {
+	var errs field.ErrorList
+	if !isGitURL(git.URL) {
+		errs = append(errs, field.Invalid(path.Child("url"), git.URL, "must be an HTTP(S) or SSH URL"))
+	}
+	if ref := git.Ref; ref != nil {
+		set := 0
+		for _, v := range []string{ref.Branch, ref.Tag, ref.Commit} {
+			if v != "" {
+				set++
+			}
+		}
+		if set > 1 {
+			errs = append(errs, field.Invalid(path.Child("ref"), ref, "at most one of branch, tag, and commit may be set"))
+		}
+		if ref.Commit != "" && !commitRegex.MatchString(ref.Commit) {
+			errs = append(errs, field.Invalid(path.Child("ref", "commit"), ref.Commit, "must be a full commit SHA"))
+		}
+	}
+	if git.SecretRef != nil && git.SecretRef.Name == "" {
+		errs = append(errs, field.Required(path.Child("secretRef", "name"), ""))
+	}
+	return errs
+}
+
+func validateCode(path *field.Path, code map[string]string) field.ErrorList {
+	var errs field.ErrorList
+	for k, v := range code {
+		if _, err := jsonnet.SnippetToAST(k, v); err != nil {
+			errs = append(errs, field.Invalid(path.Key(k), v, strings.TrimSpace(err.Error())))
+		}
+	}
+	return errs
+}
+
+func validateVarsObject(path *field.Path, raw []byte) field.ErrorList {
+	var vars map[string]interface{}
+	if err := json.Unmarshal(raw, &vars); err != nil {
+		return field.ErrorList{field.Invalid(path, string(raw), "must be a JSON object: "+err.Error())}
+	}
+	return nil
+}
+
+// isGitURL returns whether s is an HTTP(S) or SSH URL, or an scp-like SSH address.
+func isGitURL(s string) bool {
+	if scpLikeURLRegex.MatchString(s) {
+		return true
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "ssh") && u.Host != ""
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func isSchemeURL(s string, schemes ...string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestKonfiguration() *Konfiguration {
+	return &Konfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: KonfigurationSpec{
+			Interval:  metav1.Duration{Duration: time.Minute},
+			Path:      "config/main.jsonnet",
+			SourceRef: &meta.NamespacedObjectKindReference{Kind: "GitRepository", Name: "repo"},
+		},
+	}
+}
+
+func TestKonfigurationDefault(t *testing.T) {
+	k := newTestKonfiguration()
+	k.Default()
+	if k.Spec.SourceRef.Namespace != "default" {
+		t.Errorf("expected sourceRef namespace to default to 'default', got '%s'", k.Spec.SourceRef.Namespace)
+	}
+	if k.Spec.RetryInterval == nil || k.Spec.RetryInterval.Duration != time.Minute {
+		t.Errorf("expected retryInterval to default to the interval, got %v", k.Spec.RetryInterval)
+	}
+	if k.Spec.Timeout == nil || k.Spec.Timeout.Duration != time.Minute {
+		t.Errorf("expected timeout to default to the interval, got %v", k.Spec.Timeout)
+	}
+
+	// Values that are set are kept
+	k = newTestKonfiguration()
+	k.Spec.RetryInterval = &metav1.Duration{Duration: time.Second}
+	k.Spec.Timeout = &metav1.Duration{Duration: time.Hour}
+	k.Default()
+	if k.Spec.RetryInterval.Duration != time.Second || k.Spec.Timeout.Duration != time.Hour {
+		t.Errorf("expected retryInterval and timeout to be kept, got %s and %s", k.Spec.RetryInterval.Duration, k.Spec.Timeout.Duration)
+	}
+}
+
+func TestKonfigurationValidateUpdate(t *testing.T) {
+	// An object created before the self dependency rule, which is now invalid
+	old := newTestKonfiguration()
+	old.Spec.DependsOn = []DependencyReference{{Name: "test"}}
+
+	tests := []struct {
+		name    string
+		mutate  func(k *Konfiguration)
+		wantErr bool
+	}{
+		{"unchanged spec", func(k *Konfiguration) { k.Finalizers = nil }, false},
+		{"being deleted", func(k *Konfiguration) {
+			now := metav1.Now()
+			k.DeletionTimestamp = &now
+			k.Spec.Path = "http://"
+		}, false},
+		{"other field changed", func(k *Konfiguration) { k.Spec.Path = "config/other.jsonnet" }, false},
+		{"new error", func(k *Konfiguration) { k.Spec.JsonnetURLs = []string{"ftp://example.com"} }, true},
+		{"existing error kept", func(k *Konfiguration) {
+			k.Spec.DependsOn = append(k.Spec.DependsOn, DependencyReference{Name: "other"})
+		}, false},
+		{"invalid field changed", func(k *Konfiguration) { k.Spec.DependsOn[0].Kind = "Secret" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := old.DeepCopy()
+			tt.mutate(k)
+			if err := k.ValidateUpdate(old.DeepCopy()); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKonfigurationValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(k *Konfiguration)
+		wantErr bool
+	}{
+		{"valid", func(k *Konfiguration) {}, false},
+		{"valid http path", func(k *Konfiguration) {
+			k.Spec.SourceRef = nil
+			k.Spec.Path = "https://example.com/main.jsonnet"
+		}, false},
+		{"local path without source", func(k *Konfiguration) { k.Spec.SourceRef = nil }, true},
+		{"unsupported source kind", func(k *Konfiguration) { k.Spec.SourceRef.Kind = "ConfigMap" }, true},
+		{"oci repository source", func(k *Konfiguration) { k.Spec.SourceRef.Kind = "OCIRepository" }, false},
+		{"artifact source with apiVersion", func(k *Konfiguration) {
+			k.Spec.SourceRef.APIVersion = "example.com/v1"
+			k.Spec.SourceRef.Kind = "Bundle"
+		}, false},
+		{"git repository", func(k *Konfiguration) {
+			k.Spec.SourceRef = nil
+			k.Spec.Git = &GitSource{URL: "git@github.com:pelotech/jsonnet-controller.git", Ref: &GitReference{Tag: "v1.0.0"}}
+		}, false},
+		{"git repository and source", func(k *Konfiguration) {
+			k.Spec.Git = &GitSource{URL: "https://github.com/pelotech/jsonnet-controller"}
+		}, true},
+		{"git repository over a local path", func(k *Konfiguration) {
+			k.Spec.SourceRef = nil
+			k.Spec.Git = &GitSource{URL: "file:///var/lib/repo.git"}
+		}, true},
+		{"git repository with several refs", func(k *Konfiguration) {
+			k.Spec.SourceRef = nil
+			k.Spec.Git = &GitSource{URL: "https://github.com/pelotech/jsonnet-controller", Ref: &GitReference{Branch: "main", Commit: "abc"}}
+		}, true},
+		{"archive", func(k *Konfiguration) {
+			k.Spec.SourceRef = nil
+			k.Spec.Archive = &HTTPArchive{URL: "https://example.com/bundle.tar.gz", Checksum: "sha256:" + strings.Repeat("0", 64)}
+		}, false},
+		{"archive without checksum", func(k *Konfiguration) {
+			k.Spec.SourceRef = nil
+			k.Spec.Archive = &HTTPArchive{URL: "https://example.com/bundle.tar.gz"}
+		}, true},
+		{"import lock", func(k *Konfiguration) {
+			k.Spec.ImportLock = &ImportLock{File: "jsonnet.lock.json", Imports: map[string]string{"https://example.com/lib.libsonnet": strings.Repeat("0", 64)}}
+		}, false},
+		{"import lock with malformed digest", func(k *Konfiguration) {
+			k.Spec.ImportLock = &ImportLock{Imports: map[string]string{"https://example.com/lib.libsonnet": "abc"}}
+		}, true},
+		{"import lock file without source", func(k *Konfiguration) {
+			k.Spec.SourceRef = nil
+			k.Spec.Path = "https://example.com/main.jsonnet"
+			k.Spec.ImportLock = &ImportLock{File: "jsonnet.lock.json"}
+		}, true},
+		{"malformed jsonnet url", func(k *Konfiguration) { k.Spec.JsonnetURLs = []string{"example.com/lib"} }, true},
+		{"oci and configmap jsonnet urls", func(k *Konfiguration) {
+			k.Spec.JsonnetURLs = []string{"oci://ghcr.io/org/libs:v1/", "k8s://default/libs/"}
+		}, false},
+		{"kubeconfig and service account", func(k *Konfiguration) {
+			k.Spec.KubeConfig = &KubeConfig{}
+			k.Spec.KubeConfig.SecretRef.Name = "kubeconfig"
+			k.Spec.ServiceAccountName = "deployer"
+		}, true},
+		{"ext vars not an object", func(k *Konfiguration) {
+			k.Spec.Variables = &Variables{ExtVars: &extv1.JSON{Raw: []byte(`["a"]`)}}
+		}, true},
+		{"invalid ext code", func(k *Konfiguration) {
+			k.Spec.Variables = &Variables{ExtCode: map[string]string{"port": "{"}}
+		}, true},
+		{"depends on itself", func(k *Konfiguration) {
+			k.Spec.DependsOn = []DependencyReference{{Name: "test"}}
+		}, true},
+		{"depends on a kustomization of the same name", func(k *Konfiguration) {
+			k.Spec.DependsOn = []DependencyReference{{APIVersion: "kustomize.toolkit.fluxcd.io/v1beta1", Kind: "Kustomization", Name: "test"}}
+		}, false},
+		{"depends on a kind without apiVersion", func(k *Konfiguration) {
+			k.Spec.DependsOn = []DependencyReference{{Kind: "Deployment", Name: "app"}}
+		}, true},
+		{"valid inject", func(k *Konfiguration) { k.Spec.Inject = "+ { metadata+: { labels: {} } }" }, false},
+		{"invalid inject", func(k *Konfiguration) { k.Spec.Inject = "+ { metadata+: " }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKonfiguration()
+			tt.mutate(k)
+			if err := k.ValidateCreate(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KonfigurationPolicySpec defines what Konfigurations in the selected namespaces
+// are allowed to render.
+type KonfigurationPolicySpec struct {
+	// NamespaceSelector selects the namespaces whose Konfigurations this policy
+	// applies to. An empty selector matches all namespaces.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// AllowedKinds are the only kinds that may be rendered. A kind of '*' matches
+	// all kinds in the group, and the core group may be given as '' or 'core'.
+	// When empty, all kinds not explicitly denied are allowed.
+	// +optional
+	AllowedKinds []metav1.GroupKind `json:"allowedKinds,omitempty"`
+
+	// DeniedKinds are kinds that may not be rendered. A kind of '*' matches all
+	// kinds in the group. Denials take precedence over AllowedKinds.
+	// +optional
+	DeniedKinds []metav1.GroupKind `json:"deniedKinds,omitempty"`
+
+	// AllowedNamespaces are the namespaces that namespaced objects may be rendered
+	// into, in addition to the namespace of the Konfiguration. Glob patterns are
+	// supported. When empty, objects may be rendered into any namespace.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// AllowClusterScoped controls whether cluster-scoped objects may be rendered.
+	// +kubebuilder:default:=true
+	// +optional
+	AllowClusterScoped bool `json:"allowClusterScoped"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=konfigpolicy;konfigpolicies
+// +kubebuilder:printcolumn:name="Cluster Scoped",type="boolean",JSONPath=".spec.allowClusterScoped"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// KonfigurationPolicy is the Schema for the konfigurationpolicies API
+type KonfigurationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec KonfigurationPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KonfigurationPolicyList contains a list of KonfigurationPolicy
+type KonfigurationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KonfigurationPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KonfigurationPolicy{}, &KonfigurationPolicyList{})
+}
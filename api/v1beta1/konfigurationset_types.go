@@ -0,0 +1,203 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KonfigurationSetSpec defines the desired state of a KonfigurationSet
+type KonfigurationSetSpec struct {
+	// Generators produce the parameters used to render the template. Each
+	// set of parameters produced by a generator results in one Konfiguration.
+	// +required
+	Generators []KonfigurationSetGenerator `json:"generators"`
+
+	// Template is the Konfiguration to stamp out for every set of parameters.
+	// Parameters are referenced in string values as `{{name}}`.
+	// +required
+	Template KonfigurationTemplate `json:"template"`
+
+	// The interval at which to re-run the generators.
+	// +required
+	Interval metav1.Duration `json:"interval"`
+
+	// This flag tells the controller to suspend subsequent generations,
+	// it does not apply to the Konfigurations already generated. Defaults to false.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// KonfigurationSetGenerator describes a single generator. Exactly one of its
+// fields should be set.
+type KonfigurationSetGenerator struct {
+	// List generates parameters from a static list of elements.
+	// +optional
+	List *ListGenerator `json:"list,omitempty"`
+
+	// Clusters generates parameters from kubeconfig Secrets in the namespace
+	// of the KonfigurationSet.
+	// +optional
+	Clusters *ClustersGenerator `json:"clusters,omitempty"`
+
+	// GitDirectories generates parameters from the directories inside a source
+	// artifact that match a set of glob patterns.
+	// +optional
+	GitDirectories *GitDirectoriesGenerator `json:"gitDirectories,omitempty"`
+}
+
+// ListGenerator produces one set of parameters per element.
+type ListGenerator struct {
+	// Elements is the list of parameter sets.
+	// +required
+	Elements []map[string]string `json:"elements"`
+}
+
+// ClustersGenerator produces one set of parameters for every Secret matching
+// the selector. The Secrets are expected to hold a kubeconfig under the 'value'
+// key. The following parameters are produced:
+//  - name: the value of the 'jsonnet.io/cluster-name' label, or the Secret name
+//  - secretName: the name of the Secret
+//  - labels.<key>: every label on the Secret
+type ClustersGenerator struct {
+	// Selector is a label selector for kubeconfig Secrets. An empty selector
+	// matches all Secrets with the 'jsonnet.io/cluster-name' label.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Values are additional parameters to add to each generated set.
+	// +optional
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// GitDirectoriesGenerator produces one set of parameters for every directory in a
+// source artifact matching the configured patterns. The following parameters are
+// produced:
+//  - path: the path of the directory relative to the root of the artifact
+//  - path.basename: the last element of the path
+//  - path.basenameNormalized: the basename made safe for use in object names
+type GitDirectoriesGenerator struct {
+	// Reference of the source containing the directories.
+	// +required
+	SourceRef meta.NamespacedObjectKindReference `json:"sourceRef"`
+
+	// Directories are the glob patterns to match directories against.
+	// +required
+	Directories []DirectoryPattern `json:"directories"`
+}
+
+// DirectoryPattern is a glob pattern for matching directories.
+type DirectoryPattern struct {
+	// Path is the glob pattern, relative to the root of the artifact.
+	// +required
+	Path string `json:"path"`
+
+	// Exclude removes matching directories from the results instead of adding them.
+	// +optional
+	Exclude bool `json:"exclude,omitempty"`
+}
+
+// KonfigurationTemplate is the template used for generating Konfigurations.
+type KonfigurationTemplate struct {
+	// Metadata for the generated Konfigurations. The name is required and
+	// must be unique across all parameter sets.
+	// +required
+	Metadata KonfigurationTemplateMeta `json:"metadata"`
+
+	// Spec of the generated Konfigurations.
+	// +required
+	Spec KonfigurationSpec `json:"spec"`
+}
+
+// KonfigurationTemplateMeta holds the metadata of generated Konfigurations.
+type KonfigurationTemplateMeta struct {
+	// Name of the generated Konfiguration.
+	// +required
+	Name string `json:"name"`
+
+	// Labels to add to the generated Konfiguration.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations to add to the generated Konfiguration.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// KonfigurationSetStatus defines the observed state of a KonfigurationSet
+type KonfigurationSetStatus struct {
+	// ObservedGeneration is the last reconciled generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Konfigurations holds the status of every generated Konfiguration.
+	// +optional
+	Konfigurations []KonfigurationSetEntry `json:"konfigurations,omitempty"`
+}
+
+// KonfigurationSetEntry is the status of a single generated Konfiguration.
+type KonfigurationSetEntry struct {
+	// Name of the generated Konfiguration.
+	// +required
+	Name string `json:"name"`
+
+	// Ready is the status of the Ready condition of the Konfiguration.
+	// +required
+	Ready metav1.ConditionStatus `json:"ready"`
+
+	// Message is the message of the Ready condition of the Konfiguration.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastAppliedRevision is the last revision applied by the Konfiguration.
+	// +optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=konfigset;konfigsets
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// KonfigurationSet is the Schema for the konfigurationsets API
+type KonfigurationSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KonfigurationSetSpec   `json:"spec,omitempty"`
+	Status KonfigurationSetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KonfigurationSetList contains a list of KonfigurationSet
+type KonfigurationSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KonfigurationSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KonfigurationSet{}, &KonfigurationSetList{})
+}
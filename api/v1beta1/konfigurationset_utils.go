@@ -0,0 +1,74 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetNamespacedName returns the namespaced name for this KonfigurationSet.
+func (k *KonfigurationSet) GetNamespacedName() types.NamespacedName {
+	return types.NamespacedName{
+		Name:      k.GetName(),
+		Namespace: k.GetNamespace(),
+	}
+}
+
+// GetInterval returns the interval at which to run the generators.
+func (k *KonfigurationSet) GetInterval() time.Duration { return k.Spec.Interval.Duration }
+
+// IsSuspended returns whether the controller should stop generating Konfigurations.
+func (k *KonfigurationSet) IsSuspended() bool { return k.Spec.Suspend }
+
+// GetStatusConditions returns the status conditions for this resource.
+func (k *KonfigurationSet) GetStatusConditions() *[]metav1.Condition {
+	return &k.Status.Conditions
+}
+
+// SetReadiness sets the ReadyCondition and ObservedGeneration on the KonfigurationSet,
+// along with the status of the generated Konfigurations.
+func (k *KonfigurationSet) SetReadiness(ctx context.Context, cl client.Client, status metav1.ConditionStatus, reason, message string, entries []KonfigurationSetEntry) error {
+	meta.SetResourceCondition(k, meta.ReadyCondition, status, reason, trimString(message, MaxConditionMessageLength))
+	k.Status.ObservedGeneration = k.Generation
+	k.Status.Konfigurations = entries
+	return k.patchStatus(ctx, cl, k.Status)
+}
+
+// SetNotReady registers a failed generation attempt of this KonfigurationSet. The status
+// of previously generated Konfigurations is left untouched.
+func (k *KonfigurationSet) SetNotReady(ctx context.Context, cl client.Client, reason, message string) error {
+	return k.SetReadiness(ctx, cl, metav1.ConditionFalse, reason, message, k.Status.Konfigurations)
+}
+
+func (k *KonfigurationSet) patchStatus(ctx context.Context, cl client.Client, newStatus KonfigurationSetStatus) error {
+	var set KonfigurationSet
+	if err := cl.Get(ctx, k.GetNamespacedName(), &set); err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(set.DeepCopy())
+	set.Status = newStatus
+
+	return cl.Status().Patch(ctx, &set, patch)
+}
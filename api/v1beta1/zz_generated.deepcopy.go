@@ -23,12 +23,316 @@ package v1beta1
 
 import (
 	"github.com/fluxcd/pkg/apis/meta"
-	"github.com/fluxcd/pkg/runtime/dependency"
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildInputs) DeepCopyInto(out *BuildInputs) {
+	*out = *in
+	if in.Imports != nil {
+		in, out := &in.Imports, &out.Imports
+		*out = make([]ImportedFile, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildInputs.
+func (in *BuildInputs) DeepCopy() *BuildInputs {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildInputs)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClustersGenerator) DeepCopyInto(out *ClustersGenerator) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClustersGenerator.
+func (in *ClustersGenerator) DeepCopy() *ClustersGenerator {
+	if in == nil {
+		return nil
+	}
+	out := new(ClustersGenerator)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DependencyReference) DeepCopyInto(out *DependencyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DependencyReference.
+func (in *DependencyReference) DeepCopy() *DependencyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(DependencyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DirectoryPattern) DeepCopyInto(out *DirectoryPattern) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DirectoryPattern.
+func (in *DirectoryPattern) DeepCopy() *DirectoryPattern {
+	if in == nil {
+		return nil
+	}
+	out := new(DirectoryPattern)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitDirectoriesGenerator) DeepCopyInto(out *GitDirectoriesGenerator) {
+	*out = *in
+	out.SourceRef = in.SourceRef
+	if in.Directories != nil {
+		in, out := &in.Directories, &out.Directories
+		*out = make([]DirectoryPattern, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitDirectoriesGenerator.
+func (in *GitDirectoriesGenerator) DeepCopy() *GitDirectoriesGenerator {
+	if in == nil {
+		return nil
+	}
+	out := new(GitDirectoriesGenerator)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitReference) DeepCopyInto(out *GitReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitReference.
+func (in *GitReference) DeepCopy() *GitReference {
+	if in == nil {
+		return nil
+	}
+	out := new(GitReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitSource) DeepCopyInto(out *GitSource) {
+	*out = *in
+	if in.Ref != nil {
+		in, out := &in.Ref, &out.Ref
+		*out = new(GitReference)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitSource.
+func (in *GitSource) DeepCopy() *GitSource {
+	if in == nil {
+		return nil
+	}
+	out := new(GitSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPArchive) DeepCopyInto(out *HTTPArchive) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPArchive.
+func (in *HTTPArchive) DeepCopy() *HTTPArchive {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPArchive)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImportLock) DeepCopyInto(out *ImportLock) {
+	*out = *in
+	if in.Imports != nil {
+		in, out := &in.Imports, &out.Imports
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImportLock.
+func (in *ImportLock) DeepCopy() *ImportLock {
+	if in == nil {
+		return nil
+	}
+	out := new(ImportLock)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImportedFile) DeepCopyInto(out *ImportedFile) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImportedFile.
+func (in *ImportedFile) DeepCopy() *ImportedFile {
+	if in == nil {
+		return nil
+	}
+	out := new(ImportedFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JsonnetLibrary) DeepCopyInto(out *JsonnetLibrary) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JsonnetLibrary.
+func (in *JsonnetLibrary) DeepCopy() *JsonnetLibrary {
+	if in == nil {
+		return nil
+	}
+	out := new(JsonnetLibrary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *JsonnetLibrary) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JsonnetLibraryList) DeepCopyInto(out *JsonnetLibraryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]JsonnetLibrary, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JsonnetLibraryList.
+func (in *JsonnetLibraryList) DeepCopy() *JsonnetLibraryList {
+	if in == nil {
+		return nil
+	}
+	out := new(JsonnetLibraryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *JsonnetLibraryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JsonnetLibrarySpec) DeepCopyInto(out *JsonnetLibrarySpec) {
+	*out = *in
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.SourceRef != nil {
+		in, out := &in.SourceRef, &out.SourceRef
+		*out = new(meta.NamespacedObjectKindReference)
+		**out = **in
+	}
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JsonnetLibrarySpec.
+func (in *JsonnetLibrarySpec) DeepCopy() *JsonnetLibrarySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(JsonnetLibrarySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JsonnetLibraryStatus) DeepCopyInto(out *JsonnetLibraryStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JsonnetLibraryStatus.
+func (in *JsonnetLibraryStatus) DeepCopy() *JsonnetLibraryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(JsonnetLibraryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Konfiguration) DeepCopyInto(out *Konfiguration) {
 	*out = *in
@@ -88,12 +392,260 @@ func (in *KonfigurationList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationPolicy) DeepCopyInto(out *KonfigurationPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationPolicy.
+func (in *KonfigurationPolicy) DeepCopy() *KonfigurationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KonfigurationPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationPolicyList) DeepCopyInto(out *KonfigurationPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KonfigurationPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationPolicyList.
+func (in *KonfigurationPolicyList) DeepCopy() *KonfigurationPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KonfigurationPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationPolicySpec) DeepCopyInto(out *KonfigurationPolicySpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedKinds != nil {
+		in, out := &in.AllowedKinds, &out.AllowedKinds
+		*out = make([]v1.GroupKind, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedKinds != nil {
+		in, out := &in.DeniedKinds, &out.DeniedKinds
+		*out = make([]v1.GroupKind, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationPolicySpec.
+func (in *KonfigurationPolicySpec) DeepCopy() *KonfigurationPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationSet) DeepCopyInto(out *KonfigurationSet) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationSet.
+func (in *KonfigurationSet) DeepCopy() *KonfigurationSet {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationSet)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KonfigurationSet) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationSetEntry) DeepCopyInto(out *KonfigurationSetEntry) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationSetEntry.
+func (in *KonfigurationSetEntry) DeepCopy() *KonfigurationSetEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationSetEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationSetGenerator) DeepCopyInto(out *KonfigurationSetGenerator) {
+	*out = *in
+	if in.List != nil {
+		in, out := &in.List, &out.List
+		*out = new(ListGenerator)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = new(ClustersGenerator)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.GitDirectories != nil {
+		in, out := &in.GitDirectories, &out.GitDirectories
+		*out = new(GitDirectoriesGenerator)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationSetGenerator.
+func (in *KonfigurationSetGenerator) DeepCopy() *KonfigurationSetGenerator {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationSetGenerator)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationSetList) DeepCopyInto(out *KonfigurationSetList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KonfigurationSet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationSetList.
+func (in *KonfigurationSetList) DeepCopy() *KonfigurationSetList {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationSetList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KonfigurationSetList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationSetSpec) DeepCopyInto(out *KonfigurationSetSpec) {
+	*out = *in
+	if in.Generators != nil {
+		in, out := &in.Generators, &out.Generators
+		*out = make([]KonfigurationSetGenerator, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Template.DeepCopyInto(&out.Template)
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationSetSpec.
+func (in *KonfigurationSetSpec) DeepCopy() *KonfigurationSetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationSetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationSetStatus) DeepCopyInto(out *KonfigurationSetStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Konfigurations != nil {
+		in, out := &in.Konfigurations, &out.Konfigurations
+		*out = make([]KonfigurationSetEntry, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationSetStatus.
+func (in *KonfigurationSetStatus) DeepCopy() *KonfigurationSetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationSetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KonfigurationSpec) DeepCopyInto(out *KonfigurationSpec) {
 	*out = *in
 	if in.DependsOn != nil {
 		in, out := &in.DependsOn, &out.DependsOn
-		*out = make([]dependency.CrossNamespaceDependencyReference, len(*in))
+		*out = make([]DependencyReference, len(*in))
 		copy(*out, *in)
 	}
 	out.Interval = in.Interval
@@ -117,6 +669,11 @@ func (in *KonfigurationSpec) DeepCopyInto(out *KonfigurationSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ImportLock != nil {
+		in, out := &in.ImportLock, &out.ImportLock
+		*out = new(ImportLock)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Variables != nil {
 		in, out := &in.Variables, &out.Variables
 		*out = new(Variables)
@@ -127,6 +684,16 @@ func (in *KonfigurationSpec) DeepCopyInto(out *KonfigurationSpec) {
 		*out = new(meta.NamespacedObjectKindReference)
 		**out = **in
 	}
+	if in.Archive != nil {
+		in, out := &in.Archive, &out.Archive
+		*out = new(HTTPArchive)
+		**out = **in
+	}
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		*out = new(GitSource)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.HealthChecks != nil {
 		in, out := &in.HealthChecks, &out.HealthChecks
 		*out = make([]meta.NamespacedObjectKindReference, len(*in))
@@ -164,6 +731,31 @@ func (in *KonfigurationStatus) DeepCopyInto(out *KonfigurationStatus) {
 		*out = new(Snapshot)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Outputs != nil {
+		in, out := &in.Outputs, &out.Outputs
+		*out = new(apiextensionsv1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Inputs != nil {
+		in, out := &in.Inputs, &out.Inputs
+		*out = new(BuildInputs)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RemoteImports != nil {
+		in, out := &in.RemoteImports, &out.RemoteImports
+		*out = make([]RemoteImport, len(*in))
+		copy(*out, *in)
+	}
+	if in.Libraries != nil {
+		in, out := &in.Libraries, &out.Libraries
+		*out = make([]LibraryImport, len(*in))
+		copy(*out, *in)
+	}
+	if in.Lookups != nil {
+		in, out := &in.Lookups, &out.Lookups
+		*out = make([]ObjectLookup, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationStatus.
@@ -176,6 +768,52 @@ func (in *KonfigurationStatus) DeepCopy() *KonfigurationStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationTemplate) DeepCopyInto(out *KonfigurationTemplate) {
+	*out = *in
+	in.Metadata.DeepCopyInto(&out.Metadata)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationTemplate.
+func (in *KonfigurationTemplate) DeepCopy() *KonfigurationTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KonfigurationTemplateMeta) DeepCopyInto(out *KonfigurationTemplateMeta) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KonfigurationTemplateMeta.
+func (in *KonfigurationTemplateMeta) DeepCopy() *KonfigurationTemplateMeta {
+	if in == nil {
+		return nil
+	}
+	out := new(KonfigurationTemplateMeta)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeConfig) DeepCopyInto(out *KubeConfig) {
 	*out = *in
@@ -192,6 +830,79 @@ func (in *KubeConfig) DeepCopy() *KubeConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LibraryImport) DeepCopyInto(out *LibraryImport) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LibraryImport.
+func (in *LibraryImport) DeepCopy() *LibraryImport {
+	if in == nil {
+		return nil
+	}
+	out := new(LibraryImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ListGenerator) DeepCopyInto(out *ListGenerator) {
+	*out = *in
+	if in.Elements != nil {
+		in, out := &in.Elements, &out.Elements
+		*out = make([]map[string]string, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = make(map[string]string, len(*in))
+				for key, val := range *in {
+					(*out)[key] = val
+				}
+			}
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListGenerator.
+func (in *ListGenerator) DeepCopy() *ListGenerator {
+	if in == nil {
+		return nil
+	}
+	out := new(ListGenerator)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectLookup) DeepCopyInto(out *ObjectLookup) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectLookup.
+func (in *ObjectLookup) DeepCopy() *ObjectLookup {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectLookup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemoteImport) DeepCopyInto(out *RemoteImport) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteImport.
+func (in *RemoteImport) DeepCopy() *RemoteImport {
+	if in == nil {
+		return nil
+	}
+	out := new(RemoteImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Snapshot) DeepCopyInto(out *Snapshot) {
 	*out = *in
@@ -0,0 +1,49 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// artifactSourceKey returns the index value for an artifact source of the given kind.
+func artifactSourceKey(gk schema.GroupKind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gk.String(), namespace, name)
+}
+
+// artifactSourceWatches returns an object to watch for each of the given artifact
+// source kinds. Kinds that are not served by the cluster are skipped, Konfigurations
+// referencing them are only reconciled at their interval.
+func artifactSourceWatches(log logr.Logger, mgr ctrl.Manager, kinds []schema.GroupVersionKind) []client.Object {
+	objs := make([]client.Object, 0, len(kinds))
+	for _, gvk := range kinds {
+		if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			log.Info("Not watching artifact source kind, it is not served by the cluster", "kind", gvk.String(), "error", err.Error())
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		objs = append(objs, obj)
+	}
+	return objs
+}
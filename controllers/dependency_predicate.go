@@ -0,0 +1,77 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// DependencyReadyPredicate is a predicate that determines if a Konfiguration
+// became ready, was applied at a new revision, or published new outputs, so its
+// dependants can proceed.
+// It also passes changes to dependsOn, which may break a dependency cycle.
+type DependencyReadyPredicate struct {
+	predicate.Funcs
+}
+
+// Create implements the predicate interface.
+func (DependencyReadyPredicate) Create(e event.CreateEvent) bool { return false }
+
+// Delete implements the predicate interface.
+func (DependencyReadyPredicate) Delete(e event.DeleteEvent) bool { return false }
+
+// Generic implements the predicate interface.
+func (DependencyReadyPredicate) Generic(e event.GenericEvent) bool { return false }
+
+// Update implements the predicate interface.
+func (DependencyReadyPredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+
+	oldKonfig, ok := e.ObjectOld.(*konfigurationv1.Konfiguration)
+	if !ok {
+		return false
+	}
+
+	newKonfig, ok := e.ObjectNew.(*konfigurationv1.Konfiguration)
+	if !ok {
+		return false
+	}
+
+	if apimeta.IsStatusConditionTrue(newKonfig.Status.Conditions, meta.ReadyCondition) {
+		if !apimeta.IsStatusConditionTrue(oldKonfig.Status.Conditions, meta.ReadyCondition) {
+			return true
+		}
+		if oldKonfig.Status.LastAppliedRevision != newKonfig.Status.LastAppliedRevision {
+			return true
+		}
+		if !reflect.DeepEqual(oldKonfig.Status.Outputs, newKonfig.Status.Outputs) {
+			return true
+		}
+	}
+
+	return apimeta.IsStatusConditionTrue(oldKonfig.Status.Conditions, meta.StalledCondition) &&
+		oldKonfig.GetGeneration() != newKonfig.GetGeneration()
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/predicates"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kuberecorder "k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/go-retryablehttp"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
+)
+
+// JsonnetLibraryReconciler reconciles a JsonnetLibrary object
+type JsonnetLibraryReconciler struct {
+	client.Client
+	Scheme        *runtime.Scheme
+	EventRecorder kuberecorder.EventRecorder
+
+	files *libraryFiles
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *JsonnetLibraryReconciler) SetupWithManager(log logr.Logger, mgr ctrl.Manager, opts *ReconcilerOptions) error {
+	// Set up an http client for fetching artifacts
+	httpClient := retryablehttp.NewClient()
+	httpClient.RetryWaitMin = 5 * time.Second
+	httpClient.RetryWaitMax = 30 * time.Second
+	httpClient.RetryMax = opts.HTTPRetryMax
+	httpClient.Logger = nil
+	r.files = newLibraryFiles(mgr, &artifacts.Fetcher{HTTPClient: httpClient, MaxSize: opts.ArtifactMaxSize}, opts)
+
+	// Index the JsonnetLibraries by the library and version they publish.
+	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.JsonnetLibrary{}, konfigurationv1.LibraryVersionIndexKey,
+		indexByLibraryVersion); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the JsonnetLibraries by the sources they publish.
+	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.JsonnetLibrary{}, konfigurationv1.LibrarySourceIndexKey,
+		indexLibraryBySource); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// ConfigMaps are not watched, which would require reading every ConfigMap in the
+	// cluster. Konfigurations finding that the files of a library changed request it
+	// to be reconciled again instead.
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&konfigurationv1.JsonnetLibrary{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}),
+		)).
+		Watches(
+			&source.Kind{Type: &sourcev1.GitRepository{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForSourceChangeOf(sourcev1.GitRepositoryKind)),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &sourcev1.Bucket{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForSourceChangeOf(sourcev1.BucketKind)),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &sourcev1.HelmChart{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForSourceChangeOf(sourcev1.HelmChartKind)),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		).
+		Watches(&source.Channel{Source: opts.libraryResyncChannel()}, &handler.EnqueueRequestForObject{})
+	for _, obj := range artifactSourceWatches(log, mgr, opts.ArtifactSourceKinds) {
+		b = b.Watches(
+			&source.Kind{Type: obj},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForArtifactSourceChange),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		)
+	}
+	return b.WithOptions(
+		controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles},
+	).Complete(r)
+}
+
+// +kubebuilder:rbac:groups=jsonnet.io,resources=jsonnetlibraries,verbs=get;list;watch
+// +kubebuilder:rbac:groups=jsonnet.io,resources=jsonnetlibraries/status,verbs=get;update;patch
+
+// Reconcile reads the files published by a JsonnetLibrary and records their revision,
+// which triggers a reconcile of the Konfigurations importing it when it changes.
+func (r *JsonnetLibraryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.FromContext(ctx)
+
+	lib := &konfigurationv1.JsonnetLibrary{}
+	if err := r.Client.Get(ctx, req.NamespacedName, lib); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !lib.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if err := lib.Validate(); err != nil {
+		if statusErr := lib.SetNotReady(ctx, r.Client, konfigurationv1.ValidationFailedReason, err.Error()); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update JsonnetLibrary status")
+		}
+		return ctrl.Result{}, nil
+	}
+
+	contents, err := r.files.open(ctx, lib)
+	if err == nil {
+		defer contents.release()
+	}
+	var revision string
+	if err == nil {
+		revision, err = contents.revision(lib.Spec.Version)
+	}
+	if err != nil {
+		reqLogger.Error(err, "Failed to read library files")
+		r.EventRecorder.Event(lib, corev1.EventTypeWarning, konfigurationv1.ArtifactFailedReason, err.Error())
+		if statusErr := lib.SetNotReady(ctx, r.Client, konfigurationv1.ArtifactFailedReason, err.Error()); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update JsonnetLibrary status")
+		}
+		return ctrl.Result{RequeueAfter: lib.GetInterval()}, nil
+	}
+
+	if revision != lib.Status.Revision {
+		r.EventRecorder.Event(lib, corev1.EventTypeNormal, meta.ReconciliationSucceededReason, "Published revision: "+revision)
+	}
+	if err := lib.SetReady(ctx, r.Client, revision, "Published revision: "+revision); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+	reqLogger.Info(fmt.Sprintf("Reconcile finished, next run in %s", lib.GetInterval().String()), "Revision", revision)
+	return ctrl.Result{RequeueAfter: lib.GetInterval()}, nil
+}
+
+func indexByLibraryVersion(o client.Object) []string {
+	lib, ok := o.(*konfigurationv1.JsonnetLibrary)
+	if !ok {
+		panic(fmt.Sprintf("Expected a JsonnetLibrary, got %T", o))
+	}
+	return []string{lib.GetLibrary() + "/" + lib.Spec.Version}
+}
+
+func indexLibraryBySource(o client.Object) []string {
+	lib, ok := o.(*konfigurationv1.JsonnetLibrary)
+	if !ok {
+		panic(fmt.Sprintf("Expected a JsonnetLibrary, got %T", o))
+	}
+	ref := lib.Spec.SourceRef
+	if ref == nil {
+		return nil
+	}
+	namespace := lib.GetNamespace()
+	if ref.Namespace != "" {
+		namespace = ref.Namespace
+	}
+	if gvk, generic, err := konfigurationv1.ArtifactSourceGVK(ref); err == nil && generic {
+		return []string{artifactSourceKey(gvk.GroupKind(), namespace, ref.Name)}
+	}
+	return []string{fmt.Sprintf("%s/%s/%s", ref.Kind, namespace, ref.Name)}
+}
+
+func (r *JsonnetLibraryReconciler) requestsForSourceChangeOf(kind string) func(obj client.Object) []reconcile.Request {
+	return func(obj client.Object) []reconcile.Request {
+		return r.requestsForSource(fmt.Sprintf("%s/%s/%s", kind, obj.GetNamespace(), obj.GetName()))
+	}
+}
+
+// requestsForArtifactSourceChange returns requests for the JsonnetLibraries publishing
+// the given artifact source, watched as an unstructured object.
+func (r *JsonnetLibraryReconciler) requestsForArtifactSourceChange(obj client.Object) []reconcile.Request {
+	return r.requestsForSource(artifactSourceKey(obj.GetObjectKind().GroupVersionKind().GroupKind(), obj.GetNamespace(), obj.GetName()))
+}
+
+func (r *JsonnetLibraryReconciler) requestsForSource(key string) []reconcile.Request {
+	var list konfigurationv1.JsonnetLibraryList
+	if err := r.List(context.Background(), &list, client.MatchingFields{
+		konfigurationv1.LibrarySourceIndexKey: key,
+	}); err != nil {
+		return nil
+	}
+	reqs := make([]reconcile.Request, len(list.Items))
+	for i := range list.Items {
+		reqs[i].NamespacedName = list.Items[i].GetNamespacedName()
+	}
+	return reqs
+}
+
+// libraryFiles reads the files published by JsonnetLibraries.
+type libraryFiles struct {
+	client    client.Client
+	apiReader client.Reader
+	fetcher   *artifacts.Fetcher
+	artifacts *artifacts.Cache
+	access    AccessOptions
+}
+
+func newLibraryFiles(mgr ctrl.Manager, fetcher *artifacts.Fetcher, opts *ReconcilerOptions) *libraryFiles {
+	return &libraryFiles{
+		client:    mgr.GetClient(),
+		apiReader: mgr.GetAPIReader(),
+		fetcher:   fetcher,
+		artifacts: opts.ArtifactCache,
+		access:    opts.Access,
+	}
+}
+
+// libraryContents are the files published by a JsonnetLibrary, either held in memory
+// or extracted to a directory.
+type libraryContents struct {
+	files   map[string][]byte
+	dir     string
+	release func()
+}
+
+// open returns the files currently published by the library. ConfigMaps are read from
+// the API server rather than through the cache, which would watch every ConfigMap in
+// the cluster.
+func (f *libraryFiles) open(ctx context.Context, lib *konfigurationv1.JsonnetLibrary) (*libraryContents, error) {
+	switch {
+	case lib.Spec.ConfigMapRef != nil:
+		var cm corev1.ConfigMap
+		key := types.NamespacedName{Namespace: lib.GetNamespace(), Name: lib.Spec.ConfigMapRef.Name}
+		if err := f.apiReader.Get(ctx, key, &cm); err != nil {
+			return nil, fmt.Errorf("failed to get configmap '%s': %w", key, err)
+		}
+		files := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+		for k, v := range cm.Data {
+			files[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			files[k] = v
+		}
+		return &libraryContents{files: files, release: func() {}}, nil
+
+	case lib.Spec.SourceRef != nil:
+		sourceRef := lib.GetSourceRef()
+		if err := f.access.checkSourceNamespace(sourceRef.Kind, sourceRef.Namespace, sourceRef.Name, lib.GetNamespace()); err != nil {
+			return nil, err
+		}
+		source, err := konfigurationv1.GetSource(ctx, f.client, sourceRef)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve source '%s/%s/%s': %w", sourceRef.Kind, sourceRef.Namespace, sourceRef.Name, err)
+		}
+		artifact := source.GetArtifact()
+		if artifact == nil {
+			return nil, errors.New("source is not ready, artifact not found")
+		}
+		root, release, err := f.artifacts.Get(ctx, f.fetcher, artifact)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := securejoin.SecureJoin(root, lib.Spec.Path)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		return &libraryContents{dir: dir, release: release}, nil
+
+	default:
+		files := make(map[string][]byte, len(lib.Spec.Files))
+		for k, v := range lib.Spec.Files {
+			files[path.Clean(k)] = []byte(v)
+		}
+		return &libraryContents{files: files, release: func() {}}, nil
+	}
+}
+
+// read returns the contents of the file at the given path, or an error wrapping
+// os.ErrNotExist.
+func (c *libraryContents) read(p string) ([]byte, error) {
+	if c.files == nil {
+		file, err := securejoin.SecureJoin(c.dir, p)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(file)
+	}
+	data, ok := c.files[path.Clean(p)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", p, os.ErrNotExist)
+	}
+	return data, nil
+}
+
+// revision returns the revision of the files at the given version, in the form
+// <version>@sha256:<digest>. The digest covers the path and contents of every file.
+func (c *libraryContents) revision(version string) (string, error) {
+	sums := make(map[string][32]byte)
+	if c.files != nil {
+		for p, data := range c.files {
+			sums[p] = sha256.Sum256(data)
+		}
+	} else {
+		err := filepath.Walk(c.dir, func(file string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(c.dir, file)
+			if err != nil {
+				return err
+			}
+			sums[filepath.ToSlash(rel)] = sha256.Sum256(data)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(sums) == 0 {
+		return "", errors.New("the library publishes no files")
+	}
+
+	paths := make([]string, 0, len(sums))
+	for p := range sums {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, p := range paths {
+		fmt.Fprintf(&b, "%x  %s\n", sums[p], p)
+	}
+	return fmt.Sprintf("%s@sha256:%x", version, sha256.Sum256([]byte(b.String()))), nil
+}
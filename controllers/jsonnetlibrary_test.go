@@ -0,0 +1,342 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// indexedClient filters lists by the exact field selectors of the given indexers, which
+// the fake client ignores.
+type indexedClient struct {
+	client.Client
+	indexers map[string]client.IndexerFunc
+}
+
+// List implements client.Reader.
+func (c *indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if err := c.Client.List(ctx, list, opts...); err != nil || listOpts.FieldSelector == nil {
+		return err
+	}
+	items, err := apimeta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	var filtered []runtime.Object
+	for _, item := range items {
+		keep := true
+		for field, indexer := range c.indexers {
+			value, ok := listOpts.FieldSelector.RequiresExactMatch(field)
+			if !ok {
+				continue
+			}
+			matched := false
+			for _, v := range indexer(item.(client.Object)) {
+				matched = matched || v == value
+			}
+			keep = keep && matched
+		}
+		if keep {
+			filtered = append(filtered, item)
+		}
+	}
+	return apimeta.SetList(list, filtered)
+}
+
+// newTestLibrary returns a JsonnetLibrary publishing the given files, ready at the
+// revision of its files.
+func newTestLibrary(t *testing.T, namespace, name, version string, files map[string]string) *konfigurationv1.JsonnetLibrary {
+	t.Helper()
+	lib := &konfigurationv1.JsonnetLibrary{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: 1},
+	}
+	lib.Spec.Library = "platform"
+	lib.Spec.Version = version
+	lib.Spec.Files = files
+	revision, err := (&libraryContents{files: libraryFilesBytes(files)}).revision(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib.Status.Revision = revision
+	lib.Status.ObservedGeneration = 1
+	lib.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue}}
+	return lib
+}
+
+func libraryFilesBytes(files map[string]string) map[string][]byte {
+	out := make(map[string][]byte, len(files))
+	for k, v := range files {
+		out[k] = []byte(v)
+	}
+	return out
+}
+
+func TestLibraryContents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"main.libsonnet": "{}", "lib/util.libsonnet": "{ a: 1 }"}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inMemory := &libraryContents{files: libraryFilesBytes(files)}
+	onDisk := &libraryContents{dir: dir}
+
+	for name, contents := range map[string]*libraryContents{"files": inMemory, "directory": onDisk} {
+		t.Run(name, func(t *testing.T) {
+			for _, p := range []string{"lib/util.libsonnet", "./lib/util.libsonnet", "lib/../lib/util.libsonnet"} {
+				if data, err := contents.read(p); err != nil || string(data) != "{ a: 1 }" {
+					t.Errorf("expected to read %s, got %q, %v", p, data, err)
+				}
+			}
+			if _, err := contents.read("missing.libsonnet"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected a missing file to not exist, got %v", err)
+			}
+			// Paths are confined to the library
+			if _, err := contents.read("../../etc/passwd"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected paths outside of the library to not exist, got %v", err)
+			}
+		})
+	}
+
+	// The revision only depends on the version, paths and contents
+	memRevision, err := inMemory.revision("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskRevision, err := onDisk.revision("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memRevision != diskRevision || !strings.HasPrefix(memRevision, "v1@sha256:") {
+		t.Errorf("expected identical revisions, got %s and %s", memRevision, diskRevision)
+	}
+	for name, files := range map[string]map[string]string{
+		"changed contents": {"main.libsonnet": "{}", "lib/util.libsonnet": "{ a: 2 }"},
+		"renamed file":     {"main.libsonnet": "{}", "lib/other.libsonnet": "{ a: 1 }"},
+	} {
+		revision, err := (&libraryContents{files: libraryFilesBytes(files)}).revision("v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revision == memRevision {
+			t.Errorf("expected a new revision for %s", name)
+		}
+	}
+	if _, err := (&libraryContents{files: map[string][]byte{}}).revision("v1"); err == nil {
+		t.Error("expected an empty library to be refused")
+	}
+}
+
+func TestFindLibrary(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{"main.libsonnet": "{}"}
+	notReady := newTestLibrary(t, "team", "not-ready", "v3", files)
+	notReady.Status.Conditions[0].Status = metav1.ConditionFalse
+	stale := newTestLibrary(t, "team", "stale", "v4", files)
+	stale.Generation = 2
+	objs := []client.Object{
+		newTestLibrary(t, "team", "platform-v1", "v1", files),
+		newTestLibrary(t, "shared", "platform-v1", "v1", files),
+		newTestLibrary(t, "shared", "platform-v2", "v2", files),
+		newTestLibrary(t, "team", "duplicate-a", "v5", files),
+		newTestLibrary(t, "team", "duplicate-b", "v5", files),
+		notReady,
+		stale,
+	}
+	r := &KonfigurationReconciler{
+		Client: &indexedClient{
+			Client:   fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).Build(),
+			indexers: map[string]client.IndexerFunc{konfigurationv1.LibraryVersionIndexKey: indexByLibraryVersion},
+		},
+		libraryNamespace: "shared",
+	}
+
+	tcs := []struct {
+		name, version string
+		expected      types.NamespacedName
+		errMsg        string
+	}{
+		{name: "own namespace first", version: "v1", expected: types.NamespacedName{Namespace: "team", Name: "platform-v1"}},
+		{name: "shared namespace", version: "v2", expected: types.NamespacedName{Namespace: "shared", Name: "platform-v2"}},
+		{name: "not ready", version: "v3", errMsg: "is not ready"},
+		{name: "generation not observed", version: "v4", errMsg: "is not ready"},
+		{name: "ambiguous", version: "v5", errMsg: "several JsonnetLibraries"},
+		{name: "missing", version: "v6", errMsg: "no JsonnetLibrary publishes version v6"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			lib, err := r.findLibrary(ctx, "team", "platform", tc.version)
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Errorf("expected an error containing %q, got %v", tc.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lib.GetNamespacedName() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, lib.GetNamespacedName())
+			}
+		})
+	}
+}
+
+func TestLibraryImporter(t *testing.T) {
+	ctx := context.Background()
+	lib := newTestLibrary(t, "default", "platform-v1", "v1", map[string]string{"main.libsonnet": "{}"})
+	changed := newTestLibrary(t, "default", "platform-v2", "v2", map[string]string{"main.libsonnet": "{}"})
+	// The files were edited since the revision was published
+	changed.Spec.Files["main.libsonnet"] = "{ a: 1 }"
+	r := &KonfigurationReconciler{
+		Client: &indexedClient{
+			Client:   fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(lib, changed).Build(),
+			indexers: map[string]client.IndexerFunc{konfigurationv1.LibraryVersionIndexKey: indexByLibraryVersion},
+		},
+		libraries:      &libraryFiles{},
+		libraryResyncs: make(chan event.GenericEvent, 1),
+	}
+
+	libraries := make(map[types.NamespacedName]string)
+	f, release := r.libraryImporter(newDependentKonfiguration("a"), libraries)()
+	defer release()
+
+	u, _ := url.Parse("lib://platform/v1/main.libsonnet")
+	if data, err := f(ctx, u); err != nil || string(data) != "{}" {
+		t.Fatalf("expected the library file, got %q, %v", data, err)
+	}
+	expected := map[types.NamespacedName]string{lib.GetNamespacedName(): lib.Status.Revision}
+	if !reflect.DeepEqual(libraries, expected) {
+		t.Errorf("expected the library revision to be recorded, got %v", libraries)
+	}
+
+	u, _ = url.Parse("lib://platform/v2/main.libsonnet")
+	if _, err := f(ctx, u); err == nil || !strings.Contains(err.Error(), "changed since it published revision") {
+		t.Errorf("expected files differing from the published revision to be refused, got %v", err)
+	}
+	select {
+	case e := <-r.libraryResyncs:
+		if e.Object.GetName() != changed.GetName() {
+			t.Errorf("expected %s to be reconciled again, got %s", changed.GetName(), e.Object.GetName())
+		}
+	default:
+		t.Error("expected the changed library to be reconciled again")
+	}
+
+	u, _ = url.Parse("lib://platform/main.libsonnet")
+	if _, err := f(ctx, u); err == nil || !strings.Contains(err.Error(), "is not of the form") {
+		t.Errorf("expected an invalid URL to be refused, got %v", err)
+	}
+}
+
+func TestRequestsForLibraryChange(t *testing.T) {
+	lib := newTestLibrary(t, "shared", "platform-v1", "v1", map[string]string{"main.libsonnet": "{}"})
+	imported := func(name, revision string) *konfigurationv1.Konfiguration {
+		konfig := newDependentKonfiguration(name)
+		konfig.Status.Libraries = []konfigurationv1.LibraryImport{{Namespace: "shared", Name: "platform-v1", Revision: revision}}
+		return konfig
+	}
+	r := &KonfigurationReconciler{}
+	r.Client = &indexedClient{
+		Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+			imported("outdated", "v1@sha256:old"),
+			imported("current", lib.Status.Revision),
+			newDependentKonfiguration("unrelated"),
+		).Build(),
+		indexers: map[string]client.IndexerFunc{konfigurationv1.LibraryIndexKey: r.indexByLibrary},
+	}
+
+	expected := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "outdated"}}}
+	if reqs := r.requestsForLibraryChange(lib); !reflect.DeepEqual(reqs, expected) {
+		t.Errorf("expected %v, got %v", expected, reqs)
+	}
+}
+
+func TestRequestsForLibrarySourceChange(t *testing.T) {
+	withSource := func(name string, ref *meta.NamespacedObjectKindReference) *konfigurationv1.JsonnetLibrary {
+		lib := newTestLibrary(t, "default", name, "v1", map[string]string{"main.libsonnet": "{}"})
+		lib.Spec.Files = nil
+		lib.Spec.SourceRef = ref
+		return lib
+	}
+	git := withSource("git", &meta.NamespacedObjectKindReference{Kind: sourcev1.GitRepositoryKind, Name: "libs"})
+	other := withSource("other", &meta.NamespacedObjectKindReference{Kind: sourcev1.GitRepositoryKind, Name: "libs", Namespace: "other"})
+	oci := withSource("oci", &meta.NamespacedObjectKindReference{APIVersion: "source.toolkit.fluxcd.io/v1beta2", Kind: "OCIRepository", Name: "libs"})
+	inline := newTestLibrary(t, "default", "inline", "v1", map[string]string{"main.libsonnet": "{}"})
+
+	r := &JsonnetLibraryReconciler{
+		Client: &indexedClient{
+			Client:   fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(git, other, oci, inline).Build(),
+			indexers: map[string]client.IndexerFunc{konfigurationv1.LibrarySourceIndexKey: indexLibraryBySource},
+		},
+	}
+
+	repo := &sourcev1.GitRepository{ObjectMeta: metav1.ObjectMeta{Name: "libs", Namespace: "default"}}
+	reqs := r.requestsForSourceChangeOf(sourcev1.GitRepositoryKind)(repo)
+	if len(reqs) != 1 || reqs[0].NamespacedName != git.GetNamespacedName() {
+		t.Errorf("expected a request for %s, got %v", git.GetNamespacedName(), reqs)
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(schema.GroupVersionKind{Group: "source.toolkit.fluxcd.io", Version: "v1beta2", Kind: "OCIRepository"})
+	u.SetNamespace("default")
+	u.SetName("libs")
+	reqs = r.requestsForArtifactSourceChange(u)
+	if len(reqs) != 1 || reqs[0].NamespacedName != oci.GetNamespacedName() {
+		t.Errorf("expected a request for %s, got %v", oci.GetNamespacedName(), reqs)
+	}
+
+	if keys := indexLibraryBySource(inline); len(keys) != 0 {
+		t.Errorf("expected libraries without a source to not be indexed, got %v", keys)
+	}
+	if git.Spec.SourceRef.Namespace != "" {
+		t.Error("expected indexing to leave the source reference unchanged")
+	}
+}
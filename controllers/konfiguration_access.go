@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/impersonation"
+	"github.com/pelotech/jsonnet-controller/pkg/permissions"
+	"github.com/pelotech/jsonnet-controller/pkg/policy"
+)
+
+// AccessOptions are the multi-tenancy restrictions the controller enforces on
+// Konfigurations.
+type AccessOptions struct {
+	// NoCrossNamespaceRefs denies references to sources, dependencies, and
+	// imported ConfigMaps in other namespaces.
+	NoCrossNamespaceRefs bool
+	// NoRemoteBases denies jsonnetURLs, HTTP(S) paths, archives, and remote imports.
+	NoRemoteBases bool
+	// RequireImportLock refuses remote imports that are not pinned by an import
+	// lock.
+	RequireImportLock bool
+	// DefaultServiceAccount is the service account to assume for Konfigurations
+	// that configure neither a serviceAccountName nor a kubeConfig.
+	DefaultServiceAccount string
+}
+
+// checkAccess returns an error describing the first restriction the given Konfiguration
+// violates, if any.
+func (o *AccessOptions) checkAccess(konfig *konfigurationv1.Konfiguration) error {
+	if o.NoCrossNamespaceRefs {
+		if sourceRef := konfig.GetSourceRef(); sourceRef != nil {
+			if err := o.checkSourceNamespace(sourceRef.Kind, sourceRef.Namespace, sourceRef.Name, konfig.GetNamespace()); err != nil {
+				return err
+			}
+		}
+		for _, dep := range konfig.Spec.DependsOn {
+			if dep.Namespace != "" && dep.Namespace != konfig.GetNamespace() {
+				return fmt.Errorf("cannot depend on %s, cross-namespace references have been disabled", dep.String())
+			}
+		}
+	}
+
+	if o.NoRemoteBases {
+		if urls := konfig.GetJsonnetURLs(); len(urls) > 0 {
+			return errors.New("jsonnetURLs are not allowed, remote bases have been disabled")
+		}
+		if archive := konfig.GetArchive(); archive != nil {
+			return fmt.Errorf("archive '%s' is not allowed, remote bases have been disabled", archive.URL)
+		}
+		if isRemotePath(konfig.GetPath()) {
+			return fmt.Errorf("path '%s' is not allowed, remote bases have been disabled", konfig.GetPath())
+		}
+	}
+
+	return nil
+}
+
+// checkSourceNamespace returns an error if the given source namespace is not allowed
+// for an object in the given namespace.
+func (o *AccessOptions) checkSourceNamespace(kind, namespace, name, objNamespace string) error {
+	if o.NoCrossNamespaceRefs && namespace != objNamespace {
+		return fmt.Errorf("cannot access %s '%s/%s', cross-namespace references have been disabled", kind, namespace, name)
+	}
+	return nil
+}
+
+// isRemotePath returns true if the given path is an HTTP(S) URL.
+func isRemotePath(path string) bool {
+	u, err := url.Parse(path)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// checkPolicies evaluates the KonfigurationPolicies applying to the given Konfiguration
+// against the objects it rendered.
+func (r *KonfigurationReconciler) checkPolicies(ctx context.Context, konfig *konfigurationv1.Konfiguration, restMapper meta.RESTMapper, objs []*unstructured.Unstructured) error {
+	policies, err := policy.ForNamespace(ctx, r.Client, konfig.GetNamespace())
+	if err != nil {
+		return err
+	}
+	return policy.Check(policies, konfig.GetNamespace(), restMapper, objs)
+}
+
+// checkPermissions verifies that the identity of the given client holds every permission
+// needed to apply the objects and prune the last applied snapshot. A *permissions.MissingError
+// listing all missing permissions is returned if it does not.
+func (r *KonfigurationReconciler) checkPermissions(ctx context.Context, konfig *konfigurationv1.Konfiguration, kubeClient impersonation.Client, objs []*unstructured.Unstructured) error {
+	perms := permissions.NewSet()
+	if err := perms.AddApply(kubeClient.RESTMapper(), objs, konfig.ForceCreate()); err != nil {
+		return err
+	}
+	if konfig.GCEnabled() {
+		if err := perms.AddPrune(kubeClient.RESTMapper(), konfig.Status.Snapshot); err != nil {
+			return err
+		}
+	}
+	missing, err := perms.Missing(ctx, kubeClient)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return &permissions.MissingError{Missing: missing}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+func TestCheckAccess(t *testing.T) {
+	archive := &konfigurationv1.HTTPArchive{URL: "https://example.com/app.tar.gz", Checksum: strings.Repeat("a", 64)}
+
+	tcs := []struct {
+		name   string
+		opts   AccessOptions
+		mutate func(*konfigurationv1.Konfiguration)
+		errMsg string
+	}{
+		{
+			name:   "no restrictions",
+			mutate: func(k *konfigurationv1.Konfiguration) { k.Spec.Archive = archive },
+		},
+		{
+			name:   "cross-namespace dependency",
+			opts:   AccessOptions{NoCrossNamespaceRefs: true},
+			mutate: func(k *konfigurationv1.Konfiguration) { k.Spec.DependsOn[0].Namespace = "other" },
+			errMsg: "cross-namespace references have been disabled",
+		},
+		{
+			name: "same-namespace dependency",
+			opts: AccessOptions{NoCrossNamespaceRefs: true},
+		},
+		{
+			name:   "jsonnetURLs",
+			opts:   AccessOptions{NoRemoteBases: true},
+			mutate: func(k *konfigurationv1.Konfiguration) { k.Spec.JsonnetURLs = []string{"https://example.com/lib/"} },
+			errMsg: "jsonnetURLs are not allowed",
+		},
+		{
+			name:   "HTTP(S) path",
+			opts:   AccessOptions{NoRemoteBases: true},
+			mutate: func(k *konfigurationv1.Konfiguration) { k.Spec.Path = "https://example.com/main.jsonnet" },
+			errMsg: "path 'https://example.com/main.jsonnet' is not allowed",
+		},
+		{
+			name:   "archive",
+			opts:   AccessOptions{NoRemoteBases: true},
+			mutate: func(k *konfigurationv1.Konfiguration) { k.Spec.Archive = archive },
+			errMsg: "archive 'https://example.com/app.tar.gz' is not allowed",
+		},
+		{
+			name:   "local path",
+			opts:   AccessOptions{NoRemoteBases: true},
+			mutate: func(k *konfigurationv1.Konfiguration) { k.Spec.Path = "main.jsonnet" },
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			konfig := newDependentKonfiguration("a", "b")
+			if tc.mutate != nil {
+				tc.mutate(konfig)
+			}
+			err := tc.opts.checkAccess(konfig)
+			if tc.errMsg == "" {
+				if err != nil {
+					t.Errorf("expected access to be allowed, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("expected an error containing %q, got %v", tc.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRemoteChangedDenied(t *testing.T) {
+	konfig := newDependentKonfiguration("a", "other/b")
+	konfig.Spec.Path = "https://example.com/main.jsonnet"
+	// Polling the path would fail, as the reconciler has no remote files
+	r := &KonfigurationReconciler{access: AccessOptions{NoCrossNamespaceRefs: true}}
+	changed, err := r.remoteChanged(context.Background(), konfig)
+	if err != nil || changed != "" {
+		t.Errorf("expected a denied Konfiguration to not be polled, got %q, %v", changed, err)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// errUnauthenticated is returned when a build request carries no valid bearer token.
+var errUnauthenticated = errors.New("a valid bearer token is required")
+
+// authenticateBuild validates the bearer token of a build request with a TokenReview
+// and returns the user it belongs to.
+func (r *KonfigurationReconciler) authenticateBuild(ctx context.Context, req *http.Request) (*authenticationv1.UserInfo, error) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errUnauthenticated
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if token == "" {
+		return nil, errUnauthenticated
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	if err := r.Client.Create(ctx, review); err != nil {
+		return nil, fmt.Errorf("failed to review token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, fmt.Errorf("%w: %s", errUnauthenticated, review.Status.Error)
+		}
+		return nil, errUnauthenticated
+	}
+	return &review.Status.User, nil
+}
+
+// authorizeBuild checks with SubjectAccessReviews that the user may get Konfigurations
+// in the namespace of the given Konfiguration, and assume the identity it would be
+// built with.
+func (r *KonfigurationReconciler) authorizeBuild(ctx context.Context, user *authenticationv1.UserInfo, konfig *konfigurationv1.Konfiguration) error {
+	checks := []authorizationv1.ResourceAttributes{{
+		Verb:      "get",
+		Group:     konfigurationv1.GroupVersion.Group,
+		Resource:  "konfigurations",
+		Namespace: konfig.GetNamespace(),
+	}}
+
+	if secretName := konfig.GetKubeConfigSecretName(); secretName != "" {
+		checks = append(checks, authorizationv1.ResourceAttributes{
+			Verb:      "get",
+			Resource:  "secrets",
+			Namespace: konfig.GetNamespace(),
+			Name:      secretName,
+		})
+	} else if sa := r.buildServiceAccount(konfig); sa != "" {
+		checks = append(checks, authorizationv1.ResourceAttributes{
+			Verb:      "impersonate",
+			Resource:  "serviceaccounts",
+			Namespace: konfig.GetNamespace(),
+			Name:      sa,
+		})
+	}
+
+	for i := range checks {
+		allowed, err := r.reviewAccess(ctx, user, &checks[i])
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("user '%s' may not %s %s in namespace '%s'",
+				user.Username, checks[i].Verb, describeAttributes(&checks[i]), konfig.GetNamespace())
+		}
+	}
+	return nil
+}
+
+// authorizeCache checks with a SubjectAccessReview that the user may perform the given
+// verb on Konfigurations in all namespaces, as the cache is shared by all of them.
+func (r *KonfigurationReconciler) authorizeCache(ctx context.Context, user *authenticationv1.UserInfo, verb string) error {
+	attrs := &authorizationv1.ResourceAttributes{
+		Verb:     verb,
+		Group:    konfigurationv1.GroupVersion.Group,
+		Resource: "konfigurations",
+	}
+	allowed, err := r.reviewAccess(ctx, user, attrs)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("user '%s' may not %s %s in all namespaces", user.Username, verb, describeAttributes(attrs))
+	}
+	return nil
+}
+
+// reviewAccess returns whether the user is allowed the given attributes.
+func (r *KonfigurationReconciler) reviewAccess(ctx context.Context, user *authenticationv1.UserInfo, attrs *authorizationv1.ResourceAttributes) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.UID,
+			Extra:              extra,
+		},
+	}
+	if err := r.Client.Create(ctx, review); err != nil {
+		return false, fmt.Errorf("failed to review access: %w", err)
+	}
+	return review.Status.Allowed, nil
+}
+
+// buildServiceAccount returns the service account a Konfiguration would be built as,
+// if any.
+func (r *KonfigurationReconciler) buildServiceAccount(konfig *konfigurationv1.Konfiguration) string {
+	if sa := konfig.GetServiceAccountName(); sa != "" {
+		return sa
+	}
+	return r.access.DefaultServiceAccount
+}
+
+func describeAttributes(attrs *authorizationv1.ResourceAttributes) string {
+	resource := attrs.Resource
+	if attrs.Group != "" {
+		resource += "." + attrs.Group
+	}
+	if attrs.Name != "" {
+		resource += "/" + attrs.Name
+	}
+	return resource
+}
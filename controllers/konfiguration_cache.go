@@ -0,0 +1,83 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// CacheFunc returns the http handler for inspecting and purging the cache of HTTP(S)
+// paths and remote imports. GET lists the cached files, and DELETE purges those whose
+// URL starts with the prefix query parameter, or all of them.
+func (r *KonfigurationReconciler) CacheFunc() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), r.dryRunTimeout)
+		defer cancel()
+
+		var verb string
+		switch req.Method {
+		case http.MethodGet:
+			verb = "list"
+		case http.MethodDelete:
+			verb = "delete"
+		default:
+			r.returnError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed", req.Method))
+			return
+		}
+
+		if r.buildAuth {
+			var user *authenticationv1.UserInfo
+			var err error
+			if user, err = r.authenticateBuild(ctx, req); err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, errUnauthenticated) {
+					status = http.StatusUnauthorized
+				}
+				r.returnError(w, status, err.Error())
+				return
+			}
+			if err := r.authorizeCache(ctx, user, verb); err != nil {
+				r.returnError(w, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+
+		var out interface{}
+		if req.Method == http.MethodDelete {
+			prefix := req.URL.Query().Get("prefix")
+			r.HTTPLog.Info(fmt.Sprintf("Purging cached remote files with prefix '%s'", prefix))
+			out = map[string]int{"purged": r.remoteFiles.Purge(prefix)}
+		} else {
+			out = r.remoteFiles.Entries()
+		}
+
+		body, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			r.returnError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if _, err := w.Write(append(body, []byte("\n")...)); err != nil {
+			r.HTTPLog.Error(err, "Error writing cache response")
+		}
+	})
+}
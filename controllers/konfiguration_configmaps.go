@@ -0,0 +1,59 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/impersonation"
+)
+
+// errNoConfigMapIdentity is returned by k8s:// imports of Konfigurations that do not
+// impersonate anyone.
+var errNoConfigMapIdentity = errors.New("k8s:// imports require a serviceAccountName or kubeConfig, ConfigMaps are never read with the controller's identity")
+
+// configMapReader returns the reader for the ConfigMaps the Konfiguration imports over
+// k8s://, with the identity it impersonates. Without one, every import is refused.
+func (r *KonfigurationReconciler) configMapReader(konfig *konfigurationv1.Konfiguration, imp impersonation.Impersonation, kubeClient client.Client) client.Reader {
+	if !imp.Impersonating() {
+		return deniedReader{err: errNoConfigMapIdentity}
+	}
+	var reader client.Reader = kubeClient
+	if r.access.NoCrossNamespaceRefs {
+		return &namespacedReader{Reader: reader, access: &r.access, namespace: konfig.GetNamespace()}
+	}
+	return reader
+}
+
+// namespacedReader refuses to get objects outside of the namespace of a Konfiguration.
+type namespacedReader struct {
+	client.Reader
+	access    *AccessOptions
+	namespace string
+}
+
+// Get implements client.Reader.
+func (n *namespacedReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := n.access.checkSourceNamespace("ConfigMap", key.Namespace, key.Name, n.namespace); err != nil {
+		return err
+	}
+	return n.Reader.Get(ctx, key, obj)
+}
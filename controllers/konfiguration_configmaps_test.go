@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/pelotech/jsonnet-controller/pkg/impersonation"
+)
+
+func TestConfigMapReader(t *testing.T) {
+	ctx := context.Background()
+	cms := []*corev1.ConfigMap{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "local"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "remote"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(cms[0], cms[1]).Build()
+
+	// Without an identity to impersonate, ConfigMaps are never read
+	konfig := newDependentKonfiguration("a")
+	r := &KonfigurationReconciler{Client: c}
+	reader := r.configMapReader(konfig, impersonation.NewImpersonation(konfig, c, nil), c)
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: "default", Name: "local"}, &corev1.ConfigMap{}); !errors.Is(err, errNoConfigMapIdentity) {
+		t.Errorf("expected the import to be refused, got %v", err)
+	}
+
+	konfig.Spec.ServiceAccountName = "deployer"
+	imp := impersonation.NewImpersonation(konfig, c, nil)
+	for _, tc := range []struct {
+		access    AccessOptions
+		namespace string
+		allowed   bool
+	}{
+		{AccessOptions{}, "default", true},
+		{AccessOptions{}, "other", true},
+		{AccessOptions{NoCrossNamespaceRefs: true}, "default", true},
+		{AccessOptions{NoCrossNamespaceRefs: true}, "other", false},
+	} {
+		r := &KonfigurationReconciler{Client: c, access: tc.access}
+		name := "local"
+		if tc.namespace == "other" {
+			name = "remote"
+		}
+		err := r.configMapReader(konfig, imp, c).Get(ctx, types.NamespacedName{Namespace: tc.namespace, Name: name}, &corev1.ConfigMap{})
+		if (err == nil) != tc.allowed {
+			t.Errorf("expected reading from %s with %+v to be allowed=%v, got %v", tc.namespace, tc.access, tc.allowed, err)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -30,9 +31,12 @@ import (
 	"github.com/fluxcd/pkg/runtime/predicates"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	kuberecorder "k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/reference"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling"
@@ -41,8 +45,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
@@ -51,9 +57,14 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 
 	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
+	"github.com/pelotech/jsonnet-controller/pkg/bundler"
 	"github.com/pelotech/jsonnet-controller/pkg/healthcheck"
 	"github.com/pelotech/jsonnet-controller/pkg/impersonation"
 	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+	"github.com/pelotech/jsonnet-controller/pkg/oci"
+	"github.com/pelotech/jsonnet-controller/pkg/permissions"
+	"github.com/pelotech/jsonnet-controller/pkg/policy"
 	"github.com/pelotech/jsonnet-controller/pkg/resources"
 )
 
@@ -66,10 +77,22 @@ type KonfigurationReconciler struct {
 	MetricsRecorder       *metrics.Recorder
 	HTTPLog               logr.Logger
 
-	httpClient                *retryablehttp.Client
+	fetcher                   *artifacts.Fetcher
 	dependencyRequeueDuration time.Duration
-	jsonnetCache              string
 	dryRunTimeout             time.Duration
+	impersonationOpts         *impersonation.Options
+	access                    AccessOptions
+	preflightPermissionCheck  bool
+	buildAuth                 bool
+	skipUnchangedImports      bool
+	artifacts                 *artifacts.Cache
+	remoteFiles               *jsonnet.RemoteFiles
+	vendorer                  *bundler.Vendorer
+	oci                       *oci.Client
+	libraries                 *libraryFiles
+	libraryNamespace          string
+	remoteChanges             chan event.GenericEvent
+	libraryResyncs            chan event.GenericEvent
 }
 
 // ReconcilerOptions are the configuration options that can be passed to a controller
@@ -78,8 +101,50 @@ type ReconcilerOptions struct {
 	MaxConcurrentReconciles   int
 	HTTPRetryMax              int
 	DependencyRequeueInterval time.Duration
-	JsonnetCacheDirectory     string
 	DryRunRequestTimeout      time.Duration
+	ClientCacheTTL            time.Duration
+	ClientCacheSize           int
+	ServiceAccountMode        string
+	ServiceAccountTokenTTL    time.Duration
+	Access                    AccessOptions
+	PreflightPermissionCheck  bool
+	BuildAuth                 bool
+	SkipUnchangedImports      bool
+	ArtifactCache             *artifacts.Cache
+	ArtifactMaxSize           int64
+	// ArtifactSourceKinds are the kinds of sources publishing artifacts, other than
+	// those built into the source-controller API, that are watched for new revisions.
+	ArtifactSourceKinds []schema.GroupVersionKind
+	// RemoteCache configures the cache of HTTP(S) paths and remote imports. Files
+	// over ArtifactMaxSize are refused.
+	RemoteCache jsonnet.RemoteFilesOptions
+	// RemotePollInterval is the interval at which HTTP(S) paths, remote imports and
+	// looked up objects are polled for changes, zero to only pick them up at each
+	// Konfiguration's interval.
+	RemotePollInterval time.Duration
+	// LibraryNamespace is a namespace whose JsonnetLibraries may be imported by
+	// Konfigurations in any namespace.
+	LibraryNamespace string
+	// OCILayout is the directory of an OCI image layout searched for the artifacts
+	// of oci:// imports before their registry.
+	OCILayout string
+
+	// libraryResyncs receives the JsonnetLibraries whose files changed since they
+	// published their revision, for the JsonnetLibrary controller to reconcile them.
+	libraryResyncs chan event.GenericEvent
+}
+
+// libraryResyncBuffer is the number of JsonnetLibraries waiting to be reconciled
+// again before requests are dropped. Dropped libraries are picked up at their interval.
+const libraryResyncBuffer = 64
+
+// libraryResyncChannel returns the channel of JsonnetLibraries to reconcile again,
+// shared by the controllers set up with the options.
+func (o *ReconcilerOptions) libraryResyncChannel() chan event.GenericEvent {
+	if o.libraryResyncs == nil {
+		o.libraryResyncs = make(chan event.GenericEvent, libraryResyncBuffer)
+	}
+	return o.libraryResyncs
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -90,10 +155,42 @@ func (r *KonfigurationReconciler) SetupWithManager(log logr.Logger, mgr ctrl.Man
 	httpClient.RetryWaitMax = 30 * time.Second
 	httpClient.RetryMax = opts.HTTPRetryMax
 	httpClient.Logger = nil
-	r.httpClient = httpClient
+	r.fetcher = &artifacts.Fetcher{HTTPClient: httpClient, MaxSize: opts.ArtifactMaxSize}
 	r.dependencyRequeueDuration = opts.DependencyRequeueInterval
-	r.jsonnetCache = opts.JsonnetCacheDirectory
 	r.dryRunTimeout = opts.DryRunRequestTimeout
+	r.buildAuth = opts.BuildAuth
+	r.skipUnchangedImports = opts.SkipUnchangedImports
+	r.artifacts = opts.ArtifactCache
+	remoteOpts := opts.RemoteCache
+	remoteOpts.MaxFileSize = opts.ArtifactMaxSize
+	remoteFiles, err := jsonnet.NewRemoteFiles(httpClient.StandardClient(), remoteOpts)
+	if err != nil {
+		return fmt.Errorf("failed to set up the remote file cache: %w", err)
+	}
+	r.remoteFiles = remoteFiles
+	// Git dependencies are fetched like remote bases, so they are refused along with them
+	r.vendorer = &bundler.Vendorer{Cache: r.artifacts, AllowedProtocols: gitProtocols, NoGit: opts.Access.NoRemoteBases}
+	r.oci = &oci.Client{HTTPClient: httpClient.StandardClient(), Layout: opts.OCILayout, MaxSize: opts.ArtifactMaxSize}
+	r.libraries = newLibraryFiles(mgr, r.fetcher, opts)
+	r.libraryNamespace = opts.LibraryNamespace
+	r.libraryResyncs = opts.libraryResyncChannel()
+
+	// Set up the options for impersonating service accounts and kubeconfigs
+	saMode := impersonation.ServiceAccountMode(opts.ServiceAccountMode)
+	switch saMode {
+	case impersonation.ServiceAccountModeTokenRequest, impersonation.ServiceAccountModeImpersonate:
+	default:
+		return fmt.Errorf("unknown service account mode '%s'", opts.ServiceAccountMode)
+	}
+	r.impersonationOpts = &impersonation.Options{
+		RESTConfig:            mgr.GetConfig(),
+		ServiceAccountMode:    saMode,
+		TokenExpiration:       opts.ServiceAccountTokenTTL,
+		DefaultServiceAccount: opts.Access.DefaultServiceAccount,
+		Cache:                 impersonation.NewClientCache(opts.ClientCacheTTL, opts.ClientCacheSize),
+	}
+	r.access = opts.Access
+	r.preflightPermissionCheck = opts.PreflightPermissionCheck
 
 	// Index the Kustomizations by the GitRepository references they (may) point at.
 	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.Konfiguration{}, konfigurationv1.GitRepositoryIndexKey,
@@ -107,7 +204,31 @@ func (r *KonfigurationReconciler) SetupWithManager(log logr.Logger, mgr ctrl.Man
 		return fmt.Errorf("failed setting index fields: %w", err)
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	// Index the Konfigurations by the HelmChart references they (may) point at.
+	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.Konfiguration{}, konfigurationv1.HelmChartIndexKey,
+		r.indexBy(sourcev1.HelmChartKind)); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the Konfigurations by the references to any other artifact sources they (may) point at.
+	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.Konfiguration{}, konfigurationv1.ArtifactSourceIndexKey,
+		r.indexByArtifactSource); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the Konfigurations by the Konfigurations they depend on.
+	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.Konfiguration{}, konfigurationv1.DependsOnIndexKey,
+		r.indexByDependency); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the Konfigurations by the JsonnetLibraries they imported.
+	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.Konfiguration{}, konfigurationv1.LibraryIndexKey,
+		r.indexByLibrary); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&konfigurationv1.Konfiguration{}, builder.WithPredicates(
 			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}),
 		)).Watches(
@@ -118,7 +239,37 @@ func (r *KonfigurationReconciler) SetupWithManager(log logr.Logger, mgr ctrl.Man
 		&source.Kind{Type: &sourcev1.Bucket{}},
 		handler.EnqueueRequestsFromMapFunc(r.requestsForRevisionChangeOf(konfigurationv1.BucketIndexKey)),
 		builder.WithPredicates(SourceRevisionChangePredicate{}),
-	).WithOptions(
+	).Watches(
+		&source.Kind{Type: &sourcev1.HelmChart{}},
+		handler.EnqueueRequestsFromMapFunc(r.requestsForRevisionChangeOf(konfigurationv1.HelmChartIndexKey)),
+		builder.WithPredicates(SourceRevisionChangePredicate{}),
+	).Watches(
+		&source.Kind{Type: &konfigurationv1.Konfiguration{}},
+		handler.EnqueueRequestsFromMapFunc(r.requestsForDependants),
+		builder.WithPredicates(DependencyReadyPredicate{}),
+	).Watches(
+		&source.Kind{Type: &konfigurationv1.JsonnetLibrary{}},
+		handler.EnqueueRequestsFromMapFunc(r.requestsForLibraryChange),
+		builder.WithPredicates(LibraryRevisionChangePredicate{}),
+	)
+	for _, obj := range artifactSourceWatches(log, mgr, opts.ArtifactSourceKinds) {
+		b = b.Watches(
+			&source.Kind{Type: obj},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForArtifactSourceChange),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		)
+	}
+	if opts.RemotePollInterval > 0 {
+		r.remoteChanges = make(chan event.GenericEvent)
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			r.pollRemotes(ctx, opts.RemotePollInterval)
+			return nil
+		})); err != nil {
+			return err
+		}
+		b = b.Watches(&source.Channel{Source: r.remoteChanges}, &handler.EnqueueRequestForObject{})
+	}
+	return b.WithOptions(
 		controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles},
 	).Complete(r)
 }
@@ -129,10 +280,17 @@ func (r *KonfigurationReconciler) SetupWithManager(log logr.Logger, mgr ctrl.Man
 // +kubebuilder:rbac:groups=jsonnet.io,resources=konfigurations,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=jsonnet.io,resources=konfigurations/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=jsonnet.io,resources=konfigurations/finalizers,verbs=update
-// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets;gitrepositories,verbs=get;list;watch
-// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets/status;gitrepositories/status,verbs=get
+// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets;gitrepositories;helmcharts;ocirepositories,verbs=get;list;watch
+// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets/status;gitrepositories/status;helmcharts/status;ocirepositories/status,verbs=get
 // +kubebuilder:rbac:groups="",resources=secrets;serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+// +kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=jsonnet.io,resources=konfigurationpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=get;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -180,8 +338,45 @@ func (r *KonfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}, nil
 	}
 
+	// Check the konfiguration against the multi-tenancy restrictions
+	if err := r.access.checkAccess(konfig); err != nil {
+		reqLogger.Info(fmt.Sprintf("Access denied: %s", err.Error()))
+		if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(
+			"", konfigurationv1.AccessDeniedReason, err.Error(),
+		)); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update Konfiguration status")
+		}
+		r.event(ctx, konfig, &EventData{
+			Severity: events.EventSeverityError,
+			Message:  err.Error(),
+		})
+		r.recordReadiness(ctx, konfig)
+		return ctrl.Result{
+			RequeueAfter: konfig.GetRetryInterval(),
+		}, nil
+	}
+
+	// Refuse dependency cycles before fetching anything, they cannot resolve on their own
+	if err := r.findDependencyCycle(ctx, konfig); err != nil {
+		var cycleErr *dependencyCycleError
+		if !errors.As(err, &cycleErr) {
+			reqLogger.Error(err, "Failed to check for dependency cycles")
+			return ctrl.Result{}, err
+		}
+		reqLogger.Info(err.Error())
+		r.setStalled(ctx, cycleErr)
+		r.event(ctx, konfig, &EventData{
+			Severity: events.EventSeverityError,
+			Message:  err.Error(),
+		})
+		r.recordReadiness(ctx, konfig)
+		// Dependants are requeued when the cycle is broken and a dependency becomes
+		// ready, retry at the interval in case that never happens.
+		return ctrl.Result{RequeueAfter: konfig.GetInterval()}, nil
+	}
+
 	// Get the revision and the path we are going to operate on
-	revision, path, clean, err := r.prepareSource(ctx, konfig)
+	revision, root, path, clean, err := r.prepareSource(ctx, konfig)
 	if err != nil {
 		r.recordReadiness(ctx, konfig)
 		return ctrl.Result{
@@ -191,7 +386,7 @@ func (r *KonfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	defer clean()
 
 	// Check if there are any dependencies and that they are all ready
-	if err := r.checkDependencies(ctx, konfig); err != nil {
+	if err := r.checkDependencies(ctx, konfig, revision); err != nil {
 		if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(
 			revision, meta.DependencyNotReadyReason, err.Error(),
 		)); statusErr != nil {
@@ -208,6 +403,23 @@ func (r *KonfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{RequeueAfter: r.dependencyRequeueDuration}, nil
 	}
 
+	// Skip the build if this is a new revision that did not change any imported file
+	if r.skipUnchangedImports {
+		if unchanged, err := r.importsUnchanged(ctx, konfig, revision, root); err != nil {
+			reqLogger.Error(err, "Failed to compare imported files, proceeding with reconciliation")
+		} else if unchanged {
+			msg := fmt.Sprintf("Applied revision: %s, no imported files changed", revision)
+			if err := konfig.SetReady(ctx, r.Client, konfig.BuildResult(),
+				konfigurationv1.NewStatusMeta(revision, meta.ReconciliationSucceededReason, msg),
+			); err != nil {
+				return ctrl.Result{Requeue: true}, err
+			}
+			reqLogger.Info(fmt.Sprintf("No imported files changed, next run in %s", konfig.GetInterval().String()), "Revision", revision)
+			r.recordReadiness(ctx, konfig)
+			return ctrl.Result{RequeueAfter: konfig.GetInterval()}, nil
+		}
+	}
+
 	// record reconciliation duration
 	if r.MetricsRecorder != nil {
 		objRef, err := reference.GetReference(r.Scheme, konfig)
@@ -225,7 +437,7 @@ func (r *KonfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	r.recordReadiness(ctx, konfig)
 
 	// Do reconciliation
-	snapshot, err := r.reconcile(ctx, konfig, revision, path)
+	result, err := r.reconcile(ctx, konfig, revision, root, path)
 	if err != nil {
 		reqLogger.Error(err, "Error during reconciliation")
 		r.event(ctx, konfig, &EventData{
@@ -238,11 +450,11 @@ func (r *KonfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}, nil
 	}
 
-	updated := konfig.Status.Snapshot == nil || snapshot.Checksum != konfig.Status.Snapshot.Checksum
+	updated := konfig.Status.Snapshot == nil || result.Snapshot.Checksum != konfig.Status.Snapshot.Checksum
 
 	// Set the konfiguration as ready
 	msg := fmt.Sprintf("Applied revision: %s", revision)
-	if err := konfig.SetReady(ctx, r.Client, snapshot, konfigurationv1.NewStatusMeta(
+	if err := konfig.SetReady(ctx, r.Client, result, konfigurationv1.NewStatusMeta(
 		revision, meta.ReconciliationSucceededReason, msg),
 	); err != nil {
 		return ctrl.Result{Requeue: true}, err
@@ -265,7 +477,43 @@ func (r *KonfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	}, nil
 }
 
-func (r *KonfigurationReconciler) reconcile(ctx context.Context, konfig *konfigurationv1.Konfiguration, revision, path string) (*konfigurationv1.Snapshot, error) {
+// builderOptions returns the options to pass to jsonnet builders for the Konfiguration,
+// given its impersonation and the client for the cluster it targets, the map recording
+// the revisions of the JsonnetLibraries it imports, the outputs of its dependencies,
+// the root of its source and the lock pinning its remote imports, if any.
+func (r *KonfigurationReconciler) builderOptions(konfig *konfigurationv1.Konfiguration, imp impersonation.Impersonation, kubeClient impersonation.Client, libraries map[types.NamespacedName]string, dependencyOutputs, root string, lock *jsonnet.ImportLock) []jsonnet.BuilderOption {
+	opts := []jsonnet.BuilderOption{
+		jsonnet.WithExtCode(konfigurationv1.OutputsExtVar, dependencyOutputs),
+		jsonnet.WithRemoteFiles(r.remoteFiles),
+		jsonnet.WithImportScheme("k8s", false, jsonnet.ConfigMapImporter(r.configMapReader(konfig, imp, kubeClient))),
+		jsonnet.WithImportScheme("oci", true, jsonnet.OCIImporter(r.oci, r.artifacts)),
+		jsonnet.WithImportScheme("lib", false, r.libraryImporter(konfig, libraries)),
+		jsonnet.WithLookup(r.lookupReader(konfig, imp, kubeClient)),
+		// Discover the capabilities of the target cluster, with its identity, when used
+		jsonnet.WithCapabilities(func(ctx context.Context) (*jsonnet.Capabilities, error) {
+			dc, err := kubeClient.Discovery()
+			if err != nil {
+				return nil, err
+			}
+			return jsonnet.DiscoverCapabilities(dc)(ctx)
+		}),
+	}
+	if root != "" {
+		// Install the jsonnet-bundler dependencies of entrypoints in the source
+		opts = append(opts, jsonnet.WithVendor(func(ctx context.Context, dir string) ([]string, func(), error) {
+			return r.vendorer.Vendor(ctx, root, dir)
+		}))
+	}
+	if lock != nil {
+		opts = append(opts, jsonnet.WithImportLock(lock))
+	}
+	if r.access.NoRemoteBases {
+		opts = append(opts, jsonnet.WithoutRemoteImports())
+	}
+	return opts
+}
+
+func (r *KonfigurationReconciler) reconcile(ctx context.Context, konfig *konfigurationv1.Konfiguration, revision, root, path string) (*konfigurationv1.BuildResult, error) {
 	reqLogger := log.FromContext(ctx)
 	// Record the status metric no matter the outcome
 	defer r.recordReadiness(ctx, konfig)
@@ -278,7 +526,7 @@ func (r *KonfigurationReconciler) reconcile(ctx context.Context, konfig *konfigu
 	defer os.RemoveAll(dirPath)
 
 	// Create any necessary kube-clients for impersonation
-	imp := impersonation.NewImpersonation(konfig, r.Client)
+	imp := impersonation.NewImpersonation(konfig, r.Client, r.impersonationOpts)
 	kubeClient, err := imp.GetClient(ctx)
 	if err != nil {
 		if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(
@@ -290,7 +538,16 @@ func (r *KonfigurationReconciler) reconcile(ctx context.Context, konfig *konfigu
 	}
 
 	// Create a builder to evaluate the jsonnet
-	builder, err := jsonnet.NewBuilder(konfig, dirPath, r.jsonnetCache)
+	var builder jsonnet.Builder
+	var lock *jsonnet.ImportLock
+	libraries := make(map[types.NamespacedName]string)
+	dependencyOutputs, err := r.dependencyOutputs(ctx, konfig)
+	if err == nil {
+		lock, err = r.importLock(konfig, root)
+	}
+	if err == nil {
+		builder, err = jsonnet.NewBuilder(konfig, dirPath, r.builderOptions(konfig, imp, kubeClient, libraries, dependencyOutputs, root, lock)...)
+	}
 	if err != nil {
 		if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(
 			revision, meta.ReconciliationFailedReason, err.Error()),
@@ -333,6 +590,34 @@ func (r *KonfigurationReconciler) reconcile(ctx context.Context, konfig *konfigu
 		return nil, fmt.Errorf("failed to compute snapshot of manifests: %w", err)
 	}
 
+	// Check the rendered objects against any policies before applying them
+	if err := r.checkPolicies(ctx, konfig, kubeClient.RESTMapper(), buildOutput.SortedObjects()); err != nil {
+		reason := meta.ReconciliationFailedReason
+		var violations *policy.ViolationError
+		if errors.As(err, &violations) {
+			reason = konfigurationv1.PolicyViolationReason
+		}
+		if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(revision, reason, err.Error())); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update Konfiguration status")
+		}
+		return nil, fmt.Errorf("failed to check konfiguration policies: %w", err)
+	}
+
+	// Make sure the impersonated identity can do everything it needs to before touching anything
+	if r.preflightPermissionCheck && imp.Impersonating() {
+		if err := r.checkPermissions(ctx, konfig, kubeClient, buildOutput.SortedObjects()); err != nil {
+			reason := meta.ReconciliationFailedReason
+			var missing *permissions.MissingError
+			if errors.As(err, &missing) {
+				reason = konfigurationv1.InsufficientPermissionsReason
+			}
+			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(revision, reason, err.Error())); statusErr != nil {
+				reqLogger.Error(statusErr, "Failed to update Konfiguration status")
+			}
+			return nil, fmt.Errorf("pre-flight permission check failed: %w", err)
+		}
+	}
+
 	// Create a resource manager for the konfiguration
 	manager := resources.NewResourceManager(kubeClient, konfig)
 
@@ -418,14 +703,29 @@ func (r *KonfigurationReconciler) reconcile(ctx context.Context, konfig *konfigu
 		return nil, err
 	}
 
-	return snapshot, nil
+	result := &konfigurationv1.BuildResult{
+		Snapshot:      snapshot,
+		Inputs:        buildInputs(konfig, root, dependencyOutputs, buildOutput.Imports(), buildOutput.Misses()),
+		RemoteImports: remoteImports(buildOutput.Imports()),
+		Libraries:     libraryImports(libraries),
+		Lookups:       objectLookups(buildOutput.Lookups()),
+	}
+	// Builds reading live objects are never skipped, as their inputs are not files
+	if len(result.Lookups) > 0 {
+		result.Inputs = nil
+	}
+	if raw := buildOutput.Outputs(); raw != nil {
+		result.Outputs = &extv1.JSON{Raw: raw}
+	}
+
+	return result, nil
 }
 
 func (r *KonfigurationReconciler) reconcileDelete(ctx context.Context, konfig *konfigurationv1.Konfiguration) (ctrl.Result, error) {
 	// If the konfig had pruning enabled and wasn't suspended before deletion,
 	// run garbage collection.
 	if konfig.GCEnabled() && !konfig.IsSuspended() {
-		imp := impersonation.NewImpersonation(konfig, r.Client)
+		imp := impersonation.NewImpersonation(konfig, r.Client, r.impersonationOpts)
 		kubeClient, err := imp.GetClient(ctx)
 		if err != nil {
 			r.event(ctx, konfig, &EventData{
@@ -18,32 +18,141 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/fluxcd/pkg/apis/meta"
 
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/healthcheck"
+	"github.com/pelotech/jsonnet-controller/pkg/impersonation"
 )
 
-func (r *KonfigurationReconciler) checkDependencies(ctx context.Context, konfig *konfigurationv1.Konfiguration) error {
-	log := log.FromContext(ctx)
+// dependencyCycleError is returned when a Konfiguration depends on itself through
+// its dependencies.
+type dependencyCycleError struct {
+	cycle []types.NamespacedName
+}
 
+// Error implements the error interface.
+func (d *dependencyCycleError) Error() string {
+	names := make([]string, len(d.cycle))
+	for i, nn := range d.cycle {
+		names[i] = nn.String()
+	}
+	return fmt.Sprintf("dependency cycle detected: %s", strings.Join(names, " -> "))
+}
+
+// dependencies returns the namespaced names of the Konfigurations the given one
+// depends on. Dependencies on other kinds of objects are not included.
+func dependencies(konfig *konfigurationv1.Konfiguration) []types.NamespacedName {
 	_, deps := konfig.GetDependsOn()
+	out := make([]types.NamespacedName, len(deps))
+	for i, dep := range deps {
+		out[i] = types.NamespacedName{Namespace: dep.Namespace, Name: dep.Name}
+		if out[i].Namespace == "" {
+			out[i].Namespace = konfig.GetNamespace()
+		}
+	}
+	return out
+}
+
+// findDependencyCycle walks the dependencies of the given Konfiguration and returns
+// a *dependencyCycleError if it is reachable from itself. Dependencies that do not
+// exist are skipped, they are reported by checkDependencies.
+func (r *KonfigurationReconciler) findDependencyCycle(ctx context.Context, konfig *konfigurationv1.Konfiguration) error {
+	root := konfig.GetNamespacedName()
+	visited := map[types.NamespacedName]bool{root: true}
+
+	var walk func(path []types.NamespacedName, deps []types.NamespacedName) ([]types.NamespacedName, error)
+	walk = func(path []types.NamespacedName, deps []types.NamespacedName) ([]types.NamespacedName, error) {
+		for _, dep := range deps {
+			if dep == root {
+				return append(path, dep), nil
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			var k konfigurationv1.Konfiguration
+			if err := r.Get(ctx, dep, &k); err != nil {
+				if client.IgnoreNotFound(err) == nil {
+					continue
+				}
+				return nil, err
+			}
+			cycle, err := walk(append(path, dep), dependencies(&k))
+			if err != nil || cycle != nil {
+				return cycle, err
+			}
+		}
+		return nil, nil
+	}
 
-	if len(deps) == 0 {
+	cycle, err := walk([]types.NamespacedName{root}, dependencies(konfig))
+	if err != nil {
+		return err
+	}
+	if cycle != nil {
+		return &dependencyCycleError{cycle: cycle}
+	}
+	return nil
+}
+
+// setStalled marks every Konfiguration in the cycle as stalled.
+func (r *KonfigurationReconciler) setStalled(ctx context.Context, cycleErr *dependencyCycleError) {
+	log := log.FromContext(ctx)
+	// The last element closes the cycle and repeats the first
+	for _, nn := range cycleErr.cycle[:len(cycleErr.cycle)-1] {
+		var k konfigurationv1.Konfiguration
+		if err := r.Get(ctx, nn, &k); err != nil {
+			log.Error(err, fmt.Sprintf("Failed to retrieve '%s' to mark it as stalled", nn))
+			continue
+		}
+		if err := k.SetStalled(ctx, r.Client, konfigurationv1.NewStatusMeta(
+			"", konfigurationv1.DependencyCycleReason, cycleErr.Error(),
+		)); err != nil {
+			log.Error(err, fmt.Sprintf("Failed to mark '%s' as stalled", nn))
+		}
+	}
+}
+
+// checkDependencies returns an error if any of the dependencies of the Konfiguration
+// are not ready. When the Konfiguration requires it, dependencies must also have been
+// applied at the given revision. Cycles are ruled out beforehand by findDependencyCycle.
+func (r *KonfigurationReconciler) checkDependencies(ctx context.Context, konfig *konfigurationv1.Konfiguration, revision string) error {
+	log := log.FromContext(ctx)
+
+	if len(konfig.Spec.DependsOn) == 0 {
 		return nil
 	}
 
-	for _, dep := range deps {
-		if dep.Namespace == "" {
-			dep.Namespace = konfig.GetNamespace()
+	// Other objects are read with the Konfiguration's identity, so it can only wait
+	// on objects it is allowed to see.
+	var kubeClient client.Client
+	for _, dep := range konfig.Spec.DependsOn {
+		if dep.IsKonfiguration() {
+			continue
+		}
+		if kubeClient == nil {
+			imp := impersonation.NewImpersonation(konfig, r.Client, r.impersonationOpts)
+			var err error
+			if kubeClient, err = imp.GetClient(ctx); err != nil {
+				return fmt.Errorf("failed to build kube client: %w", err)
+			}
+		}
+		if err := r.checkObjectDependency(ctx, kubeClient, konfig, dep); err != nil {
+			return err
 		}
-		dName := types.NamespacedName(dep)
+	}
 
+	for _, dName := range dependencies(konfig) {
 		log.Info(fmt.Sprintf("Checking dependency '%s'", dName.String()))
 		var k konfigurationv1.Konfiguration
 		err := r.Get(ctx, dName, &k)
@@ -58,8 +167,62 @@ func (r *KonfigurationReconciler) checkDependencies(ctx context.Context, konfig
 		if !apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.ReadyCondition) {
 			return fmt.Errorf("dependency '%s' is not ready", dName)
 		}
+
+		if konfig.Spec.DependsOnSameRevision && k.Status.LastAppliedRevision != revision {
+			return fmt.Errorf("dependency '%s' is not ready at revision '%s'", dName, revision)
+		}
 	}
 
 	log.Info("All dependencies area ready, proceeding with reconciliation")
 	return nil
 }
+
+// checkObjectDependency returns an error if the object referenced by the dependency
+// is not ready. The object is read with the given client.
+func (r *KonfigurationReconciler) checkObjectDependency(ctx context.Context, kubeClient client.Client, konfig *konfigurationv1.Konfiguration, dep konfigurationv1.DependencyReference) error {
+	log := log.FromContext(ctx)
+
+	if dep.Namespace == "" {
+		dep.Namespace = konfig.GetNamespace()
+	}
+	log.Info(fmt.Sprintf("Checking dependency %s", dep.String()))
+
+	gvk, err := dep.GroupVersionKind()
+	if err != nil {
+		return fmt.Errorf("invalid dependency %s: %w", dep.String(), err)
+	}
+	ready, msg, err := healthcheck.ObjectReady(ctx, kubeClient, gvk, types.NamespacedName{Namespace: dep.Namespace, Name: dep.Name})
+	if err != nil {
+		return fmt.Errorf("unable to get dependency %s: %w", dep.String(), err)
+	}
+	if !ready {
+		return fmt.Errorf("dependency %s is not ready: %s", dep.String(), msg)
+	}
+	return nil
+}
+
+// dependencyOutputs returns a JSON object of the outputs of the Konfigurations the given
+// one depends on. Dependencies are keyed by name, qualified with their namespace when it
+// differs from the Konfiguration's.
+func (r *KonfigurationReconciler) dependencyOutputs(ctx context.Context, konfig *konfigurationv1.Konfiguration) (string, error) {
+	outputs := make(map[string]json.RawMessage)
+	for _, dName := range dependencies(konfig) {
+		var k konfigurationv1.Konfiguration
+		if err := r.Get(ctx, dName, &k); err != nil {
+			return "", fmt.Errorf("unable to get '%s' dependency: %w", dName, err)
+		}
+		key := dName.Name
+		if dName.Namespace != konfig.GetNamespace() {
+			key = dName.String()
+		}
+		outputs[key] = json.RawMessage("{}")
+		if k.Status.Outputs != nil && len(k.Status.Outputs.Raw) > 0 {
+			outputs[key] = k.Status.Outputs.Raw
+		}
+	}
+	out, err := json.Marshal(outputs)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
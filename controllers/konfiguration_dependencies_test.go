@@ -0,0 +1,286 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// newTestScheme returns a scheme with the built-in and jsonnet.io types.
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := konfigurationv1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newDependentKonfiguration returns a Konfiguration in the default namespace that
+// depends on the given Konfigurations, which may be qualified as "namespace/name".
+func newDependentKonfiguration(name string, deps ...string) *konfigurationv1.Konfiguration {
+	konfig := &konfigurationv1.Konfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+	}
+	for _, dep := range deps {
+		ref := konfigurationv1.DependencyReference{Name: dep}
+		if parts := strings.SplitN(dep, "/", 2); len(parts) == 2 {
+			ref.Namespace, ref.Name = parts[0], parts[1]
+		}
+		konfig.Spec.DependsOn = append(konfig.Spec.DependsOn, ref)
+	}
+	return konfig
+}
+
+func nn(name string) types.NamespacedName {
+	return types.NamespacedName{Namespace: "default", Name: name}
+}
+
+func TestFindDependencyCycle(t *testing.T) {
+	ctx := context.Background()
+
+	tcs := []struct {
+		name     string
+		konfigs  []*konfigurationv1.Konfiguration
+		expected []types.NamespacedName
+	}{
+		{
+			name:    "no dependencies",
+			konfigs: []*konfigurationv1.Konfiguration{newDependentKonfiguration("a")},
+		},
+		{
+			name: "self",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newDependentKonfiguration("a", "a"),
+			},
+			expected: []types.NamespacedName{nn("a"), nn("a")},
+		},
+		{
+			name: "direct",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newDependentKonfiguration("a", "b"),
+				newDependentKonfiguration("b", "a"),
+			},
+			expected: []types.NamespacedName{nn("a"), nn("b"), nn("a")},
+		},
+		{
+			name: "transitive",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newDependentKonfiguration("a", "b"),
+				newDependentKonfiguration("b", "c"),
+				newDependentKonfiguration("c", "default/a"),
+			},
+			expected: []types.NamespacedName{nn("a"), nn("b"), nn("c"), nn("a")},
+		},
+		{
+			name: "cycle not involving the Konfiguration",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newDependentKonfiguration("a", "b"),
+				newDependentKonfiguration("b", "c"),
+				newDependentKonfiguration("c", "b"),
+			},
+		},
+		{
+			name: "diamond",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newDependentKonfiguration("a", "b", "c"),
+				newDependentKonfiguration("b", "d"),
+				newDependentKonfiguration("c", "d"),
+				newDependentKonfiguration("d"),
+			},
+		},
+		{
+			name: "same name in another namespace",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newDependentKonfiguration("a", "other/a"),
+			},
+		},
+		{
+			name: "missing dependency",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newDependentKonfiguration("a", "b"),
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			objs := make([]client.Object, len(tc.konfigs))
+			for i, k := range tc.konfigs {
+				objs[i] = k
+			}
+			r := &KonfigurationReconciler{
+				Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).Build(),
+			}
+			err := r.findDependencyCycle(ctx, tc.konfigs[0])
+			if tc.expected == nil {
+				if err != nil {
+					t.Errorf("expected no cycle, got %v", err)
+				}
+				return
+			}
+			var cycleErr *dependencyCycleError
+			if !errors.As(err, &cycleErr) {
+				t.Fatalf("expected a dependency cycle error, got %v", err)
+			}
+			if !reflect.DeepEqual(cycleErr.cycle, tc.expected) {
+				t.Errorf("expected cycle %v, got %v", tc.expected, cycleErr.cycle)
+			}
+		})
+	}
+}
+
+func TestSetStalled(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := newDependentKonfiguration("a", "b"), newDependentKonfiguration("b", "a"), newDependentKonfiguration("c")
+	r := &KonfigurationReconciler{
+		Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(a, b, c).Build(),
+	}
+
+	err := r.findDependencyCycle(ctx, a)
+	var cycleErr *dependencyCycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("expected a dependency cycle error, got %v", err)
+	}
+	r.setStalled(ctx, cycleErr)
+
+	for name, stalled := range map[string]bool{"a": true, "b": true, "c": false} {
+		var k konfigurationv1.Konfiguration
+		if err := r.Get(ctx, nn(name), &k); err != nil {
+			t.Fatal(err)
+		}
+		if apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.StalledCondition) != stalled {
+			t.Errorf("expected %s to have stalled=%v, got conditions %v", name, stalled, k.Status.Conditions)
+		}
+		if !stalled {
+			continue
+		}
+		cond := apimeta.FindStatusCondition(k.Status.Conditions, meta.ReadyCondition)
+		if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != konfigurationv1.DependencyCycleReason {
+			t.Errorf("expected %s to not be ready because of the cycle, got %v", name, cond)
+		}
+	}
+}
+
+func TestDependencyOutputs(t *testing.T) {
+	withOutputs := func(namespace, name, outputs string) *konfigurationv1.Konfiguration {
+		k := &konfigurationv1.Konfiguration{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		if outputs != "" {
+			k.Status.Outputs = &extv1.JSON{Raw: []byte(outputs)}
+		}
+		return k
+	}
+	r := &KonfigurationReconciler{
+		Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+			withOutputs("default", "db", `{"host":"db.default"}`),
+			withOutputs("default", "empty", ""),
+			withOutputs("infra", "db", `{"host":"db.infra"}`),
+		).Build(),
+	}
+
+	tcs := []struct {
+		name     string
+		deps     []string
+		expected string
+	}{
+		{"same namespace", []string{"db"}, `{"db":{"host":"db.default"}}`},
+		{"without outputs", []string{"empty"}, `{"empty":{}}`},
+		{"other namespace", []string{"infra/db"}, `{"infra/db":{"host":"db.infra"}}`},
+		{"same name in both namespaces", []string{"db", "infra/db"}, `{"db":{"host":"db.default"},"infra/db":{"host":"db.infra"}}`},
+		{"namespace of the Konfiguration", []string{"default/db"}, `{"db":{"host":"db.default"}}`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := r.dependencyOutputs(context.Background(), newDependentKonfiguration("app", tc.deps...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, out)
+			}
+		})
+	}
+
+	if _, err := r.dependencyOutputs(context.Background(), newDependentKonfiguration("app", "other/db")); err == nil {
+		t.Error("expected an error for a missing dependency")
+	}
+}
+
+func TestDependencyReadyPredicate(t *testing.T) {
+	withConditions := func(generation int64, revision string, conds ...metav1.Condition) *konfigurationv1.Konfiguration {
+		k := newDependentKonfiguration("a")
+		k.SetGeneration(generation)
+		k.Status.LastAppliedRevision = revision
+		k.Status.Conditions = conds
+		return k
+	}
+	ready := metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionTrue}
+	notReady := metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionFalse}
+	stalled := metav1.Condition{Type: meta.StalledCondition, Status: metav1.ConditionTrue}
+
+	tcs := []struct {
+		name     string
+		old, new *konfigurationv1.Konfiguration
+		expected bool
+	}{
+		{"became ready", withConditions(1, "a", notReady), withConditions(1, "a", ready), true},
+		{"stayed ready", withConditions(1, "a", ready), withConditions(1, "a", ready), false},
+		{"new revision", withConditions(1, "a", ready), withConditions(1, "b", ready), true},
+		{"new revision while not ready", withConditions(1, "a", notReady), withConditions(1, "b", notReady), false},
+		{"became not ready", withConditions(1, "a", ready), withConditions(1, "a", notReady), false},
+		{"stalled and edited", withConditions(1, "a", notReady, stalled), withConditions(2, "a", notReady, stalled), true},
+		{"stalled and unchanged", withConditions(1, "a", notReady, stalled), withConditions(1, "a", notReady, stalled), false},
+		{"edited while not stalled", withConditions(1, "a", notReady), withConditions(2, "a", notReady), false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := (DependencyReadyPredicate{}).Update(event.UpdateEvent{ObjectOld: tc.old, ObjectNew: tc.new}); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	old := withConditions(1, "a", ready)
+	updated := old.DeepCopy()
+	updated.Status.Outputs = &extv1.JSON{Raw: []byte(`{"url": "https://example.com"}`)}
+	if !(DependencyReadyPredicate{}).Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated}) {
+		t.Error("expected new outputs to pass")
+	}
+	if (DependencyReadyPredicate{}).Create(event.CreateEvent{Object: updated}) {
+		t.Error("expected creations to be ignored")
+	}
+}
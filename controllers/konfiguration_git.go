@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/git"
+)
+
+// gitProtocols are the transports allowed for git repositories, local repositories
+// would expose the filesystem of the controller.
+var gitProtocols = []string{"http", "https", "ssh"}
+
+// checkoutGit resolves the git repository of the Konfiguration and returns the
+// revision and the directory it is checked out to. The directory is shared through the
+// artifact cache and must not be modified.
+func (r *KonfigurationReconciler) checkoutGit(ctx context.Context, konfig *konfigurationv1.Konfiguration) (revision, root string, release func(), err error) {
+	reqLogger := log.FromContext(ctx)
+	spec := konfig.GetGit()
+
+	fail := func(revision string, err error) (string, string, func(), error) {
+		reqLogger.Error(err, "Failed to check out git repository")
+		if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(revision, konfigurationv1.GitOperationFailedReason, err.Error())); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update Konfiguration status")
+		}
+		return "", "", nil, err
+	}
+
+	repo := &git.Repository{URL: spec.URL, AllowedProtocols: gitProtocols}
+	if spec.SecretRef != nil {
+		var secret corev1.Secret
+		if err := r.Get(ctx, types.NamespacedName{Namespace: konfig.GetNamespace(), Name: spec.SecretRef.Name}, &secret); err != nil {
+			return fail("", fmt.Errorf("failed to get git credentials: %w", err))
+		}
+		repo.Auth = &git.Auth{
+			Username:   string(secret.Data["username"]),
+			Password:   string(secret.Data["password"]),
+			Identity:   secret.Data["identity"],
+			KnownHosts: secret.Data["known_hosts"],
+		}
+	}
+
+	var ref git.Reference
+	if spec.Ref != nil {
+		ref = git.Reference{Branch: spec.Ref.Branch, Tag: spec.Ref.Tag, Commit: spec.Ref.Commit}
+	}
+	commit, revision, err := repo.Resolve(ctx, ref)
+	if err != nil {
+		return fail("", err)
+	}
+
+	root, release, err = r.artifacts.GetFunc(ctx, gitCacheID(repo, commit, spec.SparsePath), func(ctx context.Context, dir string) error {
+		return repo.Checkout(ctx, ref, commit, spec.SparsePath, dir)
+	})
+	if err != nil {
+		return fail(revision, err)
+	}
+	return revision, root, release, nil
+}
+
+// gitCacheID returns the id of a checkout in the artifact cache. The credentials are
+// part of it so that checkouts of private repositories are not shared with
+// Konfigurations lacking access to them.
+func gitCacheID(repo *git.Repository, commit, sparsePath string) string {
+	var auth string
+	if a := repo.Auth; a != nil {
+		auth = fmt.Sprintf("%x", sha256.Sum256([]byte(a.Username+"\x00"+a.Password+"\x00"+string(a.Identity))))
+	}
+	return fmt.Sprintf("git\x00%s\x00%s\x00%s\x00%s", repo.URL, commit, path.Clean("/"+sparsePath), auth)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// buildInputs returns what a build of the Konfiguration was evaluated from, given the
+// root of its source, the outputs of its dependencies, the checksums of its imports
+// keyed by URL, and the candidates that were searched for imports and did not exist.
+// It returns nil if the build read anything that cannot be compared at a later
+// revision, such as remote imports or files outside of the source.
+func buildInputs(konfig *konfigurationv1.Konfiguration, root, dependencyOutputs string, imports map[string]string, misses []string) *konfigurationv1.BuildInputs {
+	if root == "" {
+		return nil
+	}
+	inputs := &konfigurationv1.BuildInputs{
+		Generation:      konfig.GetGeneration(),
+		OutputsChecksum: checksum([]byte(dependencyOutputs)),
+		Imports:         make([]konfigurationv1.ImportedFile, 0, len(imports)+len(misses)),
+	}
+	for foundAt, sum := range imports {
+		u, err := url.Parse(foundAt)
+		if err != nil {
+			return nil
+		}
+		// The embedded library only changes with the controller
+		if u.Scheme == "internal" {
+			continue
+		}
+		if sum == "" {
+			return nil
+		}
+		rel, ok := sourcePath(root, u)
+		if !ok {
+			return nil
+		}
+		inputs.Imports = append(inputs.Imports, konfigurationv1.ImportedFile{
+			Path:     rel,
+			Checksum: sum,
+		})
+	}
+	// A file created at a candidate searched before the one an import was found at
+	// would shadow it, so those must keep not existing.
+	for _, triedAt := range misses {
+		u, err := url.Parse(triedAt)
+		if err != nil {
+			return nil
+		}
+		if u.Scheme == "internal" {
+			continue
+		}
+		rel, ok := sourcePath(root, u)
+		if !ok {
+			return nil
+		}
+		inputs.Imports = append(inputs.Imports, konfigurationv1.ImportedFile{
+			Path:    rel,
+			Missing: true,
+		})
+	}
+	sort.Slice(inputs.Imports, func(i, j int) bool {
+		return inputs.Imports[i].Path < inputs.Imports[j].Path
+	})
+	return inputs
+}
+
+// sourcePath returns the path of the file URL relative to root, and false if it is not
+// a file URL within root.
+func sourcePath(root string, u *url.URL) (string, bool) {
+	if u.Scheme != "file" {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, filepath.FromSlash(u.Path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// importsUnchanged returns true if the Konfiguration is ready, and building the given
+// revision of its source, extracted to root, would evaluate the same files with the
+// same inputs as its last build. A revision that was already applied is never
+// considered unchanged, so that reconciles at the interval still correct drift.
+func (r *KonfigurationReconciler) importsUnchanged(ctx context.Context, konfig *konfigurationv1.Konfiguration, revision, root string) (bool, error) {
+	inputs := konfig.Status.Inputs
+	if inputs == nil || root == "" || revision == konfig.Status.LastAppliedRevision {
+		return false, nil
+	}
+	if inputs.Generation != konfig.GetGeneration() || !apimeta.IsStatusConditionTrue(konfig.Status.Conditions, meta.ReadyCondition) {
+		return false, nil
+	}
+
+	dependencyOutputs, err := r.dependencyOutputs(ctx, konfig)
+	if err != nil {
+		return false, err
+	}
+	if checksum([]byte(dependencyOutputs)) != inputs.OutputsChecksum {
+		return false, nil
+	}
+
+	for _, f := range inputs.Imports {
+		path, err := securejoin.SecureJoin(root, f.Path)
+		if err != nil {
+			return false, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) && f.Missing {
+				continue
+			}
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if f.Missing {
+			return false, nil
+		}
+		if checksum(data) != f.Checksum {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func checksum(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
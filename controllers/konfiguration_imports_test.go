@@ -0,0 +1,148 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+func fileURL(path string) string {
+	return "file://" + filepath.ToSlash(path)
+}
+
+func TestBuildInputs(t *testing.T) {
+	root := filepath.FromSlash("/tmp/source")
+	konfig := newDependentKonfiguration("a")
+	konfig.SetGeneration(3)
+
+	inputs := buildInputs(konfig, root, "{}", map[string]string{
+		fileURL(filepath.Join(root, "main.jsonnet")):       "main",
+		fileURL(filepath.Join(root, "lib", "a.libsonnet")): "a",
+		"internal:///lib/kubecfg.libsonnet":                "",
+	}, []string{
+		fileURL(filepath.Join(root, "a.libsonnet")),
+		"internal:///a.libsonnet",
+	})
+	expected := &konfigurationv1.BuildInputs{
+		Generation:      3,
+		OutputsChecksum: checksum([]byte("{}")),
+		Imports: []konfigurationv1.ImportedFile{
+			{Path: "a.libsonnet", Missing: true},
+			{Path: "lib/a.libsonnet", Checksum: "a"},
+			{Path: "main.jsonnet", Checksum: "main"},
+		},
+	}
+	if !reflect.DeepEqual(inputs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, inputs)
+	}
+
+	tcs := []struct {
+		name    string
+		root    string
+		imports map[string]string
+		misses  []string
+	}{
+		{name: "no source", imports: map[string]string{fileURL(filepath.Join(root, "main.jsonnet")): "main"}},
+		{name: "remote import", root: root, imports: map[string]string{"https://example.com/a.libsonnet": "a"}},
+		{name: "untracked file", root: root, imports: map[string]string{fileURL(filepath.Join(root, "data.json")): ""}},
+		{name: "file outside of the source", root: root, imports: map[string]string{fileURL("/etc/a.libsonnet"): "a"}},
+		{name: "miss outside of the source", root: root, misses: []string{fileURL("/etc/a.libsonnet")}},
+		{name: "remote miss", root: root, misses: []string{"https://example.com/a.libsonnet"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if inputs := buildInputs(konfig, tc.root, "{}", tc.imports, tc.misses); inputs != nil {
+				t.Errorf("expected the build to not be comparable, got %+v", inputs)
+			}
+		})
+	}
+}
+
+func TestImportsUnchanged(t *testing.T) {
+	ctx := context.Background()
+	// The inputs were recorded at an earlier revision, extracted elsewhere
+	root := filepath.FromSlash("/tmp/source")
+	newKonfig := func() *konfigurationv1.Konfiguration {
+		konfig := newDependentKonfiguration("a")
+		konfig.SetGeneration(1)
+		konfig.Status.LastAppliedRevision = "main/1"
+		konfig.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue}}
+		konfig.Status.Inputs = buildInputs(konfig, root, "{}", map[string]string{
+			fileURL(filepath.Join(root, "main.jsonnet")): checksum([]byte("{}")),
+		}, []string{fileURL(filepath.Join(root, "shadow.libsonnet"))})
+		return konfig
+	}
+
+	tcs := []struct {
+		name     string
+		revision string
+		mutate   func(*konfigurationv1.Konfiguration)
+		files    map[string]string
+		expected bool
+	}{
+		{name: "unchanged", revision: "main/2", expected: true},
+		{name: "applied revision", revision: "main/1"},
+		{name: "changed file", revision: "main/2", files: map[string]string{"main.jsonnet": "{ a: 1 }"}},
+		{name: "shadowing file", revision: "main/2", files: map[string]string{"shadow.libsonnet": "{}"}},
+		{name: "unrelated file", revision: "main/2", files: map[string]string{"other.libsonnet": "{}"}, expected: true},
+		{name: "new generation", revision: "main/2", mutate: func(k *konfigurationv1.Konfiguration) { k.SetGeneration(2) }},
+		{name: "not ready", revision: "main/2", mutate: func(k *konfigurationv1.Konfiguration) { k.Status.Conditions[0].Status = metav1.ConditionFalse }},
+		{name: "no inputs", revision: "main/2", mutate: func(k *konfigurationv1.Konfiguration) { k.Status.Inputs = nil }},
+		{name: "new dependency outputs", revision: "main/2", mutate: func(k *konfigurationv1.Konfiguration) { k.Status.Inputs.OutputsChecksum = "" }},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			konfig := newKonfig()
+			files := map[string]string{"main.jsonnet": "{}"}
+			for name, data := range tc.files {
+				files[name] = data
+			}
+			for name, data := range files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.mutate != nil {
+				tc.mutate(konfig)
+			}
+			r := &KonfigurationReconciler{Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()}
+			unchanged, err := r.importsUnchanged(ctx, konfig, tc.revision, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unchanged != tc.expected {
+				t.Errorf("expected unchanged=%v, got %v", tc.expected, unchanged)
+			}
+		})
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
@@ -38,38 +39,54 @@ func (r *KonfigurationReconciler) requestsForRevisionChangeOf(indexKey string) f
 		if !ok {
 			panic(fmt.Sprintf("Expected an object conformed with GetArtifact() method, but got a %T", obj))
 		}
-		// If we do not have an artifact, we have no requests to make
-		if repo.GetArtifact() == nil {
-			return nil
-		}
+		return r.requestsForRevisionChange(indexKey, ObjectKey(obj).String(), repo.GetArtifact())
+	}
+}
 
-		ctx := context.Background()
-		var list konfigurationv1.KonfigurationList
-		if err := r.List(ctx, &list, client.MatchingFields{
-			indexKey: ObjectKey(obj).String(),
-		}); err != nil {
-			return nil
-		}
-		var dd []dependency.Dependent
-		for _, d := range list.Items {
-			// If the revision of the artifact equals to the last attempted revision,
-			// we should not make a request for this Kustomization
-			if repo.GetArtifact().Revision == d.Status.LastAttemptedRevision {
-				continue
-			}
-			dd = append(dd, d)
-		}
-		sorted, err := dependency.Sort(dd)
-		if err != nil {
-			return nil
-		}
-		reqs := make([]reconcile.Request, len(sorted))
-		for i := range sorted {
-			reqs[i].NamespacedName.Name = sorted[i].Name
-			reqs[i].NamespacedName.Namespace = sorted[i].Namespace
+// requestsForArtifactSourceChange returns requests for the Konfigurations referencing
+// the given artifact source, watched as an unstructured object.
+func (r *KonfigurationReconciler) requestsForArtifactSourceChange(obj client.Object) []reconcile.Request {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		panic(fmt.Sprintf("Expected an unstructured object, but got a %T", obj))
+	}
+	source := &konfigurationv1.ArtifactSource{Unstructured: u}
+	key := artifactSourceKey(u.GroupVersionKind().GroupKind(), u.GetNamespace(), u.GetName())
+	return r.requestsForRevisionChange(konfigurationv1.ArtifactSourceIndexKey, key, source.GetArtifact())
+}
+
+func (r *KonfigurationReconciler) requestsForRevisionChange(indexKey, indexValue string, artifact *sourcev1.Artifact) []reconcile.Request {
+	// If we do not have an artifact, we have no requests to make
+	if artifact == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	var list konfigurationv1.KonfigurationList
+	if err := r.List(ctx, &list, client.MatchingFields{
+		indexKey: indexValue,
+	}); err != nil {
+		return nil
+	}
+	var dd []dependency.Dependent
+	for _, d := range list.Items {
+		// If the revision of the artifact equals to the last attempted revision,
+		// we should not make a request for this Kustomization
+		if artifact.Revision == d.Status.LastAttemptedRevision {
+			continue
 		}
-		return reqs
+		dd = append(dd, d)
 	}
+	sorted, err := dependency.Sort(dd)
+	if err != nil {
+		return nil
+	}
+	reqs := make([]reconcile.Request, len(sorted))
+	for i := range sorted {
+		reqs[i].NamespacedName.Name = sorted[i].Name
+		reqs[i].NamespacedName.Namespace = sorted[i].Namespace
+	}
+	return reqs
 }
 
 func (r *KonfigurationReconciler) indexBy(kind string) func(o client.Object) []string {
@@ -80,6 +97,9 @@ func (r *KonfigurationReconciler) indexBy(kind string) func(o client.Object) []s
 		}
 
 		if k.Spec.SourceRef != nil && k.Spec.SourceRef.Kind == kind {
+			if _, generic, err := konfigurationv1.ArtifactSourceGVK(k.Spec.SourceRef); err != nil || generic {
+				return nil
+			}
 			namespace := k.GetNamespace()
 			if k.Spec.SourceRef.Namespace != "" {
 				namespace = k.Spec.SourceRef.Namespace
@@ -91,6 +111,25 @@ func (r *KonfigurationReconciler) indexBy(kind string) func(o client.Object) []s
 	}
 }
 
+func (r *KonfigurationReconciler) indexByArtifactSource(o client.Object) []string {
+	k, ok := o.(*konfigurationv1.Konfiguration)
+	if !ok {
+		panic(fmt.Sprintf("Expected a Konfiguration, got %T", o))
+	}
+	if k.Spec.SourceRef == nil {
+		return nil
+	}
+	gvk, generic, err := konfigurationv1.ArtifactSourceGVK(k.Spec.SourceRef)
+	if err != nil || !generic {
+		return nil
+	}
+	namespace := k.GetNamespace()
+	if k.Spec.SourceRef.Namespace != "" {
+		namespace = k.Spec.SourceRef.Namespace
+	}
+	return []string{artifactSourceKey(gvk.GroupKind(), namespace, k.Spec.SourceRef.Name)}
+}
+
 // ObjectKey returns client.ObjectKey for the object.
 func ObjectKey(object metav1.Object) client.ObjectKey {
 	return client.ObjectKey{
@@ -98,3 +137,33 @@ func ObjectKey(object metav1.Object) client.ObjectKey {
 		Name:      object.GetName(),
 	}
 }
+
+// requestsForDependants returns requests for the Konfigurations that depend on the
+// given one.
+func (r *KonfigurationReconciler) requestsForDependants(obj client.Object) []reconcile.Request {
+	var list konfigurationv1.KonfigurationList
+	if err := r.List(context.Background(), &list, client.MatchingFields{
+		konfigurationv1.DependsOnIndexKey: ObjectKey(obj).String(),
+	}); err != nil {
+		return nil
+	}
+	reqs := make([]reconcile.Request, len(list.Items))
+	for i := range list.Items {
+		reqs[i].NamespacedName = list.Items[i].GetNamespacedName()
+	}
+	return reqs
+}
+
+func (r *KonfigurationReconciler) indexByDependency(o client.Object) []string {
+	k, ok := o.(*konfigurationv1.Konfiguration)
+	if !ok {
+		panic(fmt.Sprintf("Expected a Konfiguration, got %T", o))
+	}
+
+	deps := dependencies(k)
+	keys := make([]string, len(deps))
+	for i, dep := range deps {
+		keys[i] = dep.String()
+	}
+	return keys
+}
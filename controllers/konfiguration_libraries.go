@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+)
+
+// libraryImporter returns the SchemeFunc importing lib://<library>/<version>/<path>
+// URLs for the Konfiguration from the JsonnetLibraries in its namespace, or else in
+// the shared library namespace. Only the revision a JsonnetLibrary last published is
+// imported, files that changed since are refused and the library is requested to be
+// reconciled again. The
+// revision of every JsonnetLibrary read is recorded in libraries.
+func (r *KonfigurationReconciler) libraryImporter(konfig *konfigurationv1.Konfiguration, libraries map[types.NamespacedName]string) jsonnet.SchemeFunc {
+	return func() (jsonnet.ImportFunc, func()) {
+		opened := make(map[string]*libraryContents)
+		f := func(ctx context.Context, u *url.URL) ([]byte, error) {
+			parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+			if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("%s is not of the form lib://library/version/path", u)
+			}
+			key := u.Host + "/" + parts[0]
+			contents, ok := opened[key]
+			if !ok {
+				lib, err := r.findLibrary(ctx, konfig.GetNamespace(), u.Host, parts[0])
+				if err != nil {
+					return nil, err
+				}
+				if contents, err = r.libraries.open(ctx, lib); err != nil {
+					return nil, fmt.Errorf("failed to read JsonnetLibrary '%s': %w", lib.GetNamespacedName(), err)
+				}
+				if err := verifyLibrary(lib, contents); err != nil {
+					contents.release()
+					r.requestLibraryResync(lib)
+					return nil, err
+				}
+				opened[key] = contents
+				libraries[lib.GetNamespacedName()] = lib.Status.Revision
+			}
+			return contents.read(parts[1])
+		}
+		release := func() {
+			for _, contents := range opened {
+				contents.release()
+			}
+		}
+		return f, release
+	}
+}
+
+// verifyLibrary returns an error if the contents of the JsonnetLibrary are not those of
+// the revision it published, which happens when its files changed since it was last
+// reconciled.
+func verifyLibrary(lib *konfigurationv1.JsonnetLibrary, contents *libraryContents) error {
+	revision, err := contents.revision(lib.Spec.Version)
+	if err != nil {
+		return fmt.Errorf("failed to read JsonnetLibrary '%s': %w", lib.GetNamespacedName(), err)
+	}
+	if revision != lib.Status.Revision {
+		return fmt.Errorf("JsonnetLibrary '%s' changed since it published revision %s, it must be reconciled again",
+			lib.GetNamespacedName(), lib.Status.Revision)
+	}
+	return nil
+}
+
+// requestLibraryResync requests the JsonnetLibrary to be reconciled again, so that it
+// publishes the revision of its current files. Konfigurations that imported it are
+// then reconciled as the revision changes. The request is dropped if too many are
+// pending, the library then publishes it at its interval.
+func (r *KonfigurationReconciler) requestLibraryResync(lib *konfigurationv1.JsonnetLibrary) {
+	select {
+	case r.libraryResyncs <- event.GenericEvent{Object: lib}:
+	default:
+	}
+}
+
+// findLibrary returns the ready JsonnetLibrary publishing the given version of a
+// library, in the given namespace or else in the shared library namespace.
+func (r *KonfigurationReconciler) findLibrary(ctx context.Context, namespace, library, version string) (*konfigurationv1.JsonnetLibrary, error) {
+	namespaces := []string{namespace}
+	if r.libraryNamespace != "" && r.libraryNamespace != namespace {
+		namespaces = append(namespaces, r.libraryNamespace)
+	}
+	for _, ns := range namespaces {
+		var list konfigurationv1.JsonnetLibraryList
+		if err := r.List(ctx, &list, client.InNamespace(ns), client.MatchingFields{
+			konfigurationv1.LibraryVersionIndexKey: library + "/" + version,
+		}); err != nil {
+			return nil, err
+		}
+		switch len(list.Items) {
+		case 0:
+			continue
+		case 1:
+			lib := &list.Items[0]
+			if !apimeta.IsStatusConditionTrue(lib.Status.Conditions, meta.ReadyCondition) || lib.Status.ObservedGeneration != lib.GetGeneration() {
+				return nil, fmt.Errorf("JsonnetLibrary '%s' is not ready", lib.GetNamespacedName())
+			}
+			return lib, nil
+		default:
+			return nil, fmt.Errorf("several JsonnetLibraries in namespace '%s' publish version %s of library %s", ns, version, library)
+		}
+	}
+	return nil, fmt.Errorf("no JsonnetLibrary publishes version %s of library %s", version, library)
+}
+
+// libraryImports returns the given revisions of imported JsonnetLibraries, keyed by
+// namespaced name, as recorded in the status.
+func libraryImports(libraries map[types.NamespacedName]string) []konfigurationv1.LibraryImport {
+	var imports []konfigurationv1.LibraryImport
+	for key, revision := range libraries {
+		imports = append(imports, konfigurationv1.LibraryImport{Namespace: key.Namespace, Name: key.Name, Revision: revision})
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].Namespace != imports[j].Namespace {
+			return imports[i].Namespace < imports[j].Namespace
+		}
+		return imports[i].Name < imports[j].Name
+	})
+	return imports
+}
+
+func (r *KonfigurationReconciler) indexByLibrary(o client.Object) []string {
+	k, ok := o.(*konfigurationv1.Konfiguration)
+	if !ok {
+		panic(fmt.Sprintf("Expected a Konfiguration, got %T", o))
+	}
+	keys := make([]string, len(k.Status.Libraries))
+	for i, lib := range k.Status.Libraries {
+		keys[i] = types.NamespacedName{Namespace: lib.Namespace, Name: lib.Name}.String()
+	}
+	return keys
+}
+
+// requestsForLibraryChange returns requests for the Konfigurations whose last build
+// imported an older revision of the given JsonnetLibrary.
+func (r *KonfigurationReconciler) requestsForLibraryChange(obj client.Object) []reconcile.Request {
+	lib, ok := obj.(*konfigurationv1.JsonnetLibrary)
+	if !ok {
+		panic(fmt.Sprintf("Expected a JsonnetLibrary, got %T", obj))
+	}
+	var list konfigurationv1.KonfigurationList
+	if err := r.List(context.Background(), &list, client.MatchingFields{
+		konfigurationv1.LibraryIndexKey: ObjectKey(obj).String(),
+	}); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, konfig := range list.Items {
+		for _, imported := range konfig.Status.Libraries {
+			if imported.Namespace == lib.GetNamespace() && imported.Name == lib.GetName() && imported.Revision != lib.Status.Revision {
+				reqs = append(reqs, reconcile.Request{NamespacedName: konfig.GetNamespacedName()})
+				break
+			}
+		}
+	}
+	return reqs
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+)
+
+// importLock returns the lock pinning the remote imports of the Konfiguration, read
+// from the lockfile in the source at root, if any, and the digests in its spec. An
+// empty lock is returned when none is configured and locks are required.
+func (r *KonfigurationReconciler) importLock(konfig *konfigurationv1.Konfiguration, root string) (*jsonnet.ImportLock, error) {
+	spec := konfig.GetImportLock()
+	if spec == nil {
+		spec = &konfigurationv1.ImportLock{}
+	}
+
+	var lock *jsonnet.ImportLock
+	if root != "" {
+		file := spec.File
+		if file == "" {
+			file = jsonnet.LockFileName
+		}
+		path, err := securejoin.SecureJoin(root, file)
+		if err != nil {
+			return nil, err
+		}
+		lock, err = jsonnet.ReadImportLock(path)
+		if err != nil && (spec.File != "" || !errors.Is(err, os.ErrNotExist)) {
+			return nil, fmt.Errorf("failed to read the import lock: %w", err)
+		}
+	} else if spec.File != "" {
+		return nil, errors.New("an import lock file requires a sourceRef, git repository, or archive")
+	}
+
+	if konfig.GetImportLock() != nil || r.access.RequireImportLock {
+		if lock == nil {
+			lock, _ = jsonnet.NewImportLock(nil)
+		}
+		if err := lock.Add(spec.Imports); err != nil {
+			return nil, fmt.Errorf("invalid import lock: %w", err)
+		}
+	}
+	return lock, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/impersonation"
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+)
+
+// errNoLookupIdentity is returned by lookups of Konfigurations that do not impersonate
+// an identity, which would otherwise read objects as the controller.
+var errNoLookupIdentity = errors.New("lookup requires a serviceAccountName or kubeConfig, objects are never read with the controller's identity")
+
+// lookupReader returns the reader for the objects the Konfiguration reads with the
+// lookup native function, with the identity it impersonates. Lookups are refused
+// for Konfigurations that do not impersonate one.
+func (r *KonfigurationReconciler) lookupReader(konfig *konfigurationv1.Konfiguration, imp impersonation.Impersonation, kubeClient client.Client) client.Reader {
+	if !imp.Impersonating() {
+		return deniedReader{err: errNoLookupIdentity}
+	}
+	var reader client.Reader = kubeClient
+	if r.access.NoCrossNamespaceRefs {
+		return &lookupNamespaceReader{Reader: reader, mapper: kubeClient.RESTMapper(), access: &r.access, namespace: konfig.GetNamespace()}
+	}
+	return reader
+}
+
+// lookupNamespaceReader refuses to read namespaced objects outside of the namespace of
+// a Konfiguration, including lists across all namespaces.
+type lookupNamespaceReader struct {
+	client.Reader
+	mapper    apimeta.RESTMapper
+	access    *AccessOptions
+	namespace string
+}
+
+// Get implements client.Reader.
+func (n *lookupNamespaceReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if key.Namespace != "" {
+		if err := n.access.checkSourceNamespace(obj.GetObjectKind().GroupVersionKind().Kind, key.Namespace, key.Name, n.namespace); err != nil {
+			return err
+		}
+	}
+	return n.Reader.Get(ctx, key, obj)
+}
+
+// List implements client.Reader.
+func (n *lookupNamespaceReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	kind := list.GetObjectKind().GroupVersionKind()
+	namespace := listOpts.Namespace
+	if namespace == "" {
+		// Only cluster-scoped objects may be listed without a namespace
+		mapping, err := n.mapper.RESTMapping(kind.GroupKind(), kind.Version)
+		if err != nil {
+			return err
+		}
+		if mapping.Scope.Name() == apimeta.RESTScopeNameRoot {
+			return n.Reader.List(ctx, list, opts...)
+		}
+		namespace = "*"
+	}
+	if err := n.access.checkSourceNamespace(kind.Kind, namespace, "", n.namespace); err != nil {
+		return err
+	}
+	return n.Reader.List(ctx, list, opts...)
+}
+
+// deniedReader refuses every read with an error.
+type deniedReader struct {
+	err error
+}
+
+// Get implements client.Reader.
+func (d deniedReader) Get(context.Context, client.ObjectKey, client.Object) error { return d.err }
+
+// List implements client.Reader.
+func (d deniedReader) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return d.err
+}
+
+// objectLookups returns the lookups of a build as recorded in the status.
+func objectLookups(lookups []jsonnet.Lookup) []konfigurationv1.ObjectLookup {
+	var out []konfigurationv1.ObjectLookup
+	for _, l := range lookups {
+		out = append(out, konfigurationv1.ObjectLookup{
+			APIVersion: l.APIVersion,
+			Kind:       l.Kind,
+			Namespace:  l.Namespace,
+			Name:       l.Name,
+			Checksum:   l.Checksum,
+		})
+	}
+	return out
+}
+
+// lookupsChanged reads the objects looked up by the last build of the Konfiguration
+// again, with its identity, and returns a description of those that changed.
+func (r *KonfigurationReconciler) lookupsChanged(ctx context.Context, konfig *konfigurationv1.Konfiguration) ([]string, error) {
+	if len(konfig.Status.Lookups) == 0 {
+		return nil, nil
+	}
+	imp := impersonation.NewImpersonation(konfig, r.Client, r.impersonationOpts)
+	kubeClient, err := imp.GetClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reader := r.lookupReader(konfig, imp, kubeClient)
+	var changes []string
+	for _, l := range konfig.Status.Lookups {
+		sum, err := jsonnet.LookupChecksum(ctx, reader, jsonnet.Lookup{
+			APIVersion: l.APIVersion,
+			Kind:       l.Kind,
+			Namespace:  l.Namespace,
+			Name:       l.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sum != l.Checksum {
+			changes = append(changes, fmt.Sprintf("%s/%s/%s/%s@sha256:%s", l.APIVersion, l.Kind, l.Namespace, l.Name, sum))
+		}
+	}
+	return changes, nil
+}
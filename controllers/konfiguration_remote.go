@@ -0,0 +1,156 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// remoteRevision returns the revision of the file at an HTTP(S) path, its URL and
+// the digest of its current contents. Paths to directories are resolved to their
+// main.jsonnet like at build time.
+func (r *KonfigurationReconciler) remoteRevision(ctx context.Context, path string) (string, error) {
+	if strings.HasSuffix(path, "/") {
+		path += "main.jsonnet"
+	}
+	_, sum, err := r.remoteFiles.Get(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	return fmt.Sprintf("%s@sha256:%s", path, sum), nil
+}
+
+// archiveRevision returns the revision of an HTTP(S) archive, its URL and expected
+// digest.
+func archiveRevision(archive *konfigurationv1.HTTPArchive) string {
+	return fmt.Sprintf("%s@sha256:%s", archive.URL, strings.TrimPrefix(archive.Checksum, "sha256:"))
+}
+
+// remoteImports returns the files imported over HTTP(S) from the given checksums of
+// the imports of a build, keyed by URL.
+func remoteImports(imports map[string]string) []konfigurationv1.RemoteImport {
+	var remotes []konfigurationv1.RemoteImport
+	for foundAt, sum := range imports {
+		if !isRemotePath(foundAt) || sum == "" {
+			continue
+		}
+		remotes = append(remotes, konfigurationv1.RemoteImport{URL: foundAt, Checksum: sum})
+	}
+	sort.Slice(remotes, func(i, j int) bool { return remotes[i].URL < remotes[j].URL })
+	return remotes
+}
+
+// pollRemotes checks the HTTP(S) paths, remote imports and lookups of all Konfigurations
+// at the given interval, and requests a reconcile of those whose contents changed, until
+// the context is done.
+func (r *KonfigurationReconciler) pollRemotes(ctx context.Context, interval time.Duration) {
+	reqLogger := log.FromContext(ctx).WithName("remote-poller")
+	// The digests of the changes last notified for every Konfiguration, so that a
+	// change is only notified once even when its reconcile fails.
+	notified := make(map[types.NamespacedName]string)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var list konfigurationv1.KonfigurationList
+		if err := r.List(ctx, &list); err != nil {
+			reqLogger.Error(err, "Failed to list Konfigurations")
+			continue
+		}
+		seen := make(map[types.NamespacedName]struct{}, len(list.Items))
+		for i := range list.Items {
+			konfig := &list.Items[i]
+			if konfig.Spec.Suspend {
+				continue
+			}
+			key := konfig.GetNamespacedName()
+			seen[key] = struct{}{}
+			changed, err := r.remoteChanged(ctx, konfig)
+			if err != nil {
+				reqLogger.Info("Failed to poll remote files and lookups", "konfiguration", key.String(), "error", err.Error())
+				continue
+			}
+			if changed == "" || changed == notified[key] {
+				continue
+			}
+			notified[key] = changed
+			reqLogger.Info("Remote files or looked up objects changed, requesting reconcile", "konfiguration", key.String())
+			select {
+			case r.remoteChanges <- event.GenericEvent{Object: konfig}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for key := range notified {
+			if _, ok := seen[key]; !ok {
+				delete(notified, key)
+			}
+		}
+	}
+}
+
+// remoteChanged fetches the HTTP(S) path, the remote imports and the looked up objects
+// of the Konfiguration, and returns a description of their contents if they changed
+// since it was last reconciled, or an empty string. Konfigurations denied by the access
+// options are not polled, they fail to reconcile until their spec changes.
+func (r *KonfigurationReconciler) remoteChanged(ctx context.Context, konfig *konfigurationv1.Konfiguration) (string, error) {
+	if r.access.checkAccess(konfig) != nil {
+		return "", nil
+	}
+	changes, err := r.lookupsChanged(ctx, konfig)
+	if err != nil {
+		return "", err
+	}
+	if r.access.NoRemoteBases {
+		return strings.Join(changes, ","), nil
+	}
+	if path := konfig.GetPath(); konfig.GetSourceRef() == nil && konfig.GetGit() == nil && konfig.GetArchive() == nil && isRemotePath(path) {
+		revision, err := r.remoteRevision(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		if revision != konfig.Status.LastAttemptedRevision {
+			changes = append(changes, revision)
+		}
+	}
+	for _, imp := range konfig.Status.RemoteImports {
+		_, sum, err := r.remoteFiles.Get(ctx, imp.URL)
+		if err != nil {
+			return "", err
+		}
+		if sum != imp.Checksum {
+			changes = append(changes, fmt.Sprintf("%s@sha256:%s", imp.URL, sum))
+		}
+	}
+	return strings.Join(changes, ","), nil
+}
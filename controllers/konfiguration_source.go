@@ -20,22 +20,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"os"
 
-	"github.com/fluxcd/pkg/untar"
+	"github.com/fluxcd/pkg/runtime/events"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
-	"github.com/hashicorp/go-retryablehttp"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
 )
 
-func (r *KonfigurationReconciler) prepareSource(ctx context.Context, konfig *konfigurationv1.Konfiguration) (revision, path string, clean func(), err error) {
+// prepareSource returns the revision and path to build for the Konfiguration. When
+// it references a source, the artifact is extracted and its directory returned as root,
+// and likewise when it configures a git repository or an archive. The directory is
+// shared with other reconciles and must not be modified. The revision of an HTTP(S)
+// path is the digest of its current contents.
+func (r *KonfigurationReconciler) prepareSource(ctx context.Context, konfig *konfigurationv1.Konfiguration) (revision, root, path string, clean func(), err error) {
 	reqLogger := log.FromContext(ctx)
 
 	// Initially set paths to those defined in spec. If we are running
@@ -74,68 +75,97 @@ func (r *KonfigurationReconciler) prepareSource(ctx context.Context, konfig *kon
 		artifact := source.GetArtifact()
 		revision = artifact.Revision
 
-		// Create a temp directory for the artifact
-		var tmpDir string
-		tmpDir, err = ioutil.TempDir("", konfig.GetName())
+		// Get the artifact from the cache, downloading it if necessary
+		var release func()
+		root, release, err = r.artifacts.Get(ctx, r.fetcher, artifact)
 		if err != nil {
-			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(artifact.Revision, sourcev1.StorageOperationFailedReason, err.Error())); statusErr != nil {
+			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(artifact.Revision, konfigurationv1.ArtifactFailedReason, err.Error())); statusErr != nil {
 				reqLogger.Error(statusErr, "Failed to update Konfiguration status")
 			}
-			reqLogger.Error(err, "Could not allocate a temp directory for source artifact")
+			reqLogger.Error(err, "Failed to download source artifact")
+			var checksumErr *artifacts.ChecksumError
+			if errors.As(err, &checksumErr) || errors.Is(err, artifacts.ErrSizeLimit) {
+				r.event(ctx, konfig, &EventData{
+					Revision: artifact.Revision,
+					Severity: events.EventSeverityError,
+					Message:  err.Error(),
+				})
+			}
 			return
 		}
 
-		// Download and extract the artifact
-		if err = r.downloadAndExtractTo(artifact.URL, tmpDir); err != nil {
+		path, err = securejoin.SecureJoin(root, path)
+		if err != nil {
 			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(artifact.Revision, konfigurationv1.ArtifactFailedReason, err.Error())); statusErr != nil {
 				reqLogger.Error(statusErr, "Failed to update Konfiguration status")
 			}
-			reqLogger.Error(err, "Failed to download source artifact")
+			reqLogger.Error(err, "Failed to format path relative to the artifact directory")
+			release()
 			return
 		}
 
-		path, err = securejoin.SecureJoin(tmpDir, path)
+		clean = release
+	} else if konfig.GetGit() != nil {
+		var release func()
+		revision, root, release, err = r.checkoutGit(ctx, konfig)
 		if err != nil {
-			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(artifact.Revision, konfigurationv1.ArtifactFailedReason, err.Error())); statusErr != nil {
+			return
+		}
+
+		path, err = securejoin.SecureJoin(root, path)
+		if err != nil {
+			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(revision, konfigurationv1.GitOperationFailedReason, err.Error())); statusErr != nil {
 				reqLogger.Error(statusErr, "Failed to update Konfiguration status")
 			}
-			reqLogger.Error(err, "Failed to format path relative to tmp directory")
+			reqLogger.Error(err, "Failed to format path relative to the repository directory")
+			release()
+			return
 		}
 
-		clean = func() { os.RemoveAll(tmpDir) }
-	}
+		clean = release
+	} else if archive := konfig.GetArchive(); archive != nil {
+		revision = archiveRevision(archive)
+		artifact := &sourcev1.Artifact{URL: archive.URL, Checksum: archive.Checksum, Revision: revision}
 
-	return
-}
-
-func (r *KonfigurationReconciler) downloadAndExtractTo(artifactURL, tmpDir string) error {
-	if hostname := os.Getenv("SOURCE_CONTROLLER_LOCALHOST"); hostname != "" {
-		u, err := url.Parse(artifactURL)
+		var release func()
+		root, release, err = r.artifacts.Get(ctx, r.fetcher, artifact)
 		if err != nil {
-			return err
+			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(revision, konfigurationv1.ArtifactFailedReason, err.Error())); statusErr != nil {
+				reqLogger.Error(statusErr, "Failed to update Konfiguration status")
+			}
+			reqLogger.Error(err, "Failed to download archive")
+			var checksumErr *artifacts.ChecksumError
+			if errors.As(err, &checksumErr) || errors.Is(err, artifacts.ErrSizeLimit) {
+				r.event(ctx, konfig, &EventData{
+					Revision: revision,
+					Severity: events.EventSeverityError,
+					Message:  err.Error(),
+				})
+			}
+			return
 		}
-		u.Host = hostname
-		artifactURL = u.String()
-	}
 
-	req, err := retryablehttp.NewRequest(http.MethodGet, artifactURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create a new request: %w", err)
-	}
-
-	resp, err := r.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to download artifact, error: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download artifact from %s, status: %s", artifactURL, resp.Status)
-	}
+		path, err = securejoin.SecureJoin(root, path)
+		if err != nil {
+			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta(revision, konfigurationv1.ArtifactFailedReason, err.Error())); statusErr != nil {
+				reqLogger.Error(statusErr, "Failed to update Konfiguration status")
+			}
+			reqLogger.Error(err, "Failed to format path relative to the archive directory")
+			release()
+			return
+		}
 
-	if _, err = untar.Untar(resp.Body, tmpDir); err != nil {
-		return fmt.Errorf("failed to untar artifact, error: %w", err)
+		clean = release
+	} else if isRemotePath(path) {
+		revision, err = r.remoteRevision(ctx, path)
+		if err != nil {
+			if statusErr := konfig.SetNotReady(ctx, r.Client, konfigurationv1.NewStatusMeta("", konfigurationv1.ArtifactFailedReason, err.Error())); statusErr != nil {
+				reqLogger.Error(statusErr, "Failed to update Konfiguration status")
+			}
+			reqLogger.Error(err, "Failed to fetch remote path")
+			return
+		}
 	}
 
-	return nil
+	return
 }
@@ -19,18 +19,22 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"time"
 
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
 	"github.com/pelotech/jsonnet-controller/pkg/impersonation"
 	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+	"github.com/pelotech/jsonnet-controller/pkg/policy"
 )
 
 // DryRunFunc returns the http handler for checking what a given Konfiguration
@@ -42,6 +46,19 @@ func (r *KonfigurationReconciler) DryRunFunc() http.Handler {
 
 		defer req.Body.Close()
 
+		var user *authenticationv1.UserInfo
+		if r.buildAuth {
+			var err error
+			if user, err = r.authenticateBuild(ctx, req); err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, errUnauthenticated) {
+					status = http.StatusUnauthorized
+				}
+				r.returnError(w, status, err.Error())
+				return
+			}
+		}
+
 		reader := yaml.NewYAMLOrJSONDecoder(req.Body, 2048)
 		var konfig konfigurationv1.Konfiguration
 		err := reader.Decode(&konfig)
@@ -52,6 +69,18 @@ func (r *KonfigurationReconciler) DryRunFunc() http.Handler {
 
 		r.HTTPLog.Info(fmt.Sprintf("Dry run request for %s/%s", konfig.GetNamespace(), konfig.GetName()))
 
+		if user != nil {
+			if err := r.authorizeBuild(ctx, user, &konfig); err != nil {
+				r.returnError(w, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+
+		if err := r.access.checkAccess(&konfig); err != nil {
+			r.returnError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
 		var lastErr error
 		for {
 			select {
@@ -62,7 +91,7 @@ func (r *KonfigurationReconciler) DryRunFunc() http.Handler {
 				if lastErr != nil {
 					time.Sleep(time.Second)
 				}
-				_, path, clean, err := r.prepareSource(ctx, &konfig)
+				_, root, path, clean, err := r.prepareSource(ctx, &konfig)
 				if err != nil {
 					if client.IgnoreNotFound(err) == nil {
 						r.returnError(w, http.StatusInternalServerError, err.Error())
@@ -79,7 +108,7 @@ func (r *KonfigurationReconciler) DryRunFunc() http.Handler {
 				}
 				defer os.RemoveAll(dirPath)
 
-				imp := impersonation.NewImpersonation(&konfig, r.Client)
+				imp := impersonation.NewImpersonation(&konfig, r.Client, r.impersonationOpts)
 				kubeClient, err := imp.GetClient(ctx)
 
 				if err != nil {
@@ -87,7 +116,19 @@ func (r *KonfigurationReconciler) DryRunFunc() http.Handler {
 					return
 				}
 
-				builder, err := jsonnet.NewBuilder(&konfig, dirPath, r.jsonnetCache)
+				dependencyOutputs, err := r.dependencyOutputs(ctx, &konfig)
+				if err != nil {
+					r.returnError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+
+				lock, err := r.importLock(&konfig, root)
+				if err != nil {
+					r.returnError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+
+				builder, err := jsonnet.NewBuilder(&konfig, dirPath, r.builderOptions(&konfig, imp, kubeClient, make(map[types.NamespacedName]string), dependencyOutputs, root, lock)...)
 				if err != nil {
 					r.returnError(w, http.StatusInternalServerError, err.Error())
 					return
@@ -99,6 +140,16 @@ func (r *KonfigurationReconciler) DryRunFunc() http.Handler {
 					return
 				}
 
+				if err := r.checkPolicies(ctx, &konfig, kubeClient.RESTMapper(), buildOutput.SortedObjects()); err != nil {
+					var violations *policy.ViolationError
+					if errors.As(err, &violations) {
+						r.returnError(w, http.StatusForbidden, err.Error())
+						return
+					}
+					r.returnError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+
 				stream, err := buildOutput.YAMLStream()
 				if err != nil {
 					r.returnError(w, http.StatusInternalServerError, err.Error())
@@ -0,0 +1,430 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/predicates"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	kuberecorder "k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/go-retryablehttp"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
+)
+
+// KonfigurationSetReconciler reconciles a KonfigurationSet object
+type KonfigurationSetReconciler struct {
+	client.Client
+	Scheme        *runtime.Scheme
+	EventRecorder kuberecorder.EventRecorder
+
+	fetcher   *artifacts.Fetcher
+	artifacts *artifacts.Cache
+	access    AccessOptions
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KonfigurationSetReconciler) SetupWithManager(log logr.Logger, mgr ctrl.Manager, opts *ReconcilerOptions) error {
+	// Set up an http client for fetching artifacts
+	httpClient := retryablehttp.NewClient()
+	httpClient.RetryWaitMin = 5 * time.Second
+	httpClient.RetryWaitMax = 30 * time.Second
+	httpClient.RetryMax = opts.HTTPRetryMax
+	httpClient.Logger = nil
+	r.fetcher = &artifacts.Fetcher{HTTPClient: httpClient, MaxSize: opts.ArtifactMaxSize}
+	r.artifacts = opts.ArtifactCache
+	r.access = opts.Access
+
+	// Index the KonfigurationSets by the sources their generators (may) point at.
+	if err := mgr.GetCache().IndexField(context.TODO(), &konfigurationv1.KonfigurationSet{}, konfigurationv1.KonfigurationSetSourceIndexKey,
+		r.indexBySource); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&konfigurationv1.KonfigurationSet{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}),
+		)).
+		Owns(&konfigurationv1.Konfiguration{}, builder.WithPredicates(GeneratedKonfigurationChangePredicate{})).
+		Watches(
+			&source.Kind{Type: &sourcev1.GitRepository{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForSourceChangeOf(sourcev1.GitRepositoryKind)),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &sourcev1.Bucket{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForSourceChangeOf(sourcev1.BucketKind)),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &sourcev1.HelmChart{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForSourceChangeOf(sourcev1.HelmChartKind)),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForClusterSecret),
+		)
+	for _, obj := range artifactSourceWatches(log, mgr, opts.ArtifactSourceKinds) {
+		b = b.Watches(
+			&source.Kind{Type: obj},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForArtifactSourceChange),
+			builder.WithPredicates(SourceRevisionChangePredicate{}),
+		)
+	}
+	return b.WithOptions(
+		controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles},
+	).Complete(r)
+}
+
+// +kubebuilder:rbac:groups=jsonnet.io,resources=konfigurationsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=jsonnet.io,resources=konfigurationsets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=jsonnet.io,resources=konfigurationsets/finalizers,verbs=update
+
+// Reconcile runs the generators of a KonfigurationSet and creates, updates, and deletes
+// the Konfigurations they produce.
+func (r *KonfigurationSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.FromContext(ctx)
+
+	reqLogger.Info("Reconciling konfigurationset")
+
+	set := &konfigurationv1.KonfigurationSet{}
+	if err := r.Client.Get(ctx, req.NamespacedName, set); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Generated Konfigurations are garbage-collected through their owner references
+	if !set.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if set.IsSuspended() {
+		return ctrl.Result{RequeueAfter: set.GetInterval()}, nil
+	}
+
+	// Run the generators and render the template for every set of parameters
+	desired, err := r.desiredKonfigurations(ctx, set)
+	if err != nil {
+		reqLogger.Error(err, "Failed to generate Konfigurations")
+		r.EventRecorder.Event(set, corev1.EventTypeWarning, konfigurationv1.GenerationFailedReason, err.Error())
+		if statusErr := set.SetNotReady(ctx, r.Client, konfigurationv1.GenerationFailedReason, err.Error()); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update KonfigurationSet status")
+		}
+		return ctrl.Result{RequeueAfter: set.GetInterval()}, nil
+	}
+
+	// Create or update the generated Konfigurations
+	generated := make([]*konfigurationv1.Konfiguration, 0, len(desired))
+	for _, konfig := range desired {
+		applied, err := r.applyKonfiguration(ctx, set, konfig)
+		if err != nil {
+			reqLogger.Error(err, "Failed to apply generated Konfiguration", "Konfiguration", konfig.GetName())
+			r.EventRecorder.Event(set, corev1.EventTypeWarning, meta.ReconciliationFailedReason, err.Error())
+			if statusErr := set.SetNotReady(ctx, r.Client, meta.ReconciliationFailedReason, err.Error()); statusErr != nil {
+				reqLogger.Error(statusErr, "Failed to update KonfigurationSet status")
+			}
+			return ctrl.Result{RequeueAfter: set.GetInterval()}, nil
+		}
+		generated = append(generated, applied)
+	}
+
+	// Remove any Konfigurations that are no longer generated
+	if err := r.deleteOrphaned(ctx, set, desired); err != nil {
+		reqLogger.Error(err, "Failed to delete orphaned Konfigurations")
+		r.EventRecorder.Event(set, corev1.EventTypeWarning, meta.ReconciliationFailedReason, err.Error())
+		if statusErr := set.SetNotReady(ctx, r.Client, meta.ReconciliationFailedReason, err.Error()); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update KonfigurationSet status")
+		}
+		return ctrl.Result{RequeueAfter: set.GetInterval()}, nil
+	}
+
+	// Aggregate the status of the generated Konfigurations
+	status, reason, msg, entries := aggregateStatus(generated)
+	if err := set.SetReadiness(ctx, r.Client, status, reason, msg, entries); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	reqLogger.Info(fmt.Sprintf("Reconcile finished, next run in %s", set.GetInterval().String()))
+	return ctrl.Result{RequeueAfter: set.GetInterval()}, nil
+}
+
+// desiredKonfigurations returns the Konfigurations that should exist for the given set.
+func (r *KonfigurationSetReconciler) desiredKonfigurations(ctx context.Context, set *konfigurationv1.KonfigurationSet) ([]*konfigurationv1.Konfiguration, error) {
+	params, err := r.generate(ctx, set)
+	if err != nil {
+		return nil, err
+	}
+	desired := make([]*konfigurationv1.Konfiguration, 0, len(params))
+	seen := make(map[string]struct{}, len(params))
+	for _, p := range params {
+		konfig, err := renderTemplate(set, p)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[konfig.GetName()]; ok {
+			return nil, fmt.Errorf("template rendered duplicate name '%s'", konfig.GetName())
+		}
+		seen[konfig.GetName()] = struct{}{}
+		if err := controllerutil.SetControllerReference(set, konfig, r.Scheme); err != nil {
+			return nil, err
+		}
+		desired = append(desired, konfig)
+	}
+	return desired, nil
+}
+
+// applyKonfiguration creates the given Konfiguration, or updates it if it already exists
+// and differs. The Konfiguration in the cluster is returned.
+func (r *KonfigurationSetReconciler) applyKonfiguration(ctx context.Context, set *konfigurationv1.KonfigurationSet, konfig *konfigurationv1.Konfiguration) (*konfigurationv1.Konfiguration, error) {
+	reqLogger := log.FromContext(ctx)
+
+	var existing konfigurationv1.Konfiguration
+	if err := r.Get(ctx, konfig.GetNamespacedName(), &existing); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		reqLogger.Info(fmt.Sprintf("Creating Konfiguration '%s'", konfig.GetName()))
+		if err := r.Create(ctx, konfig); err != nil {
+			return nil, fmt.Errorf("failed to create Konfiguration '%s': %w", konfig.GetName(), err)
+		}
+		r.EventRecorder.Event(set, corev1.EventTypeNormal, "Created", fmt.Sprintf("Created Konfiguration '%s'", konfig.GetName()))
+		return konfig, nil
+	}
+
+	if !metav1.IsControlledBy(&existing, set) {
+		return nil, fmt.Errorf("Konfiguration '%s' already exists and is not managed by this KonfigurationSet", konfig.GetName())
+	}
+
+	// The stored Konfiguration carries the defaults of the admission webhook and the
+	// CRD schema, so compare against the rendered one with the same defaults applied.
+	desired := konfig.DeepCopy()
+	desired.Default()
+	if konfigurationMatches(&existing, desired) {
+		return &existing, nil
+	}
+	updated := existing.DeepCopy()
+	updated.Spec = desired.Spec
+	updated.SetLabels(desired.GetLabels())
+	updated.SetAnnotations(desired.GetAnnotations())
+	if err := r.Update(ctx, updated, client.DryRunAll); err != nil {
+		return nil, fmt.Errorf("failed to update Konfiguration '%s': %w", konfig.GetName(), err)
+	}
+	if konfigurationMatches(&existing, updated) {
+		return &existing, nil
+	}
+
+	reqLogger.Info(fmt.Sprintf("Updating Konfiguration '%s'", konfig.GetName()))
+	if err := r.Update(ctx, updated); err != nil {
+		return nil, fmt.Errorf("failed to update Konfiguration '%s': %w", konfig.GetName(), err)
+	}
+	r.EventRecorder.Event(set, corev1.EventTypeNormal, "Updated", fmt.Sprintf("Updated Konfiguration '%s'", konfig.GetName()))
+	return updated, nil
+}
+
+// konfigurationMatches returns whether the spec, labels, and annotations of the
+// given Konfigurations are equal.
+func konfigurationMatches(existing, desired *konfigurationv1.Konfiguration) bool {
+	return equality.Semantic.DeepEqual(existing.Spec, desired.Spec) &&
+		equality.Semantic.DeepEqual(existing.GetLabels(), desired.GetLabels()) &&
+		equality.Semantic.DeepEqual(existing.GetAnnotations(), desired.GetAnnotations())
+}
+
+// deleteOrphaned removes the Konfigurations controlled by the set that are not in desired.
+func (r *KonfigurationSetReconciler) deleteOrphaned(ctx context.Context, set *konfigurationv1.KonfigurationSet, desired []*konfigurationv1.Konfiguration) error {
+	reqLogger := log.FromContext(ctx)
+
+	var list konfigurationv1.KonfigurationList
+	if err := r.List(ctx, &list, client.InNamespace(set.GetNamespace()), client.MatchingLabels{
+		konfigurationv1.KonfigurationSetNameLabel: set.GetName(),
+	}); err != nil {
+		return err
+	}
+
+	keep := make(map[string]struct{}, len(desired))
+	for _, konfig := range desired {
+		keep[konfig.GetName()] = struct{}{}
+	}
+
+	for i := range list.Items {
+		konfig := &list.Items[i]
+		if _, ok := keep[konfig.GetName()]; ok || !metav1.IsControlledBy(konfig, set) || !konfig.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		reqLogger.Info(fmt.Sprintf("Deleting Konfiguration '%s'", konfig.GetName()))
+		if err := r.Delete(ctx, konfig); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete Konfiguration '%s': %w", konfig.GetName(), err)
+		}
+		r.EventRecorder.Event(set, corev1.EventTypeNormal, "Deleted", fmt.Sprintf("Deleted Konfiguration '%s'", konfig.GetName()))
+	}
+	return nil
+}
+
+// aggregateStatus computes the readiness of a set from the Konfigurations it generated.
+func aggregateStatus(konfigs []*konfigurationv1.Konfiguration) (metav1.ConditionStatus, string, string, []konfigurationv1.KonfigurationSetEntry) {
+	entries := make([]konfigurationv1.KonfigurationSetEntry, 0, len(konfigs))
+	var notReady, progressing []string
+
+	for _, konfig := range konfigs {
+		entry := konfigurationSetEntry(konfig)
+		switch entry.Ready {
+		case metav1.ConditionFalse:
+			notReady = append(notReady, konfig.GetName())
+		case metav1.ConditionUnknown:
+			progressing = append(progressing, konfig.GetName())
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	total := len(konfigs)
+	switch {
+	case len(notReady) > 0:
+		sort.Strings(notReady)
+		return metav1.ConditionFalse, meta.ReconciliationFailedReason,
+			fmt.Sprintf("%d of %d Konfigurations are not ready: %s", len(notReady), total, strings.Join(notReady, ", ")), entries
+	case len(progressing) > 0:
+		sort.Strings(progressing)
+		return metav1.ConditionUnknown, meta.ProgressingReason,
+			fmt.Sprintf("%d of %d Konfigurations are progressing: %s", len(progressing), total, strings.Join(progressing, ", ")), entries
+	default:
+		return metav1.ConditionTrue, meta.ReconciliationSucceededReason,
+			fmt.Sprintf("%d of %d Konfigurations are ready", total, total), entries
+	}
+}
+
+// konfigurationSetEntry returns the status entry of a generated Konfiguration. Its
+// readiness is unknown until the current generation has been observed.
+func konfigurationSetEntry(konfig *konfigurationv1.Konfiguration) konfigurationv1.KonfigurationSetEntry {
+	entry := konfigurationv1.KonfigurationSetEntry{
+		Name:                konfig.GetName(),
+		Ready:               metav1.ConditionUnknown,
+		LastAppliedRevision: konfig.Status.LastAppliedRevision,
+	}
+	if c := apimeta.FindStatusCondition(konfig.Status.Conditions, meta.ReadyCondition); c != nil && konfig.Status.ObservedGeneration == konfig.GetGeneration() {
+		entry.Ready = c.Status
+		entry.Message = c.Message
+	}
+	return entry
+}
+
+func (r *KonfigurationSetReconciler) indexBySource(o client.Object) []string {
+	set, ok := o.(*konfigurationv1.KonfigurationSet)
+	if !ok {
+		panic(fmt.Sprintf("Expected a KonfigurationSet, got %T", o))
+	}
+	var keys []string
+	for _, gen := range set.Spec.Generators {
+		if gen.GitDirectories == nil {
+			continue
+		}
+		ref := gen.GitDirectories.SourceRef
+		namespace := set.GetNamespace()
+		if ref.Namespace != "" {
+			namespace = ref.Namespace
+		}
+		if gvk, generic, err := konfigurationv1.ArtifactSourceGVK(&ref); err == nil && generic {
+			keys = append(keys, artifactSourceKey(gvk.GroupKind(), namespace, ref.Name))
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%s/%s/%s", ref.Kind, namespace, ref.Name))
+	}
+	return keys
+}
+
+func (r *KonfigurationSetReconciler) requestsForSourceChangeOf(kind string) func(obj client.Object) []reconcile.Request {
+	return func(obj client.Object) []reconcile.Request {
+		var list konfigurationv1.KonfigurationSetList
+		if err := r.List(context.Background(), &list, client.MatchingFields{
+			konfigurationv1.KonfigurationSetSourceIndexKey: fmt.Sprintf("%s/%s/%s", kind, obj.GetNamespace(), obj.GetName()),
+		}); err != nil {
+			return nil
+		}
+		reqs := make([]reconcile.Request, len(list.Items))
+		for i := range list.Items {
+			reqs[i].NamespacedName = list.Items[i].GetNamespacedName()
+		}
+		return reqs
+	}
+}
+
+// requestsForArtifactSourceChange returns requests for the KonfigurationSets whose
+// generators reference the given artifact source, watched as an unstructured object.
+func (r *KonfigurationSetReconciler) requestsForArtifactSourceChange(obj client.Object) []reconcile.Request {
+	var list konfigurationv1.KonfigurationSetList
+	if err := r.List(context.Background(), &list, client.MatchingFields{
+		konfigurationv1.KonfigurationSetSourceIndexKey: artifactSourceKey(obj.GetObjectKind().GroupVersionKind().GroupKind(), obj.GetNamespace(), obj.GetName()),
+	}); err != nil {
+		return nil
+	}
+	reqs := make([]reconcile.Request, len(list.Items))
+	for i := range list.Items {
+		reqs[i].NamespacedName = list.Items[i].GetNamespacedName()
+	}
+	return reqs
+}
+
+func (r *KonfigurationSetReconciler) requestsForClusterSecret(obj client.Object) []reconcile.Request {
+	var list konfigurationv1.KonfigurationSetList
+	if err := r.List(context.Background(), &list, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+Sets:
+	for _, set := range list.Items {
+		for _, gen := range set.Spec.Generators {
+			if gen.Clusters == nil {
+				continue
+			}
+			selector, err := clusterSecretSelector(gen.Clusters)
+			if err != nil {
+				continue
+			}
+			if selector.Matches(labels.Set(obj.GetLabels())) {
+				reqs = append(reqs, reconcile.Request{NamespacedName: set.GetNamespacedName()})
+				continue Sets
+			}
+		}
+	}
+	return reqs
+}
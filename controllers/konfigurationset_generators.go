@@ -0,0 +1,238 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// generatorParams is a single set of parameters produced by a generator.
+type generatorParams map[string]string
+
+// generate runs all the generators of the given KonfigurationSet and returns the
+// combined list of parameters.
+func (r *KonfigurationSetReconciler) generate(ctx context.Context, set *konfigurationv1.KonfigurationSet) ([]generatorParams, error) {
+	params := make([]generatorParams, 0)
+	for i, gen := range set.Spec.Generators {
+		var (
+			out []generatorParams
+			err error
+		)
+		switch {
+		case gen.List != nil:
+			out = r.generateFromList(gen.List)
+		case gen.Clusters != nil:
+			out, err = r.generateFromClusters(ctx, set.GetNamespace(), gen.Clusters)
+		case gen.GitDirectories != nil:
+			out, err = r.generateFromGitDirectories(ctx, set, gen.GitDirectories)
+		default:
+			err = errors.New("no generator configured")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("generator %d: %w", i, err)
+		}
+		params = append(params, out...)
+	}
+	return params, nil
+}
+
+func (r *KonfigurationSetReconciler) generateFromList(gen *konfigurationv1.ListGenerator) []generatorParams {
+	params := make([]generatorParams, len(gen.Elements))
+	for i, elem := range gen.Elements {
+		params[i] = make(generatorParams, len(elem))
+		for k, v := range elem {
+			params[i][k] = v
+		}
+	}
+	return params
+}
+
+func (r *KonfigurationSetReconciler) generateFromClusters(ctx context.Context, namespace string, gen *konfigurationv1.ClustersGenerator) ([]generatorParams, error) {
+	selector, err := clusterSecretSelector(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("failed to list cluster secrets: %w", err)
+	}
+
+	// Sort for stable output
+	sort.Slice(secrets.Items, func(i, j int) bool { return secrets.Items[i].GetName() < secrets.Items[j].GetName() })
+
+	params := make([]generatorParams, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		p := generatorParams{
+			"name":       secret.GetName(),
+			"secretName": secret.GetName(),
+		}
+		for k, v := range secret.GetLabels() {
+			p["labels."+k] = v
+		}
+		if name, ok := secret.GetLabels()[konfigurationv1.ClusterNameLabel]; ok && name != "" {
+			p["name"] = name
+		}
+		for k, v := range gen.Values {
+			p[k] = v
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// clusterSecretSelector returns the selector to use for the given clusters generator.
+// When no selector is configured, all secrets carrying the cluster-name label are matched.
+func clusterSecretSelector(gen *konfigurationv1.ClustersGenerator) (labels.Selector, error) {
+	if gen.Selector == nil || (len(gen.Selector.MatchLabels) == 0 && len(gen.Selector.MatchExpressions) == 0) {
+		req, err := labels.NewRequirement(konfigurationv1.ClusterNameLabel, selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		return labels.NewSelector().Add(*req), nil
+	}
+	return metav1.LabelSelectorAsSelector(gen.Selector)
+}
+
+func (r *KonfigurationSetReconciler) generateFromGitDirectories(ctx context.Context, set *konfigurationv1.KonfigurationSet, gen *konfigurationv1.GitDirectoriesGenerator) ([]generatorParams, error) {
+	sourceRef := gen.SourceRef
+	if sourceRef.Namespace == "" {
+		sourceRef.Namespace = set.GetNamespace()
+	}
+	if err := r.access.checkSourceNamespace(sourceRef.Kind, sourceRef.Namespace, sourceRef.Name, set.GetNamespace()); err != nil {
+		return nil, err
+	}
+
+	source, err := konfigurationv1.GetSource(ctx, r.Client, &sourceRef)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve source '%s/%s/%s': %w", sourceRef.Kind, sourceRef.Namespace, sourceRef.Name, err)
+	}
+	artifact := source.GetArtifact()
+	if artifact == nil {
+		return nil, errors.New("source is not ready, artifact not found")
+	}
+
+	root, release, err := r.artifacts.Get(ctx, r.fetcher, artifact)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	dirs, err := matchDirectories(root, gen.Directories)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]generatorParams, 0, len(dirs))
+	for _, dir := range dirs {
+		base := path.Base(dir)
+		params = append(params, generatorParams{
+			"path":                    dir,
+			"path.basename":           base,
+			"path.basenameNormalized": normalizeName(base),
+		})
+	}
+	return params, nil
+}
+
+// matchDirectories returns the sorted list of directories under root, relative to root,
+// that match the given patterns. Patterns may not leave root, and matches resolving
+// outside of it through symlinks are skipped.
+func matchDirectories(root string, patterns []konfigurationv1.DirectoryPattern) ([]string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	included := make(map[string]struct{})
+	// Apply inclusions first so that exclusions are honored regardless of ordering
+	for _, exclude := range []bool{false, true} {
+		for _, pattern := range patterns {
+			if pattern.Exclude != exclude {
+				continue
+			}
+			for _, segment := range strings.Split(filepath.ToSlash(pattern.Path), "/") {
+				if segment == ".." {
+					return nil, fmt.Errorf("invalid directory pattern %q: must not contain '..'", pattern.Path)
+				}
+			}
+			matches, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(pattern.Path, "/"))))
+			if err != nil {
+				return nil, fmt.Errorf("invalid directory pattern %q: %w", pattern.Path, err)
+			}
+			for _, match := range matches {
+				finfo, err := os.Stat(match)
+				if err != nil || !finfo.IsDir() {
+					continue
+				}
+				if !isWithin(realRoot, match) {
+					continue
+				}
+				rel, err := filepath.Rel(root, match)
+				if err != nil {
+					return nil, err
+				}
+				rel = filepath.ToSlash(rel)
+				if exclude {
+					delete(included, rel)
+				} else {
+					included[rel] = struct{}{}
+				}
+			}
+		}
+	}
+	dirs := make([]string, 0, len(included))
+	for dir := range included {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// isWithin returns true if the given path resolves to root or a path under it once
+// its symlinks are evaluated.
+func isWithin(root, p string) bool {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// normalizeName converts the given string into something suitable for use in an
+// object name.
+func normalizeName(s string) string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+var templateParamRegex = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// renderTemplate renders the KonfigurationSet template with the given parameters and
+// returns the Konfiguration to be generated. References to unknown parameters are left
+// as-is.
+func renderTemplate(set *konfigurationv1.KonfigurationSet, params generatorParams) (*konfigurationv1.Konfiguration, error) {
+	raw, err := json.Marshal(set.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+	var tmpl interface{}
+	if err := json.Unmarshal(raw, &tmpl); err != nil {
+		return nil, err
+	}
+
+	rendered, err := json.Marshal(substituteParams(tmpl, params))
+	if err != nil {
+		return nil, err
+	}
+	var out konfigurationv1.KonfigurationTemplate
+	if err := json.Unmarshal(rendered, &out); err != nil {
+		return nil, err
+	}
+
+	if out.Metadata.Name == "" {
+		return nil, errors.New("template rendered an empty name")
+	}
+	if errs := validation.IsDNS1123Subdomain(out.Metadata.Name); len(errs) > 0 {
+		return nil, errors.New("template rendered an invalid name '" + out.Metadata.Name + "': " + errs[0])
+	}
+
+	labels := out.Metadata.Labels
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[konfigurationv1.KonfigurationSetNameLabel] = set.GetName()
+
+	konfig := &konfigurationv1.Konfiguration{}
+	konfig.SetName(out.Metadata.Name)
+	konfig.SetNamespace(set.GetNamespace())
+	konfig.SetLabels(labels)
+	konfig.SetAnnotations(out.Metadata.Annotations)
+	konfig.Spec = out.Spec
+
+	return konfig, nil
+}
+
+// substituteParams walks the given json value and replaces all parameter references in
+// strings.
+func substituteParams(val interface{}, params generatorParams) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[substituteString(k, params)] = substituteParams(item, params)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = substituteParams(item, params)
+		}
+		return out
+	case string:
+		return substituteString(v, params)
+	default:
+		return v
+	}
+}
+
+func substituteString(s string, params generatorParams) string {
+	return templateParamRegex.ReplaceAllStringFunc(s, func(match string) string {
+		key := templateParamRegex.FindStringSubmatch(match)[1]
+		if val, ok := params[key]; ok {
+			return val
+		}
+		return match
+	})
+}
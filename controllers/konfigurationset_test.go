@@ -0,0 +1,305 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+func newTestKonfigurationSet(name string) *konfigurationv1.KonfigurationSet {
+	set := &konfigurationv1.KonfigurationSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "set", Namespace: "default"},
+	}
+	set.Spec.Template.Metadata = konfigurationv1.KonfigurationTemplateMeta{
+		Name:   name,
+		Labels: map[string]string{"cluster": "{{ name }}"},
+	}
+	set.Spec.Template.Spec.Path = "clusters/{{name}}/main.jsonnet"
+	return set
+}
+
+func TestRenderTemplate(t *testing.T) {
+	konfig, err := renderTemplate(newTestKonfigurationSet("app-{{ name }}"), generatorParams{"name": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if konfig.GetName() != "app-prod" || konfig.GetNamespace() != "default" {
+		t.Errorf("unexpected name %s/%s", konfig.GetNamespace(), konfig.GetName())
+	}
+	if konfig.Spec.Path != "clusters/prod/main.jsonnet" {
+		t.Errorf("unexpected path %q", konfig.Spec.Path)
+	}
+	expected := map[string]string{"cluster": "prod", konfigurationv1.KonfigurationSetNameLabel: "set"}
+	if !reflect.DeepEqual(konfig.GetLabels(), expected) {
+		t.Errorf("expected labels %v, got %v", expected, konfig.GetLabels())
+	}
+
+	tcs := []struct {
+		name, tmpl string
+		params     generatorParams
+		errMsg     string
+	}{
+		{"empty name", "{{ name }}", generatorParams{"name": ""}, "empty name"},
+		{"invalid name", "app-{{ name }}", generatorParams{"name": "Prod_1"}, "invalid name"},
+		{"unknown parameter", "app-{{ cluster }}", generatorParams{"name": "prod"}, "invalid name"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := renderTemplate(newTestKonfigurationSet(tc.tmpl), tc.params)
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tc.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestSubstituteParams(t *testing.T) {
+	params := generatorParams{"name": "prod", "labels.region": "eu"}
+	in := map[string]interface{}{
+		"{{name}}": []interface{}{"{{ labels.region }}-{{name}}", "{{ missing }}", float64(1), true},
+		"nested":   map[string]interface{}{"value": "{{name}}{{name}}"},
+	}
+	expected := map[string]interface{}{
+		"prod":   []interface{}{"eu-prod", "{{ missing }}", float64(1), true},
+		"nested": map[string]interface{}{"value": "prodprod"},
+	}
+	if out := substituteParams(in, params); !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+}
+
+func TestMatchDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	for _, dir := range []string{"apps/a", "apps/b", "apps/skip", "infra/c", "../outside"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "apps", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Symlinks to directories within the root are followed, but not out of it
+	if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(root, "infra", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "apps", "a"), filepath.Join(root, "infra", "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name     string
+		patterns []konfigurationv1.DirectoryPattern
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "glob skips files",
+			patterns: []konfigurationv1.DirectoryPattern{{Path: "apps/*"}},
+			expected: []string{"apps/a", "apps/b", "apps/skip"},
+		},
+		{
+			name: "exclusions regardless of order",
+			patterns: []konfigurationv1.DirectoryPattern{
+				{Path: "apps/skip", Exclude: true},
+				{Path: "/apps/*"},
+				{Path: "infra/c"},
+			},
+			expected: []string{"apps/a", "apps/b", "infra/c"},
+		},
+		{
+			name:     "symlinks",
+			patterns: []konfigurationv1.DirectoryPattern{{Path: "infra/*"}},
+			expected: []string{"infra/c", "infra/linked"},
+		},
+		{
+			name:     "parent directories",
+			patterns: []konfigurationv1.DirectoryPattern{{Path: "../*"}},
+			wantErr:  true,
+		},
+		{
+			name:     "nested parent directories",
+			patterns: []konfigurationv1.DirectoryPattern{{Path: "apps/../../*"}},
+			wantErr:  true,
+		},
+		{
+			name:     "no matches",
+			patterns: []konfigurationv1.DirectoryPattern{{Path: "missing/*"}},
+			expected: []string{},
+		},
+		{
+			name:     "invalid pattern",
+			patterns: []konfigurationv1.DirectoryPattern{{Path: "apps/["}},
+			wantErr:  true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dirs, err := matchDirectories(root, tc.patterns)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dirs, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, dirs)
+			}
+		})
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tcs := map[string]string{
+		"simple":         "simple",
+		"Mixed_Case.dir": "mixed-case-dir",
+		"--edges--":      "edges",
+		"a  b__c":        "a-b-c",
+	}
+	for in, expected := range tcs {
+		if out := normalizeName(in); out != expected {
+			t.Errorf("normalizeName(%q): expected %q, got %q", in, expected, out)
+		}
+	}
+}
+
+func newGeneratedKonfiguration(name string, generation int64, ready metav1.ConditionStatus, observed int64) *konfigurationv1.Konfiguration {
+	konfig := &konfigurationv1.Konfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Generation: generation},
+	}
+	konfig.Status.ObservedGeneration = observed
+	konfig.Status.LastAppliedRevision = "rev"
+	if ready != "" {
+		konfig.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: ready, Message: "message"}}
+	}
+	return konfig
+}
+
+func TestAggregateStatus(t *testing.T) {
+	tcs := []struct {
+		name     string
+		konfigs  []*konfigurationv1.Konfiguration
+		status   metav1.ConditionStatus
+		reason   string
+		message  string
+		expected []metav1.ConditionStatus
+	}{
+		{
+			name: "all ready",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newGeneratedKonfiguration("b", 1, metav1.ConditionTrue, 1),
+				newGeneratedKonfiguration("a", 1, metav1.ConditionTrue, 1),
+			},
+			status:   metav1.ConditionTrue,
+			reason:   meta.ReconciliationSucceededReason,
+			message:  "2 of 2 Konfigurations are ready",
+			expected: []metav1.ConditionStatus{metav1.ConditionTrue, metav1.ConditionTrue},
+		},
+		{
+			name: "not ready wins over progressing",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newGeneratedKonfiguration("c", 1, metav1.ConditionFalse, 1),
+				newGeneratedKonfiguration("b", 1, "", 0),
+				newGeneratedKonfiguration("a", 1, metav1.ConditionFalse, 1),
+			},
+			status:   metav1.ConditionFalse,
+			reason:   meta.ReconciliationFailedReason,
+			message:  "2 of 3 Konfigurations are not ready: a, c",
+			expected: []metav1.ConditionStatus{metav1.ConditionFalse, metav1.ConditionUnknown, metav1.ConditionFalse},
+		},
+		{
+			name: "stale generation is progressing",
+			konfigs: []*konfigurationv1.Konfiguration{
+				newGeneratedKonfiguration("a", 2, metav1.ConditionTrue, 1),
+			},
+			status:   metav1.ConditionUnknown,
+			reason:   meta.ProgressingReason,
+			message:  "1 of 1 Konfigurations are progressing: a",
+			expected: []metav1.ConditionStatus{metav1.ConditionUnknown},
+		},
+		{
+			name:     "empty",
+			status:   metav1.ConditionTrue,
+			reason:   meta.ReconciliationSucceededReason,
+			message:  "0 of 0 Konfigurations are ready",
+			expected: []metav1.ConditionStatus{},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			status, reason, message, entries := aggregateStatus(tc.konfigs)
+			if status != tc.status || reason != tc.reason || message != tc.message {
+				t.Errorf("expected %s/%s/%q, got %s/%s/%q", tc.status, tc.reason, tc.message, status, reason, message)
+			}
+			got := make([]metav1.ConditionStatus, len(entries))
+			for i, entry := range entries {
+				got[i] = entry.Ready
+				if i > 0 && entries[i-1].Name > entry.Name {
+					t.Errorf("entries are not sorted: %v", entries)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected entries %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestGeneratedKonfigurationChangePredicate(t *testing.T) {
+	ready := newGeneratedKonfiguration("a", 1, metav1.ConditionTrue, 1)
+
+	resynced := ready.DeepCopy()
+	resynced.Status.LastAttemptedRevision = "next"
+	notReady := newGeneratedKonfiguration("a", 1, metav1.ConditionFalse, 1)
+	edited := ready.DeepCopy()
+	edited.SetGeneration(2)
+
+	p := GeneratedKonfigurationChangePredicate{}
+	if p.Create(event.CreateEvent{Object: ready}) {
+		t.Error("expected creations to be ignored")
+	}
+	for name, tc := range map[string]struct {
+		obj      *konfigurationv1.Konfiguration
+		expected bool
+	}{
+		"unrelated status change": {resynced, false},
+		"readiness change":        {notReady, true},
+		"spec change":             {edited, true},
+	} {
+		if got := p.Update(event.UpdateEvent{ObjectOld: ready, ObjectNew: tc.obj}); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", name, tc.expected, got)
+		}
+	}
+}
@@ -14,10 +14,14 @@ limitations under the License.
 package controllers
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
 )
 
 // SourceRevisionChangePredicate is a predicate that determines if the source
@@ -32,12 +36,12 @@ func (SourceRevisionChangePredicate) Update(e event.UpdateEvent) bool {
 		return false
 	}
 
-	oldSource, ok := e.ObjectOld.(sourcev1.Source)
+	oldSource, ok := sourceOf(e.ObjectOld)
 	if !ok {
 		return false
 	}
 
-	newSource, ok := e.ObjectNew.(sourcev1.Source)
+	newSource, ok := sourceOf(e.ObjectNew)
 	if !ok {
 		return false
 	}
@@ -53,3 +57,59 @@ func (SourceRevisionChangePredicate) Update(e event.UpdateEvent) bool {
 
 	return false
 }
+
+// sourceOf returns the object as a source, reading artifact sources watched as
+// unstructured objects from their status.
+func sourceOf(obj client.Object) (sourcev1.Source, bool) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return &konfigurationv1.ArtifactSource{Unstructured: u}, true
+	}
+	source, ok := obj.(sourcev1.Source)
+	return source, ok
+}
+
+// LibraryRevisionChangePredicate is a predicate that determines if the revision
+// published by a JsonnetLibrary has changed.
+type LibraryRevisionChangePredicate struct {
+	predicate.Funcs
+}
+
+// Update implements the predicate interface.
+func (LibraryRevisionChangePredicate) Update(e event.UpdateEvent) bool {
+	oldLib, ok := e.ObjectOld.(*konfigurationv1.JsonnetLibrary)
+	if !ok {
+		return false
+	}
+	newLib, ok := e.ObjectNew.(*konfigurationv1.JsonnetLibrary)
+	if !ok {
+		return false
+	}
+	return newLib.Status.Revision != "" && oldLib.Status.Revision != newLib.Status.Revision
+}
+
+// GeneratedKonfigurationChangePredicate is a predicate that determines if a
+// Konfiguration generated by a KonfigurationSet changed in a way that affects the
+// set: its spec was edited, or the entry it contributes to the set status changed.
+// Creations are ignored, as the set created the Konfiguration itself.
+type GeneratedKonfigurationChangePredicate struct {
+	predicate.Funcs
+}
+
+// Create implements the predicate interface.
+func (GeneratedKonfigurationChangePredicate) Create(e event.CreateEvent) bool { return false }
+
+// Update implements the predicate interface.
+func (GeneratedKonfigurationChangePredicate) Update(e event.UpdateEvent) bool {
+	oldKonfig, ok := e.ObjectOld.(*konfigurationv1.Konfiguration)
+	if !ok {
+		return false
+	}
+	newKonfig, ok := e.ObjectNew.(*konfigurationv1.Konfiguration)
+	if !ok {
+		return false
+	}
+	if oldKonfig.GetGeneration() != newKonfig.GetGeneration() {
+		return true
+	}
+	return konfigurationSetEntry(oldKonfig) != konfigurationSetEntry(newKonfig)
+}
@@ -17,8 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -30,16 +34,20 @@ import (
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	crtlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
 	"github.com/pelotech/jsonnet-controller/controllers"
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
 	"github.com/pelotech/jsonnet-controller/pkg/gencert"
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -68,11 +76,27 @@ func main() {
 		enableLeaderElection bool
 		probeAddr            string
 		watchAllNamespaces   bool
+		enableWebhooks       bool
+		webhookName          string
+		webhookServiceName   string
+		tlsCertSecret        string
+		lookupKeySecret      string
+		artifactCacheDir     string
+		artifactCacheSize    int64
+		artifactFetchTimeout time.Duration
+		artifactMaxSize      int64
+		artifactSourceKinds  string
+		jsonnetCacheSize     int64
 		reconcileOpts        controllers.ReconcilerOptions
 	)
 
 	flag.IntVar(&webPort, "web-bind-port", 9443, "The port to bind the web server to.")
 	flag.StringVar(&tlsCertDir, "tls-cert-dir", "", "The path to certificates and keys to use for the webserver. A self-signed certificate will be generated if not provided.")
+	flag.BoolVar(&enableWebhooks, "enable-admission-webhooks", true, "Serve the defaulting and validating admission webhooks for Konfigurations.")
+	flag.StringVar(&webhookName, "admission-webhook-name", "jsonnet-controller", "The name of the webhook configurations to inject the self-signed certificate into.")
+	flag.StringVar(&webhookServiceName, "webhook-service-name", "jsonnet-controller", "The name of the service in front of the webserver, added to the self-signed certificate.")
+	flag.StringVar(&tlsCertSecret, "tls-cert-secret", "jsonnet-controller-tls", "The name of the secret in the runtime namespace to share the self-signed certificate through between replicas, empty to generate one per replica.")
+	flag.StringVar(&lookupKeySecret, "lookup-key-secret", "jsonnet-controller-lookup-key", "The name of the secret in the runtime namespace to share the key the checksums of looked up objects are computed with between replicas and restarts, empty to generate one per process.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -86,8 +110,40 @@ func main() {
 	flag.IntVar(&reconcileOpts.HTTPRetryMax, "http-retry-max", 5, "Maximum number of times to retry fetching a source artifact")
 	flag.IntVar(&reconcileOpts.MaxConcurrentReconciles, "max-concurrent-reconciles", 3, "Number of reconcilations to allow to run at a time")
 	flag.DurationVar(&reconcileOpts.DependencyRequeueInterval, "dependency-requeue-interval", 30*time.Second, "The interval at which failing dependencies are reevaluated.")
-	flag.StringVar(&reconcileOpts.JsonnetCacheDirectory, "jsonnet-cache", "/cache", "The directory to cache jsonnet assets")
+	flag.StringVar(&reconcileOpts.RemoteCache.Dir, "jsonnet-cache", "/cache", "The directory to cache HTTP(S) paths and remote imports in, empty to only cache them in memory")
+	flag.DurationVar(&reconcileOpts.RemoteCache.TTL, "jsonnet-cache-ttl", time.Minute, "How long to use cached HTTP(S) paths and remote imports before revalidating them, 0 to revalidate them on every use")
+	flag.Func("jsonnet-cache-immutable", "A regular expression matching the URLs of HTTP(S) paths and remote imports that never change once cached, can be repeated", func(pattern string) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		reconcileOpts.RemoteCache.Immutable = append(reconcileOpts.RemoteCache.Immutable, re)
+		return nil
+	})
+	flag.Int64Var(&jsonnetCacheSize, "jsonnet-cache-size", 256, "The size in megabytes above which the least recently used HTTP(S) paths and remote imports are evicted from the cache, 0 for no limit")
+	flag.StringVar(&artifactCacheDir, "artifact-cache", "/cache/artifacts", "The directory to cache extracted source artifacts in, empty to download them on every reconcile")
+	flag.Int64Var(&artifactCacheSize, "artifact-cache-size", 1024, "The size in megabytes above which unused source artifacts are evicted from the cache, 0 for no limit")
+	flag.DurationVar(&artifactFetchTimeout, "artifact-fetch-timeout", artifacts.DefaultFetchTimeout, "The time allowed to download and extract an artifact into the cache, shared by every reconcile waiting for it")
+	flag.Int64Var(&artifactMaxSize, "artifact-max-size", 1024, "The maximum size in megabytes of a source artifact once uncompressed, 0 for no limit")
+	flag.StringVar(&artifactSourceKinds, "artifact-source-kinds", "OCIRepository.v1beta2.source.toolkit.fluxcd.io", "A comma-separated list of Kind.version.group of other sources publishing artifacts to watch for new revisions")
+	flag.StringVar(&reconcileOpts.OCILayout, "oci-layout", "", "The path to an OCI image layout searched for the artifacts of oci:// imports before their registry, for use offline")
+	flag.StringVar(&reconcileOpts.LibraryNamespace, "jsonnet-library-namespace", "", "A namespace whose JsonnetLibraries may be imported over lib:// by Konfigurations in all namespaces")
+	flag.DurationVar(&reconcileOpts.RemotePollInterval, "remote-poll-interval", time.Minute, "The interval at which HTTP(S) paths, remote imports, and objects read by lookup are polled for changes, 0 to only pick them up at each Konfiguration's interval")
 	flag.DurationVar(&reconcileOpts.DryRunRequestTimeout, "dry-run-timeout", 10*time.Second, "The timeout for dry-run requests")
+	flag.BoolVar(&reconcileOpts.BuildAuth, "build-auth", true, "Require a bearer token on dry-run requests, and check that its user may get Konfigurations and assume their identity")
+	flag.BoolVar(&reconcileOpts.SkipUnchangedImports, "skip-unchanged-imports", true, "Skip building new source revisions that did not change any file imported by the last build")
+	flag.DurationVar(&reconcileOpts.ClientCacheTTL, "impersonation-cache-ttl", 10*time.Minute, "How long to reuse clients built for impersonating service accounts and kubeconfigs, 0 to never expire")
+	flag.IntVar(&reconcileOpts.ClientCacheSize, "impersonation-cache-size", 100, "Maximum number of impersonated clients to keep, 0 for no limit")
+	flag.StringVar(&reconcileOpts.ServiceAccountMode, "service-account-mode", "token-request", "How to assume the identity of a Konfiguration's serviceAccountName, either 'token-request' or 'impersonate'")
+	flag.DurationVar(&reconcileOpts.ServiceAccountTokenTTL, "service-account-token-ttl", time.Hour, "The lifetime to request for service account tokens when using the 'token-request' mode")
+
+	// Multi-tenancy options
+	flag.BoolVar(&reconcileOpts.PreflightPermissionCheck, "preflight-permission-check", true, "Check that impersonated identities hold every permission needed to apply and prune before reconciling")
+	flag.BoolVar(&reconcileOpts.Access.NoCrossNamespaceRefs, "no-cross-namespace-refs", false, "Deny references to sources, dependencies, and imported ConfigMaps in other namespaces")
+	flag.BoolVar(&reconcileOpts.Access.NoRemoteBases, "no-remote-bases", false, "Deny jsonnetURLs, HTTP(S) paths, archives, and remote imports")
+	flag.BoolVar(&reconcileOpts.Access.RequireImportLock, "require-import-lock", false, "Refuse HTTP(S) paths and remote imports that are not pinned by an import lock")
+	flag.StringVar(&reconcileOpts.Access.DefaultServiceAccount, "default-service-account", "", "The service account to assume for Konfigurations that configure neither a serviceAccountName nor a kubeConfig")
+
 	// Zap options
 	opts := zap.Options{
 		Development: true,
@@ -98,16 +154,68 @@ func main() {
 	// Setup logging
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	if tlsCertDir == "" {
+	generatedCert := tlsCertDir == ""
+	if generatedCert {
 		var err error
 		setupLog.Info("Generating self-signed certificates for the webhook server")
-		tlsCertDir, err = gencert.GenerateCert()
+		if ns := os.Getenv("POD_NAMESPACE"); ns != "" && tlsCertSecret != "" {
+			// The manager is not created yet, use a client of its own
+			var c client.Client
+			c, err = client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+			if err == nil {
+				tlsCertDir, err = gencert.SecretCert(context.Background(), c, ns, tlsCertSecret, gencert.ServiceDNSNames(webhookServiceName, ns)...)
+			}
+		} else {
+			var dnsNames []string
+			if ns != "" {
+				dnsNames = gencert.ServiceDNSNames(webhookServiceName, ns)
+			}
+			tlsCertDir, err = gencert.GenerateCert(dnsNames...)
+		}
 		if err != nil {
 			setupLog.Error(err, "unable to generate a self-signed certificate")
 			os.Exit(1)
 		}
 	}
 
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" && lookupKeySecret != "" {
+		// The manager is not created yet, use a client of its own
+		c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err == nil {
+			var key []byte
+			if key, err = jsonnet.SecretLookupKey(context.Background(), c, ns, lookupKeySecret); err == nil {
+				jsonnet.SetLookupKey(key)
+			}
+		}
+		if err != nil {
+			setupLog.Error(err, "unable to retrieve the lookup key")
+			os.Exit(1)
+		}
+	}
+
+	reconcileOpts.ArtifactMaxSize = artifactMaxSize * 1024 * 1024
+	reconcileOpts.RemoteCache.MaxSize = jsonnetCacheSize * 1024 * 1024
+	for _, kind := range strings.Split(artifactSourceKinds, ",") {
+		if kind = strings.TrimSpace(kind); kind == "" {
+			continue
+		}
+		gvk, _ := schema.ParseKindArg(kind)
+		if gvk == nil {
+			setupLog.Error(fmt.Errorf("'%s' is not of the form Kind.version.group", kind), "invalid artifact source kind")
+			os.Exit(1)
+		}
+		reconcileOpts.ArtifactSourceKinds = append(reconcileOpts.ArtifactSourceKinds, *gvk)
+	}
+	if artifactCacheDir != "" {
+		cache, err := artifacts.NewCache(artifactCacheDir, artifactCacheSize*1024*1024)
+		if err != nil {
+			setupLog.Error(err, "unable to create the artifact cache")
+			os.Exit(1)
+		}
+		cache.FetchTimeout = artifactFetchTimeout
+		reconcileOpts.ArtifactCache = cache
+	}
+
 	var eventRecorder *events.Recorder
 	if eventsAddr != "" {
 		if er, err := events.NewRecorder(eventsAddr, controllerName); err != nil {
@@ -120,6 +228,7 @@ func main() {
 
 	metricsRecorder := metrics.NewRecorder()
 	crtlmetrics.Registry.MustRegister(metricsRecorder.Collectors()...)
+	crtlmetrics.Registry.MustRegister(jsonnet.RemoteFilesCollectors()...)
 
 	watchNamespace := ""
 	if !watchAllNamespaces {
@@ -151,11 +260,48 @@ func main() {
 	}
 
 	mgr.GetWebhookServer().Register("/build", konfigurationController.DryRunFunc())
+	mgr.GetWebhookServer().Register("/cache", konfigurationController.CacheFunc())
 
 	if err = konfigurationController.SetupWithManager(setupLog, mgr, &reconcileOpts); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Konfiguration")
 		os.Exit(1)
 	}
+
+	if err = (&controllers.KonfigurationSetReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		EventRecorder: mgr.GetEventRecorderFor(controllerName),
+	}).SetupWithManager(setupLog, mgr, &reconcileOpts); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KonfigurationSet")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.JsonnetLibraryReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		EventRecorder: mgr.GetEventRecorderFor(controllerName),
+	}).SetupWithManager(setupLog, mgr, &reconcileOpts); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "JsonnetLibrary")
+		os.Exit(1)
+	}
+
+	if enableWebhooks {
+		if err = (&konfigurationv1.Konfiguration{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Konfiguration")
+			os.Exit(1)
+		}
+		if generatedCert {
+			// The manager's client reads from a cache that is not started yet
+			c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+			if err == nil {
+				err = gencert.InjectCABundle(context.Background(), c, tlsCertDir, webhookName)
+			}
+			if err != nil {
+				setupLog.Error(err, "unable to inject the self-signed certificate into the webhook configurations")
+				os.Exit(1)
+			}
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
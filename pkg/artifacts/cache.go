@@ -0,0 +1,287 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+// Cache holds source artifacts extracted to disk, shared across reconciles. Entries
+// are keyed by the artifact's URL and checksum, so a new revision results in a new
+// entry. Other contents, such as git checkouts, may be cached under an id of their
+// own. Entries are reference counted while in use, and those not in use are evicted
+// in least-recently-used order once the extracted size of the cache exceeds its limit.
+//
+// A nil Cache is valid and extracts every artifact to a new temporary directory.
+type Cache struct {
+	// FetchTimeout bounds how long fetching contents into the cache may take. Fetches
+	// are shared by every caller waiting for the same contents, so they do not stop
+	// when the caller that started them does.
+	FetchTimeout time.Duration
+
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	dir  string
+	size int64
+	refs int
+	// ready is closed once the artifact is extracted, or failed to be
+	ready chan struct{}
+	err   error
+	// evicted is set once the entry is removed from the cache, its directory is
+	// deleted when the last reference is released
+	evicted bool
+}
+
+// DefaultFetchTimeout is the FetchTimeout of caches returned by NewCache.
+const DefaultFetchTimeout = 5 * time.Minute
+
+// entryDirRegex matches the directories created for cache entries.
+var entryDirRegex = regexp.MustCompile("^[0-9a-f]{16}-[0-9]+$")
+
+// NewCache returns a new Cache storing artifacts in dir. Entries left in dir by a
+// previous process are removed. A maxSize of zero means the size of the cache is not
+// bounded.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	stale, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, finfo := range stale {
+		if finfo.IsDir() && entryDirRegex.MatchString(finfo.Name()) {
+			if err := os.RemoveAll(filepath.Join(dir, finfo.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Cache{
+		FetchTimeout: DefaultFetchTimeout,
+		dir:          dir,
+		maxSize:      maxSize,
+		lru:          list.New(),
+		entries:      make(map[string]*list.Element),
+	}, nil
+}
+
+// Size returns the extracted size in bytes of the artifacts currently in the cache.
+func (c *Cache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Get returns the directory the artifact is extracted to, fetching it with the given
+// fetcher if it is not cached yet. Concurrent calls for the same artifact share a single
+// download, which is not cancelled by ctx, each call only stops waiting for it when its
+// own ctx is done. The directory must not be modified, and release must be called once
+// it is no longer in use.
+func (c *Cache) Get(ctx context.Context, fetcher *Fetcher, artifact *sourcev1.Artifact) (dir string, release func(), err error) {
+	return c.GetFunc(ctx, artifact.URL+"\x00"+artifact.Checksum, func(ctx context.Context, dir string) error {
+		return fetcher.Fetch(ctx, artifact, dir)
+	})
+}
+
+// GetFunc is like Get for contents identified by id, rather than an artifact, that are
+// written to an empty directory by fetch. The id must change whenever the contents do.
+func (c *Cache) GetFunc(ctx context.Context, id string, fetch func(ctx context.Context, dir string) error) (dir string, release func(), err error) {
+	if c == nil {
+		dir, err := ioutil.TempDir("", "artifact")
+		if err != nil {
+			return "", nil, err
+		}
+		if err := fetch(ctx, dir); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		return dir, func() { os.RemoveAll(dir) }, nil
+	}
+
+	key := cacheKey(id)
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	} else {
+		// Entries evicted while in use keep their directory until released, so every
+		// entry gets a directory of its own.
+		entryDir, err := ioutil.TempDir(c.dir, key[:16]+"-")
+		if err != nil {
+			c.mu.Unlock()
+			return "", nil, err
+		}
+		// The fetch holds a reference of its own, so the directory is not removed
+		// while it is written if every caller stops waiting.
+		elem = c.lru.PushFront(&cacheEntry{
+			key:   key,
+			dir:   entryDir,
+			refs:  1,
+			ready: make(chan struct{}),
+		})
+		c.entries[key] = elem
+	}
+	entry := elem.Value.(*cacheEntry)
+	entry.refs++
+	c.mu.Unlock()
+
+	release = func() { c.release(entry) }
+
+	if !ok {
+		go c.fetch(detach(ctx), fetch, entry)
+	}
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		release()
+		return "", nil, ctx.Err()
+	}
+	if entry.err != nil {
+		release()
+		return "", nil, entry.err
+	}
+	return entry.dir, release, nil
+}
+
+// fetch writes the contents into the entry's directory within the FetchTimeout, drops
+// the reference held by the fetch, and marks it ready. Failed entries are removed from
+// the cache so the next call retries.
+func (c *Cache) fetch(ctx context.Context, fetch func(ctx context.Context, dir string) error, entry *cacheEntry) {
+	if c.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.FetchTimeout)
+		defer cancel()
+	}
+	err := fetch(ctx, entry.dir)
+	var size int64
+	if err == nil {
+		size, err = dirSize(entry.dir)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(entry.ready)
+	entry.refs--
+	if err != nil {
+		entry.err = err
+		c.remove(entry)
+		return
+	}
+	entry.size = size
+	c.size += size
+	c.evict()
+}
+
+// release drops a reference to the entry, deleting it if it was evicted while in use,
+// and evicts entries if the cache is over its limit.
+func (c *Cache) release(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.refs == 0 && entry.evicted {
+		os.RemoveAll(entry.dir)
+		return
+	}
+	c.evict()
+}
+
+// evict removes the least recently used entries that are not in use until the cache
+// is within its limit. It must be called with the lock held.
+func (c *Cache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+	for elem := c.lru.Back(); elem != nil && c.size > c.maxSize; {
+		entry := elem.Value.(*cacheEntry)
+		elem = elem.Prev()
+		if entry.refs > 0 {
+			continue
+		}
+		c.remove(entry)
+	}
+}
+
+// remove drops the entry from the cache, deleting its directory if it is not in use.
+// It must be called with the lock held.
+func (c *Cache) remove(entry *cacheEntry) {
+	if elem, ok := c.entries[entry.key]; ok && elem.Value == entry {
+		c.lru.Remove(elem)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+	entry.evicted = true
+	if entry.refs == 0 {
+		os.RemoveAll(entry.dir)
+	}
+}
+
+// detachedContext carries the values of a context, such as its logger, without its
+// deadline or cancellation.
+type detachedContext struct{ context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// detach returns a context with the values of ctx that is never cancelled.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func cacheKey(id string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(id)))
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// testArtifact returns a gzipped tarball containing a single file with the given contents.
+func testArtifact(t *testing.T, contents string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "main.jsonnet", Mode: 0644, Size: int64(len(contents))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCache(t *testing.T) {
+	artifacts := map[string][]byte{
+		"/a.tar.gz": testArtifact(t, "{}"),
+		"/b.tar.gz": testArtifact(t, "[]"),
+	}
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		data, ok := artifacts[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	artifact := func(path string) *sourcev1.Artifact {
+		return &sourcev1.Artifact{
+			URL:      srv.URL + path,
+			Checksum: fmt.Sprintf("%x", sha1.Sum(artifacts[path])),
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Entries from a previous process are removed, anything else is left alone
+	stale := filepath.Join(dir, "0123456789abcdef-1234")
+	other := filepath.Join(dir, "other")
+	for _, d := range []string{stale, other} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only room for one of the artifacts
+	cache, err := NewCache(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected stale entries to be removed, got %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("expected other directories to be kept, got %v", err)
+	}
+	ctx := context.Background()
+	fetcher := &Fetcher{HTTPClient: testHTTPClient()}
+
+	aDir, releaseA, err := cache.Get(ctx, fetcher, artifact("/a.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(aDir, "main.jsonnet")); err != nil || string(data) != "{}" {
+		t.Fatalf("expected the artifact to be extracted, got %q, %v", data, err)
+	}
+
+	// A second reference shares the extracted tree
+	aDir2, releaseA2, err := cache.Get(ctx, fetcher, artifact("/a.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aDir2 != aDir || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected the cached artifact to be reused, got %s after %d requests", aDir2, requests)
+	}
+
+	// Over the limit, but a is in use so it is kept
+	bDir, releaseB, err := cache.Get(ctx, fetcher, artifact("/b.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(aDir); err != nil {
+		t.Fatalf("expected an artifact in use to be kept, got %v", err)
+	}
+
+	// Once released, a is the least recently used and is evicted
+	releaseA()
+	releaseA2()
+	if _, err := os.Stat(aDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the least recently used artifact to be evicted, got %v", err)
+	}
+	if _, err := os.Stat(bDir); err != nil {
+		t.Fatalf("expected the artifact in use to be kept, got %v", err)
+	}
+	releaseB()
+	if size := cache.Size(); size != 2 {
+		t.Errorf("expected a cache size of 2, got %d", size)
+	}
+
+	// Checksum mismatches are rejected and not cached
+	bad := artifact("/a.tar.gz")
+	bad.Checksum = "invalid"
+	for i := 0; i < 2; i++ {
+		var checksumErr *ChecksumError
+		if _, _, err := cache.Get(ctx, fetcher, bad); !errors.As(err, &checksumErr) {
+			t.Fatalf("expected a checksum mismatch to be rejected, got %v", err)
+		}
+	}
+}
+
+func TestCacheSharedFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	fetch := func(ctx context.Context, dir string) error {
+		close(started)
+		select {
+		case <-unblock:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return ioutil.WriteFile(filepath.Join(dir, "main.jsonnet"), []byte("{}"), 0644)
+	}
+
+	// The caller that started the fetch gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, _, err := cache.GetFunc(ctx, "id", fetch)
+		errs <- err
+	}()
+	<-started
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled caller to stop waiting, got %v", err)
+	}
+
+	// Another caller still receives the contents
+	close(unblock)
+	entryDir, release, err := cache.GetFunc(context.Background(), "id", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if data, err := ioutil.ReadFile(filepath.Join(entryDir, "main.jsonnet")); err != nil || string(data) != "{}" {
+		t.Fatalf("expected the fetch to complete, got %q, %v", data, err)
+	}
+
+	// Fetches that do not complete within the timeout fail
+	cache.FetchTimeout = 10 * time.Millisecond
+	_, _, err = cache.GetFunc(context.Background(), "slow", func(ctx context.Context, dir string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the fetch to time out, got %v", err)
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	data := testArtifact(t, strings.Repeat("0", 4096))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+	artifact := &sourcev1.Artifact{URL: srv.URL, Checksum: fmt.Sprintf("%x", sha1.Sum(data))}
+
+	for _, tc := range []struct {
+		maxSize int64
+		err     error
+	}{
+		{maxSize: 0},
+		{maxSize: 1 << 20},
+		{maxSize: 1024, err: ErrSizeLimit},
+	} {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		fetcher := &Fetcher{HTTPClient: testHTTPClient(), MaxSize: tc.maxSize}
+		err = fetcher.Fetch(context.Background(), artifact, dir)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("expected %v with a limit of %d, got %v", tc.err, tc.maxSize, err)
+		}
+		if tc.err != nil {
+			if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("expected a rejected artifact to be removed, found %d entries", len(entries))
+			}
+		}
+	}
+}
+
+func TestFetchChecksums(t *testing.T) {
+	data := testArtifact(t, "{}")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	for _, checksum := range []string{
+		fmt.Sprintf("%x", sha1.Sum(data)),
+		fmt.Sprintf("%x", sha256.Sum256(data)),
+		fmt.Sprintf("sha256:%x", sha256.Sum256(data)),
+	} {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		fetcher := &Fetcher{HTTPClient: testHTTPClient()}
+		if err := fetcher.Fetch(context.Background(), &sourcev1.Artifact{URL: srv.URL, Checksum: checksum}, dir); err != nil {
+			t.Errorf("expected checksum %s to be verified, got %v", checksum, err)
+		}
+	}
+}
+
+func testHTTPClient() *retryablehttp.Client {
+	httpClient := retryablehttp.NewClient()
+	httpClient.RetryMax = 0
+	httpClient.Logger = nil
+	return httpClient
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package artifacts fetches and caches the artifacts published by Flux sources.
+package artifacts
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// ChecksumError is returned when a downloaded artifact does not match the checksum
+// advertised by its source.
+type ChecksumError struct {
+	URL, Expected, Actual string
+}
+
+// Error implements the error interface.
+func (c *ChecksumError) Error() string {
+	return fmt.Sprintf("artifact %s was rejected, its checksum '%s' does not match the advertised checksum '%s'",
+		c.URL, c.Actual, c.Expected)
+}
+
+// Fetcher downloads and extracts artifacts.
+type Fetcher struct {
+	// HTTPClient is the client used to download artifacts.
+	HTTPClient *retryablehttp.Client
+	// MaxSize is the maximum uncompressed size in bytes of an artifact, zero for no
+	// limit.
+	MaxSize int64
+}
+
+// Fetch downloads the artifact and untars it into dir. The artifact is hashed while
+// it is extracted, and when it does not match the checksum advertised by the source
+// the extracted files are removed and a *ChecksumError is returned. Checksums are
+// SHA1 as published by the v1beta1 sources, or SHA256 as published by later ones,
+// optionally in their "sha256:<hex>" digest form.
+//
+// The SOURCE_CONTROLLER_LOCALHOST environment variable replaces the host of the
+// artifact URL, for running outside of the cluster. Artifacts are verified all the same.
+func (f *Fetcher) Fetch(ctx context.Context, artifact *sourcev1.Artifact, dir string) error {
+	artifactURL := artifact.URL
+	if hostname := os.Getenv("SOURCE_CONTROLLER_LOCALHOST"); hostname != "" {
+		u, err := url.Parse(artifactURL)
+		if err != nil {
+			return err
+		}
+		u.Host = hostname
+		artifactURL = u.String()
+	}
+
+	req, err := retryablehttp.NewRequest(http.MethodGet, artifactURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create a new request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := f.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download artifact, error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download artifact from %s, status: %s", artifactURL, resp.Status)
+	}
+
+	expected, hasher := checksumHash(artifact.Checksum)
+	body := io.TeeReader(resp.Body, hasher)
+	if err := Untar(body, dir, f.MaxSize); err != nil {
+		removeContents(dir)
+		return fmt.Errorf("failed to untar artifact, error: %w", err)
+	}
+	// Hash anything after the end of the archive
+	var trailing io.Reader = body
+	if f.MaxSize > 0 {
+		trailing = &limitedReader{r: body, remaining: f.MaxSize}
+	}
+	if _, err := io.Copy(ioutil.Discard, trailing); err != nil {
+		removeContents(dir)
+		return fmt.Errorf("failed to download artifact from %s, error: %w", artifactURL, err)
+	}
+
+	if sum := fmt.Sprintf("%x", hasher.Sum(nil)); expected != "" && sum != expected {
+		removeContents(dir)
+		return &ChecksumError{URL: artifact.URL, Expected: artifact.Checksum, Actual: sum}
+	}
+
+	return nil
+}
+
+// checksumHash returns the hex digest of the given checksum and the hash it was
+// computed with.
+func checksumHash(checksum string) (string, hash.Hash) {
+	if strings.HasPrefix(checksum, "sha256:") {
+		return strings.TrimPrefix(checksum, "sha256:"), sha256.New()
+	}
+	if len(checksum) == sha256.Size*2 {
+		return checksum, sha256.New()
+	}
+	return checksum, sha1.New()
+}
+
+// removeContents removes everything in dir, but not dir itself.
+func removeContents(dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		os.RemoveAll(filepath.Join(dir, entry.Name()))
+	}
+}
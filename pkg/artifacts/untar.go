@@ -0,0 +1,132 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSizeLimit is returned when an artifact is larger than the maximum size once
+// uncompressed.
+var ErrSizeLimit = errors.New("artifact exceeds the maximum uncompressed size")
+
+// Untar reads the gzip-compressed tarball from r and writes it into dir. Only regular
+// files and directories are extracted. When maxSize is positive, extraction stops with
+// ErrSizeLimit once more than maxSize bytes were decompressed.
+func Untar(r io.Reader, dir string, maxSize int64) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("requires gzip-compressed body: %w", err)
+	}
+	var decompressed io.Reader = zr
+	if maxSize > 0 {
+		decompressed = &limitedReader{r: zr, remaining: maxSize}
+	}
+
+	tr := tar.NewReader(decompressed)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// A tarball ends with padding, read it so the whole stream is hashed
+			_, err := io.Copy(ioutil.Discard, decompressed)
+			return unwrapLimit(err, "gzip error")
+		}
+		if err != nil {
+			return unwrapLimit(err, "tar error")
+		}
+		if !validRelPath(hdr.Name) {
+			return fmt.Errorf("tar contained invalid name %q", hdr.Name)
+		}
+		abs := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+
+		mode := hdr.FileInfo().Mode()
+		switch {
+		case mode.IsRegular():
+			if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+				return err
+			}
+			wf, err := os.OpenFile(abs, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(wf, tr)
+			if closeErr := wf.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return unwrapLimit(err, fmt.Sprintf("error writing to %s", abs))
+			}
+			if n != hdr.Size {
+				return fmt.Errorf("only wrote %d bytes to %s; expected %d", n, abs, hdr.Size)
+			}
+		case mode.IsDir():
+			if err := os.MkdirAll(abs, 0755); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("tar file entry %s contained unsupported file type %v", hdr.Name, mode)
+		}
+	}
+}
+
+// limitedReader returns ErrSizeLimit once more than the remaining bytes are read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// Read implements io.Reader.
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrSizeLimit
+	}
+	// Read one byte past the limit to tell an exact fit from an overflow
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrSizeLimit
+	}
+	return n, err
+}
+
+func unwrapLimit(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrSizeLimit) {
+		return ErrSizeLimit
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+func validRelPath(p string) bool {
+	if p == "" || strings.Contains(p, `\`) || strings.HasPrefix(p, "/") || strings.Contains(p, "../") || p == ".." {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,450 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bundler installs the dependencies locked by jsonnet-bundler, so that
+// sources do not need to commit their vendor directory.
+package bundler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
+	"github.com/pelotech/jsonnet-controller/pkg/git"
+)
+
+const (
+	// FileName is the name of the file declaring the dependencies of a jsonnet
+	// project.
+	FileName = "jsonnetfile.json"
+	// LockFileName is the name of the file pinning the dependencies to exact
+	// versions.
+	LockFileName = "jsonnetfile.lock.json"
+	// VendorDir is the name of the directory dependencies are installed to.
+	VendorDir = "vendor"
+)
+
+// ErrNotLocked is returned when a project declares dependencies without a lockfile.
+var ErrNotLocked = fmt.Errorf("%s is required to install the dependencies in %s, run 'jb install' to create it", LockFileName, FileName)
+
+// Lock holds the dependencies of a jsonnet project as pinned by its lockfile.
+type Lock struct {
+	Dependencies []Dependency `json:"dependencies"`
+	// LegacyImports links every dependency under its short name as well, so that it
+	// can be imported without its host and path, as declared in the jsonnetfile.
+	LegacyImports bool `json:"-"`
+}
+
+// Dependency is a locked dependency.
+type Dependency struct {
+	Source Source `json:"source"`
+	// Version is the commit a git dependency is locked to.
+	Version string `json:"version"`
+	// Sum is the base64 encoded sha256 checksum of the installed files, if known.
+	Sum string `json:"sum,omitempty"`
+	// LegacyNameCompat overrides the short name of the dependency.
+	LegacyNameCompat string `json:"name,omitempty"`
+}
+
+// Source is where a dependency is installed from, exactly one of its fields is set.
+type Source struct {
+	Git   *GitSource   `json:"git,omitempty"`
+	Local *LocalSource `json:"local,omitempty"`
+}
+
+// GitSource is a dependency on a path in a git repository.
+type GitSource struct {
+	Remote string `json:"remote"`
+	Subdir string `json:"subdir"`
+}
+
+// LocalSource is a dependency on a directory relative to the project.
+type LocalSource struct {
+	Directory string `json:"directory"`
+}
+
+// Name returns the path the dependency is installed to in the vendor directory.
+func (d *Dependency) Name() string {
+	switch {
+	case d.Source.Git != nil:
+		return path.Join(gitRemotePath(d.Source.Git.Remote), d.Source.Git.Subdir)
+	case d.Source.Local != nil:
+		return filepath.Base(d.Source.Local.Directory)
+	}
+	return ""
+}
+
+// LegacyName returns the short name the dependency is also linked under with legacy
+// imports.
+func (d *Dependency) LegacyName() string {
+	if d.LegacyNameCompat != "" {
+		return d.LegacyNameCompat
+	}
+	return path.Base(d.Name())
+}
+
+// scpLikeRegex matches the user and host of scp-like git remotes.
+var scpLikeRegex = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):`)
+
+// gitRemotePath returns the host and path of a git remote, without scheme, user,
+// port, or .git suffix, e.g. github.com/grafana/jsonnet-libs.
+func gitRemotePath(remote string) string {
+	if i := strings.Index(remote, "://"); i >= 0 {
+		remote = remote[i+3:]
+		if j := strings.Index(remote, "@"); j >= 0 && j < strings.Index(remote+"/", "/") {
+			remote = remote[j+1:]
+		}
+		host := strings.SplitN(remote, "/", 2)
+		if h := strings.Index(host[0], ":"); h >= 0 {
+			host[0] = host[0][:h]
+		}
+		remote = strings.Join(host, "/")
+	} else {
+		remote = scpLikeRegex.ReplaceAllString(remote, "$1/")
+	}
+	return strings.TrimSuffix(strings.Trim(remote, "/"), ".git")
+}
+
+// Load reads the jsonnetfile and lockfile in dir. It returns nil if dir does not
+// declare any dependencies, and ErrNotLocked if they are not locked.
+func Load(dir string) (*Lock, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	file := struct {
+		Dependencies  []json.RawMessage `json:"dependencies"`
+		LegacyImports *bool             `json:"legacyImports"`
+	}{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", FileName, err)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, LockFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if len(file.Dependencies) == 0 {
+				return nil, nil
+			}
+			return nil, ErrNotLocked
+		}
+		return nil, err
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", LockFileName, err)
+	}
+	lock.LegacyImports = file.LegacyImports == nil || *file.LegacyImports
+	for i := range lock.Dependencies {
+		dep := &lock.Dependencies[i]
+		switch {
+		case dep.Source.Git != nil:
+			if dep.Version == "" {
+				return nil, fmt.Errorf("dependency %s is not locked to a version in %s", dep.Name(), LockFileName)
+			}
+		case dep.Source.Local != nil:
+		default:
+			return nil, fmt.Errorf("dependency %d in %s has no supported source, only git and local sources are", i, LockFileName)
+		}
+		if name := dep.Name(); !isLocal(name) {
+			return nil, fmt.Errorf("invalid dependency name '%s' in %s", name, LockFileName)
+		}
+	}
+	return &lock, nil
+}
+
+// Install installs the dependencies of the project in dir to its vendor directory,
+// like 'jb install' does from an existing lockfile. Git repositories are restricted to
+// the given protocols, if any.
+func (l *Lock) Install(ctx context.Context, dir string, protocols []string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	vendorDir := filepath.Join(dir, VendorDir)
+	if err := os.MkdirAll(vendorDir, 0755); err != nil {
+		return err
+	}
+	if err := l.installGit(ctx, vendorDir, protocols); err != nil {
+		return err
+	}
+	// Local dependencies may be anywhere on the filesystem
+	return l.linkLocal(string(filepath.Separator), dir, vendorDir)
+}
+
+// installGit checks out the git dependencies into vendorDir and verifies their
+// checksums.
+func (l *Lock) installGit(ctx context.Context, vendorDir string, protocols []string) error {
+	for i := range l.Dependencies {
+		dep := &l.Dependencies[i]
+		if dep.Source.Git == nil {
+			continue
+		}
+		dest, err := securejoin.SecureJoin(vendorDir, dep.Name())
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+
+		tmp, err := ioutil.TempDir(vendorDir, ".checkout-")
+		if err != nil {
+			return err
+		}
+		repo := &git.Repository{URL: dep.Source.Git.Remote, AllowedProtocols: protocols}
+		ref := gitReference(dep.Version)
+		commit, _, err := repo.Resolve(ctx, ref)
+		if err == nil {
+			err = repo.Checkout(ctx, ref, commit, dep.Source.Git.Subdir, tmp)
+		}
+		if err == nil {
+			var src string
+			if src, err = securejoin.SecureJoin(tmp, dep.Source.Git.Subdir); err == nil {
+				err = os.Rename(src, dest)
+			}
+		}
+		os.RemoveAll(tmp)
+		if err != nil {
+			return fmt.Errorf("failed to install %s: %w", dep.Name(), err)
+		}
+
+		if dep.Sum != "" {
+			sum, err := hashDir(dest)
+			if err != nil {
+				return err
+			}
+			if sum != dep.Sum {
+				return fmt.Errorf("checksum of %s at %s does not match %s, got %s", dep.Name(), dep.Version, dep.Sum, sum)
+			}
+		}
+	}
+	return l.linkLegacy(vendorDir, func(dep *Dependency) bool { return dep.Source.Git != nil })
+}
+
+// linkLocal links the local dependencies of the project in dir into vendorDir. Their
+// directories must be within root.
+func (l *Lock) linkLocal(root, dir, vendorDir string) error {
+	for i := range l.Dependencies {
+		dep := &l.Dependencies[i]
+		if dep.Source.Local == nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, filepath.Join(dir, dep.Source.Local.Directory))
+		if err != nil {
+			return err
+		}
+		target, err := securejoin.SecureJoin(root, rel)
+		if err != nil {
+			return err
+		}
+		link, err := securejoin.SecureJoin(vendorDir, dep.Name())
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+		if target, err = filepath.Rel(vendorDir, target); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+	}
+	return l.linkLegacy(vendorDir, func(dep *Dependency) bool { return dep.Source.Local != nil })
+}
+
+// linkLegacy links the matching dependencies under their short name when legacy
+// imports are enabled, unless the name is taken.
+func (l *Lock) linkLegacy(vendorDir string, match func(dep *Dependency) bool) error {
+	if !l.LegacyImports {
+		return nil
+	}
+	for i := range l.Dependencies {
+		dep := &l.Dependencies[i]
+		name, legacy := dep.Name(), dep.LegacyName()
+		if !match(dep) || legacy == name || strings.ContainsAny(legacy, `/\`) || legacy == ".." {
+			continue
+		}
+		link := filepath.Join(vendorDir, legacy)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.FromSlash(name), link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isLocal returns whether the slash-separated name is a relative path that stays
+// within the directory it is joined to.
+func isLocal(name string) bool {
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(filepath.FromSlash(name)) || strings.Contains(name, `\`) {
+		return false
+	}
+	cleaned := path.Clean(name)
+	return cleaned != "." && cleaned != ".." && !strings.HasPrefix(cleaned, "../")
+}
+
+// commitRegex matches full commit SHAs.
+var commitRegex = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// gitReference returns the reference to check out for a locked version, which is
+// normally a commit, and otherwise taken to be a tag.
+func gitReference(version string) git.Reference {
+	if commitRegex.MatchString(version) {
+		return git.Reference{Commit: version}
+	}
+	return git.Reference{Tag: version}
+}
+
+// hashDir returns the checksum of the files in dir the way jsonnet-bundler computes
+// it, the base64 encoded sha256 of their contents in lexical order.
+func hashDir(dir string) (string, error) {
+	hasher := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(hasher, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Vendorer installs the dependencies of projects for builds. Git dependencies are
+// shared through the artifact cache, keyed by their locked versions.
+type Vendorer struct {
+	// Cache is the cache git dependencies are installed to. A nil Cache installs
+	// them to a new temporary directory for every build.
+	Cache *artifacts.Cache
+	// AllowedProtocols restricts the transports used to fetch git dependencies.
+	AllowedProtocols []string
+	// NoGit refuses to install git dependencies, with ErrGitDependenciesDisabled.
+	// Projects with a vendor directory are still used as is.
+	NoGit bool
+}
+
+// ErrGitDependenciesDisabled is returned when installing git dependencies with a
+// Vendorer that refuses them.
+var ErrGitDependenciesDisabled = errors.New("git dependencies are disabled")
+
+// Vendor returns the vendor directories to search for imports of the project in dir,
+// within the source at root, if it declares dependencies. A vendor directory in the
+// project is used as is. Release must be called once the directories are no longer
+// in use.
+func (v *Vendorer) Vendor(ctx context.Context, root, dir string) (paths []string, release func(), err error) {
+	release = func() {}
+	lock, err := Load(dir)
+	if err != nil || lock == nil {
+		return nil, release, err
+	}
+	if finfo, err := os.Stat(filepath.Join(dir, VendorDir)); err == nil && finfo.IsDir() {
+		return []string{filepath.Join(dir, VendorDir)}, release, nil
+	}
+
+	var releases []func()
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+
+	if id := lock.gitCacheID(); id != "" {
+		if v.NoGit {
+			return nil, release, fmt.Errorf("cannot install the git dependencies in %s: %w", LockFileName, ErrGitDependenciesDisabled)
+		}
+		gitDir, gitRelease, err := v.Cache.GetFunc(ctx, id, func(ctx context.Context, dir string) error {
+			return lock.installGit(ctx, dir, v.AllowedProtocols)
+		})
+		if err != nil {
+			return nil, release, err
+		}
+		releases = append(releases, gitRelease)
+		paths = append(paths, gitDir)
+	}
+
+	if lock.hasLocal() {
+		localDir, err := ioutil.TempDir("", "vendor")
+		if err != nil {
+			release()
+			return nil, func() {}, err
+		}
+		releases = append(releases, func() { os.RemoveAll(localDir) })
+		if err := lock.linkLocal(root, dir, localDir); err != nil {
+			release()
+			return nil, func() {}, err
+		}
+		paths = append(paths, localDir)
+	}
+	return paths, release, nil
+}
+
+// hasLocal returns whether the lock has local dependencies.
+func (l *Lock) hasLocal() bool {
+	for _, dep := range l.Dependencies {
+		if dep.Source.Local != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// gitCacheID returns the id of the git dependencies of the lock in the artifact
+// cache, or an empty string if there are none.
+func (l *Lock) gitCacheID() string {
+	var deps []string
+	for _, dep := range l.Dependencies {
+		if dep.Source.Git != nil {
+			deps = append(deps, strings.Join([]string{dep.Source.Git.Remote, dep.Source.Git.Subdir, dep.Version, dep.Sum, dep.LegacyName()}, "\x00"))
+		}
+	}
+	if len(deps) == 0 {
+		return ""
+	}
+	sort.Strings(deps)
+	return fmt.Sprintf("jsonnet-bundler\x00%t\x00%s", l.LegacyImports, strings.Join(deps, "\x01"))
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitRemotePath(t *testing.T) {
+	tcs := map[string]string{
+		"https://github.com/grafana/jsonnet-libs.git":      "github.com/grafana/jsonnet-libs",
+		"https://github.com/grafana/jsonnet-libs":          "github.com/grafana/jsonnet-libs",
+		"git@github.com:grafana/jsonnet-libs.git":          "github.com/grafana/jsonnet-libs",
+		"ssh://git@gitlab.example.com:2222/org/group/repo": "gitlab.example.com/org/group/repo",
+	}
+	for remote, want := range tcs {
+		if got := gitRemotePath(remote); got != want {
+			t.Errorf("expected %s for %s, got %s", want, remote, got)
+		}
+	}
+}
+
+func TestVendor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A repository with a library in a subdirectory
+	work, bare := filepath.Join(dir, "work"), filepath.Join(dir, "libs.git")
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = work
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(path, contents string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(work, "lib", "lib.libsonnet"), "{ lib: true }")
+	git("init", "-q", "-b", "main")
+	git("add", ".")
+	git("commit", "-q", "-m", "lib")
+	commit := git("rev-parse", "HEAD")
+	git("clone", "-q", "--bare", work, bare)
+	sum, err := hashDir(filepath.Join(work, "lib"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A project depending on it and on a local library
+	root := filepath.Join(dir, "source")
+	project := filepath.Join(root, "app")
+	write(filepath.Join(root, "local", "local.libsonnet"), "{ local: true }")
+	write(filepath.Join(project, FileName), "{}")
+	lockfile := fmt.Sprintf(`{"version": 1, "dependencies": [
+		{"source": {"git": {"remote": "file://%s", "subdir": "lib"}}, "version": "%s", "sum": "%%s"},
+		{"source": {"local": {"directory": "../local"}}, "version": ""}
+	]}`, bare, commit)
+	write(filepath.Join(project, LockFileName), fmt.Sprintf(lockfile, sum))
+
+	vendorer := &Vendorer{}
+	paths, release, err := vendorer.Vendor(context.Background(), root, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if len(paths) != 2 {
+		t.Fatalf("expected git and local vendor directories, got %v", paths)
+	}
+	for _, path := range []string{
+		filepath.Join(paths[0], filepath.FromSlash(strings.TrimSuffix(strings.TrimPrefix(bare, "/"), ".git")), "lib", "lib.libsonnet"),
+		filepath.Join(paths[0], "lib", "lib.libsonnet"),
+		filepath.Join(paths[1], "local", "local.libsonnet"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be vendored: %v", path, err)
+		}
+	}
+
+	// Checksums are verified
+	write(filepath.Join(project, LockFileName), fmt.Sprintf(lockfile, "bm90IHRoZSBzdW0="))
+	if _, _, err := vendorer.Vendor(context.Background(), root, project); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestLoadTraversal(t *testing.T) {
+	tcs := map[string]string{
+		"git subdir":      `{"source": {"git": {"remote": "https://example.com/lib.git", "subdir": "../../../etc"}}, "version": "v1"}`,
+		"git remote":      `{"source": {"git": {"remote": "https://../../../etc"}}, "version": "v1"}`,
+		"local directory": `{"source": {"local": {"directory": ".."}}, "version": ""}`,
+	}
+	for name, dep := range tcs {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, FileName), []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lockfile := `{"version": 1, "dependencies": [` + dep + `]}`
+			if err := ioutil.WriteFile(filepath.Join(dir, LockFileName), []byte(lockfile), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "invalid dependency name") {
+				t.Errorf("expected the dependency to be refused, got %v", err)
+			}
+		})
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	tcs := map[string]bool{
+		"github.com/grafana/jsonnet-libs": true,
+		"lib/../other":                    true,
+		"":                                false,
+		".":                               false,
+		"..":                              false,
+		"../lib":                          false,
+		"lib/../../other":                 false,
+		"/etc":                            false,
+		`..\lib`:                          false,
+	}
+	for name, want := range tcs {
+		if got := isLocal(name); got != want {
+			t.Errorf("expected isLocal(%q) to be %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestVendorNoGit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockfile := `{"version": 1, "dependencies": [
+		{"source": {"git": {"remote": "https://example.com/lib.git"}}, "version": "v1"}
+	]}`
+	for name, contents := range map[string]string{FileName: "{}", LockFileName: lockfile} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vendorer := &Vendorer{NoGit: true}
+	if _, _, err := vendorer.Vendor(context.Background(), dir, dir); !errors.Is(err, ErrGitDependenciesDisabled) {
+		t.Errorf("expected git dependencies to be refused, got %v", err)
+	}
+
+	// A committed vendor directory is used as is
+	if err := os.Mkdir(filepath.Join(dir, VendorDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	paths, release, err := vendorer.Vendor(context.Background(), dir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if len(paths) != 1 || paths[0] != filepath.Join(dir, VendorDir) {
+		t.Errorf("expected the vendor directory to be used, got %v", paths)
+	}
+}
@@ -18,96 +18,251 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 
 	"github.com/spf13/cobra"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func init() {
+	buildCmd.Flags().StringVar(&buildToken, "token", "", "a bearer token to authenticate to the controller with, defaults to the one in the kubeconfig")
+	buildCmd.Flags().StringVar(&buildCAFile, "certificate-authority", "", "the path to a CA bundle to verify the controller with, defaults to the one injected into its webhook configurations")
+
 	rootCmd.AddCommand(buildCmd)
 }
 
 var forwarder *portforward.PortForwarder
 var stopChan chan struct{}
 var localAddr string
+var buildToken string
+var buildCAFile string
+
+const (
+	// controllerName is the name of the controller's deployment, service, and webhook
+	// configurations.
+	controllerName = "jsonnet-controller"
+	// controllerNamespace is the namespace the controller runs in.
+	controllerNamespace = "flux-system"
+	// controllerCertSecret is the secret the controller shares its self-signed
+	// certificate through.
+	controllerCertSecret = controllerName + "-tls"
+	// controllerServerName is the name the certificate of the controller is verified
+	// against, the one the API server calls its webhooks with.
+	controllerServerName = controllerName + "." + controllerNamespace + ".svc"
+)
 
 var buildCmd = &cobra.Command{
-	Use:   "build [PATH]",
-	Short: "Evaluate what a given Konfiguration manifest would produce from the controller",
-	Args:  cobra.MaximumNArgs(1),
-	PreRunE: func(cmd *cobra.Command, args []string) error {
-		if err := checkClient(); err != nil {
-			return err
-		}
-		var err error
-		forwarder, stopChan, err = forwardControllerPort("9443")
+	Use:     "build [PATH]",
+	Short:   "Evaluate what a given Konfiguration manifest would produce from the controller",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error { return startControllerForward() },
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defer stopControllerForward()
+		data, err := readManifest(args)
 		if err != nil {
 			return err
 		}
-		ports, err := forwarder.GetPorts()
+		body, err := controllerBuild(data)
 		if err != nil {
-			stopChan <- struct{}{}
+			var buildErr *controllerBuildError
+			if errors.As(err, &buildErr) {
+				fmt.Fprintln(os.Stderr, "ERROR: ", buildErr.Error())
+				os.Exit(3)
+			}
 			return err
 		}
-		localAddr = fmt.Sprintf("https://127.0.0.1:%d/build", ports[0].Local)
+		fmt.Print(string(body))
 		return nil
 	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		defer func() { stopChan <- struct{}{} }()
-		httpClient := http.DefaultClient
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		}
-		if len(args) == 0 || args[0] == "-" {
-			stat, err := os.Stdin.Stat()
-			if err != nil {
-				return err
-			}
-			if stat.Mode()&os.ModeNamedPipe == 0 {
-				fmt.Fprintln(os.Stderr, "(reading from stdin)")
-			}
-			args = []string{os.Stdin.Name()}
-		}
+}
 
-		data, err := ioutil.ReadFile(args[0])
-		if err != nil {
-			return err
-		}
+// controllerBuildError is returned when the controller refuses or fails a build, or
+// another request.
+type controllerBuildError struct {
+	message string
+}
+
+func (c *controllerBuildError) Error() string { return c.message }
 
-		r, err := http.NewRequest(http.MethodGet, localAddr, bytes.NewBuffer(data))
+// startControllerForward forwards a local port to the web server of the controller.
+func startControllerForward() error {
+	if err := checkClient(); err != nil {
+		return err
+	}
+	var err error
+	forwarder, stopChan, err = forwardControllerPort("9443")
+	if err != nil {
+		return err
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		stopChan <- struct{}{}
+		return err
+	}
+	localAddr = fmt.Sprintf("https://127.0.0.1:%d", ports[0].Local)
+	return nil
+}
+
+// stopControllerForward stops a forward started by startControllerForward.
+func stopControllerForward() { stopChan <- struct{}{} }
+
+// readManifest reads the manifest at the path in args, or from stdin if there is none
+// or it is "-".
+func readManifest(args []string) ([]byte, error) {
+	if len(args) == 0 || args[0] == "-" {
+		stat, err := os.Stdin.Stat()
 		if err != nil {
-			return err
+			return nil, err
 		}
+		if stat.Mode()&os.ModeNamedPipe == 0 {
+			fmt.Fprintln(os.Stderr, "(reading from stdin)")
+		}
+		args = []string{os.Stdin.Name()}
+	}
+	return ioutil.ReadFile(args[0])
+}
 
-		res, err := httpClient.Do(r)
-		if err != nil {
-			return err
+// controllerBuild sends the given Konfiguration manifest to the controller and returns
+// the YAML stream it produced.
+func controllerBuild(data []byte) ([]byte, error) {
+	return controllerRequest(http.MethodGet, "/build", bytes.NewBuffer(data))
+}
+
+// controllerRequest sends a request to the given path of the forwarded controller and
+// returns the body of its response.
+func controllerRequest(method, path string, reqBody io.Reader) ([]byte, error) {
+	caBundle, err := controllerCA(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := controllerHTTPClient(restConfig, buildToken, caBundle)
+	if err != nil {
+		return nil, err
+	}
+	return doControllerRequest(httpClient, method, localAddr+path, reqBody, hasCertAuth(restConfig, buildToken))
+}
+
+// controllerCA returns the CA bundle to verify the certificate of the controller with.
+// Unless given with --certificate-authority, it is read from the webhook configurations
+// the controller injects its certificate into, or else the secret it shares it through.
+func controllerCA(ctx context.Context) ([]byte, error) {
+	if buildCAFile != "" {
+		return ioutil.ReadFile(buildCAFile)
+	}
+
+	var webhooks admissionregistrationv1.ValidatingWebhookConfiguration
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: controllerName}, &webhooks); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	} else if err == nil {
+		for _, webhook := range webhooks.Webhooks {
+			if len(webhook.ClientConfig.CABundle) > 0 {
+				return webhook.ClientConfig.CABundle, nil
+			}
 		}
-		defer res.Body.Close()
+	}
 
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return err
+	var secret corev1.Secret
+	if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: controllerNamespace, Name: controllerCertSecret}, &secret); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	} else if err == nil {
+		if ca := secret.Data["ca.crt"]; len(ca) > 0 {
+			return ca, nil
+		}
+		if ca := secret.Data[corev1.TLSCertKey]; len(ca) > 0 {
+			return ca, nil
 		}
+	}
 
-		if res.StatusCode != http.StatusOK {
-			var errMap map[string]string
-			if err := json.Unmarshal(body, &errMap); err != nil {
-				return err
-			}
-			fmt.Fprintln(os.Stderr, "ERROR: ", errMap["error"])
-			os.Exit(3)
+	return nil, fmt.Errorf("could not find the CA of the controller in the '%s' webhook configuration or the '%s/%s' secret, pass it with --certificate-authority",
+		controllerName, controllerNamespace, controllerCertSecret)
+}
+
+// controllerHTTPClient returns a client verifying the controller against the given CA
+// bundle, and authenticating to it with the bearer token (or exec/auth provider) from
+// the given config, or the given token. The controller reviews it with the API server.
+func controllerHTTPClient(config *rest.Config, token string, caBundle []byte) (*http.Client, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return nil, errors.New("the CA bundle of the controller contains no certificates")
+	}
+
+	transportConfig, err := config.TransportConfig()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		transportConfig.BearerToken = token
+		transportConfig.BearerTokenFile = ""
+	}
+	// Only the bearer token is passed on: client certificates are meant for the API
+	// server, and the controller could not verify them.
+	rt, err := transport.HTTPWrappersForConfig(transportConfig, &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: controllerServerName,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// hasCertAuth returns whether the config authenticates with a client certificate only.
+func hasCertAuth(config *rest.Config, token string) bool {
+	if token != "" || config.BearerToken != "" || config.BearerTokenFile != "" ||
+		config.ExecProvider != nil || config.AuthProvider != nil {
+		return false
+	}
+	return len(config.CertData) > 0 || config.CertFile != ""
+}
+
+// doControllerRequest sends a request with the given client and returns the body of
+// its response. certAuth hints that a refused request lacked a bearer token.
+func doControllerRequest(httpClient *http.Client, method, url string, reqBody io.Reader, certAuth bool) ([]byte, error) {
+	r, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var errMap map[string]string
+		if err := json.Unmarshal(body, &errMap); err != nil {
+			return nil, err
+		}
+		msg := errMap["error"]
+		if res.StatusCode == http.StatusUnauthorized && certAuth {
+			msg += ": the kubeconfig authenticates with a client certificate, which the controller cannot verify," +
+				" pass a bearer token with --token (e.g. from 'kubectl create token')"
 		}
+		return nil, &controllerBuildError{message: msg}
+	}
 
-		fmt.Print(string(body))
-		return nil
-	},
+	return body, nil
 }
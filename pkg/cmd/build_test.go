@@ -0,0 +1,211 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/pelotech/jsonnet-controller/pkg/gencert"
+)
+
+// newTestCert generates a certificate for the given names and returns it and its key.
+func newTestCert(t *testing.T, dnsNames ...string) (cert, key []byte) {
+	t.Helper()
+	dir, err := gencert.GenerateCert(dnsNames...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if cert, err = ioutil.ReadFile(filepath.Join(dir, "tls.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if key, err = ioutil.ReadFile(filepath.Join(dir, "tls.key")); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// newTestController starts a TLS server with the given certificate, that answers with
+// the bearer token it received, or refuses requests without one.
+func newTestController(t *testing.T, cert, key []byte) *httptest.Server {
+	t.Helper()
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "a valid bearer token is required"}`))
+			return
+		}
+		w.Write([]byte(token))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	srv.StartTLS()
+	return srv
+}
+
+func TestControllerRequest(t *testing.T) {
+	cert, key := newTestCert(t, controllerServerName)
+	otherCA, _ := newTestCert(t, controllerServerName)
+	srv := newTestController(t, cert, key)
+	defer srv.Close()
+
+	tcs := []struct {
+		name     string
+		config   *rest.Config
+		token    string
+		caBundle []byte
+		expected string
+		errMsg   string
+	}{
+		{
+			name:     "kubeconfig token",
+			config:   &rest.Config{BearerToken: "kubeconfig"},
+			caBundle: cert,
+			expected: "kubeconfig",
+		},
+		{
+			name:     "token flag",
+			config:   &rest.Config{BearerToken: "kubeconfig"},
+			token:    "flag",
+			caBundle: cert,
+			expected: "flag",
+		},
+		{
+			name: "client certificate",
+			config: &rest.Config{TLSClientConfig: rest.TLSClientConfig{
+				CertData: cert,
+				KeyData:  key,
+			}},
+			caBundle: cert,
+			errMsg:   "pass a bearer token with --token",
+		},
+		{
+			name:     "untrusted certificate",
+			config:   &rest.Config{BearerToken: "kubeconfig"},
+			caBundle: otherCA,
+			errMsg:   "certificate",
+		},
+		{
+			name:     "invalid CA bundle",
+			config:   &rest.Config{BearerToken: "kubeconfig"},
+			caBundle: []byte("garbage"),
+			errMsg:   "contains no certificates",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := func() ([]byte, error) {
+				httpClient, err := controllerHTTPClient(tc.config, tc.token, tc.caBundle)
+				if err != nil {
+					return nil, err
+				}
+				return doControllerRequest(httpClient, http.MethodGet, srv.URL+"/build", nil, hasCertAuth(tc.config, tc.token))
+			}()
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Errorf("expected an error containing %q, got %v", tc.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tc.expected {
+				t.Errorf("expected the controller to receive token %q, got %q", tc.expected, string(body))
+			}
+		})
+	}
+
+	// Refused requests are reported as build errors
+	httpClient, err := controllerHTTPClient(&rest.Config{}, "", cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doControllerRequest(httpClient, http.MethodGet, srv.URL+"/build", nil, false)
+	var buildErr *controllerBuildError
+	if !errors.As(err, &buildErr) || buildErr.Error() != "a valid bearer token is required" {
+		t.Errorf("expected a build error, got %v", err)
+	}
+}
+
+func TestControllerCA(t *testing.T) {
+	defer func(c client.Client) { k8sClient = c }(k8sClient)
+	ctx := context.Background()
+
+	webhooks := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: controllerName},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:         "vkonfiguration.jsonnet.io",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("webhook")},
+		}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: controllerNamespace, Name: controllerCertSecret},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("secret")},
+	}
+
+	tcs := []struct {
+		name     string
+		objs     []client.Object
+		expected string
+	}{
+		{"webhook configuration", []client.Object{webhooks, secret}, "webhook"},
+		{"secret", []client.Object{secret}, "secret"},
+		{"missing", nil, ""},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tc.objs...).Build()
+			ca, err := controllerCA(ctx)
+			if tc.expected == "" {
+				if err == nil || !strings.Contains(err.Error(), "--certificate-authority") {
+					t.Errorf("expected an error pointing at --certificate-authority, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(ca, []byte(tc.expected)) {
+				t.Errorf("expected CA %q, got %q", tc.expected, string(ca))
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+)
+
+func init() {
+	cacheCmd.PersistentFlags().StringVar(&buildToken, "token", "", "a bearer token to authenticate to the controller with, defaults to the one in the kubeconfig")
+	cacheCmd.PersistentFlags().StringVar(&buildCAFile, "certificate-authority", "", "the path to a CA bundle to verify the controller with, defaults to the one injected into its webhook configurations")
+	cacheListCmd.Flags().BoolVarP(&cacheListJSON, "json", "j", false, "print the cached files as JSON")
+
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePurgeCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+var cacheListJSON bool
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and purge the HTTP(S) paths and remote imports cached by the controller",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the files in the cache of the controller",
+	Args:    cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error { return startControllerForward() },
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defer stopControllerForward()
+		body, err := controllerRequest(http.MethodGet, "/cache", nil)
+		if err != nil {
+			return err
+		}
+		if cacheListJSON {
+			fmt.Print(string(body))
+			return nil
+		}
+		var entries []jsonnet.RemoteFileInfo
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "URL\tSIZE\tFETCHED\tLAST USED\tIMMUTABLE")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%d\t%s ago\t%s ago\t%t\n", entry.URL, entry.Size,
+				duration.HumanDuration(time.Since(entry.FetchedAt)), duration.HumanDuration(time.Since(entry.LastUsed)), entry.Immutable)
+		}
+		return w.Flush()
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:     "purge [URL-PREFIX]",
+	Short:   "Remove files from the cache of the controller, all of them unless a URL prefix is given",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error { return startControllerForward() },
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defer stopControllerForward()
+		path := "/cache"
+		if len(args) == 1 {
+			path += "?prefix=" + url.QueryEscape(args[0])
+		}
+		body, err := controllerRequest(http.MethodDelete, path, nil)
+		if err != nil {
+			return err
+		}
+		var out map[string]int
+		if err := json.Unmarshal(body, &out); err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d file(s) from the cache\n", out["purged"])
+		return nil
+	},
+}
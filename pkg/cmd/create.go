@@ -58,7 +58,8 @@ func init() {
 	createFlags.StringVarP(&createSpec.Spec.Path, "path", "p", "/", "the path to the jsonnet to reconcile")
 	createFlags.StringVar(&sourceRef.Name, "source-name", "", "the name of the source object containing the jsonnet code")
 	createFlags.StringVar(&sourceRef.Namespace, "source-namespace", "", "the namespace of the source object containing the jsonnet code (defaults to the creation namespace)")
-	createFlags.StringVar(&sourceRef.Kind, "source-kind", "GitRepository", "the kind of source provided by --source-name.")
+	createFlags.StringVar(&sourceRef.Kind, "source-kind", "GitRepository", "the kind of source provided by --source-name, one of GitRepository, Bucket, HelmChart, OCIRepository, or any kind publishing an artifact with --source-api-version")
+	createFlags.StringVar(&sourceRef.APIVersion, "source-api-version", "", "the api version of the source provided by --source-name, required for kinds other than GitRepository, Bucket, HelmChart, and OCIRepository")
 	createFlags.StringVarP(&createSpec.Namespace, "namespace", "n", "default", "the namespace to create the resource")
 	createFlags.DurationVar(&createSpec.Spec.Interval.Duration, "interval", time.Minute*5, "the interval to reconcile the konfiguration")
 	createFlags.DurationVar(&createSpec.Spec.RetryInterval.Duration, "retry-interval", time.Duration(0), "the interval to reconcile the konfiguration")
@@ -101,6 +102,9 @@ var createCmd = &cobra.Command{
 			if sourceRef.Namespace == "" {
 				sourceRef.Namespace = createSpec.Namespace
 			}
+			if _, _, err := konfigurationv1.ArtifactSourceGVK(&sourceRef); err != nil {
+				return err
+			}
 			createSpec.Spec.SourceRef = &sourceRef
 		}
 		if createSpec.Spec.RetryInterval.Duration == 0 {
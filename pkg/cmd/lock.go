@@ -0,0 +1,108 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+)
+
+var lockKonfig = &konfigurationv1.Konfiguration{
+	Spec: konfigurationv1.KonfigurationSpec{
+		Variables: &konfigurationv1.Variables{
+			ExtStr:  map[string]string{},
+			ExtCode: map[string]string{},
+			TLAStr:  map[string]string{},
+			TLACode: map[string]string{},
+		},
+	},
+}
+
+var lockOutput string
+
+func init() {
+	flags := lockCmd.Flags()
+
+	flags.StringToStringVar(&lockKonfig.Spec.Variables.ExtStr, "ext-str", nil, "external string variables")
+	flags.StringToStringVar(&lockKonfig.Spec.Variables.ExtCode, "ext-code", nil, "external code variables")
+	flags.StringToStringVar(&lockKonfig.Spec.Variables.TLAStr, "tla-str", nil, "top-level string variables")
+	flags.StringToStringVar(&lockKonfig.Spec.Variables.TLACode, "tla-code", nil, "top-level code variables")
+	flags.StringSliceVar(&lockKonfig.Spec.JsonnetPaths, "jsonnet-path", nil, "additional search paths, relative to the current directory")
+	flags.StringSliceVar(&lockKonfig.Spec.JsonnetURLs, "jsonnet-url", nil, "additional HTTP(S) search URLs")
+	flags.StringVarP(&lockOutput, "output", "o", jsonnet.LockFileName, "the lockfile to write, or - for stdout")
+
+	rootCmd.AddCommand(lockCmd)
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock [FILE]",
+	Short: "Write a lockfile pinning the remote imports of a jsonnet file or path",
+	Long: `Write a lockfile pinning the remote imports of a jsonnet file or path.
+
+FILE is evaluated locally, like with "show", and the sha256 digest of every file it
+imports over HTTP(S) is written to the lockfile. The controller reads jsonnet.lock.json
+from the root of a source, or the file set in the importLock of a Konfiguration, and
+refuses remote imports that are missing from it or whose contents changed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		builder, err := jsonnet.NewBuilder(lockKonfig, cwd, localBuilderOptions()...)
+		if err != nil {
+			return err
+		}
+		out, err := builder.Build(context.Background(), nil, args[0])
+		if err != nil {
+			return err
+		}
+
+		imports := make(map[string]string)
+		for foundAt, sum := range out.Imports() {
+			if sum != "" && (strings.HasPrefix(foundAt, "http://") || strings.HasPrefix(foundAt, "https://")) {
+				imports[foundAt] = sum
+			}
+		}
+		lock, err := jsonnet.NewImportLock(imports)
+		if err != nil {
+			return err
+		}
+		data, err := lock.Marshal()
+		if err != nil {
+			return err
+		}
+
+		if lockOutput == "-" {
+			fmt.Print(string(data))
+			return nil
+		}
+		if err := ioutil.WriteFile(lockOutput, data, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Pinned %d remote import(s) in %s\n", len(imports), lockOutput)
+		return nil
+	},
+}
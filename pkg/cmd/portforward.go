@@ -35,8 +35,8 @@ import (
 
 func forwardControllerPort(port string) (forwarder *portforward.PortForwarder, stopChan chan struct{}, err error) {
 	var podList corev1.PodList
-	if err = k8sClient.List(context.Background(), &podList, client.InNamespace("flux-system"), client.MatchingLabels{
-		"app": "jsonnet-controller",
+	if err = k8sClient.List(context.Background(), &podList, client.InNamespace(controllerNamespace), client.MatchingLabels{
+		"app": controllerName,
 	}); err != nil {
 		return
 	}
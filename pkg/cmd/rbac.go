@@ -0,0 +1,276 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+	"github.com/pelotech/jsonnet-controller/pkg/permissions"
+)
+
+var rbacKonfig = &konfigurationv1.Konfiguration{
+	Spec: konfigurationv1.KonfigurationSpec{
+		Variables: &konfigurationv1.Variables{
+			ExtStr:  map[string]string{},
+			ExtCode: map[string]string{},
+			TLAStr:  map[string]string{},
+			TLACode: map[string]string{},
+		},
+	},
+}
+
+var rbacFromController bool
+var rbacRoleName string
+var rbacOutput string
+
+func init() {
+	flags := rbacCmd.Flags()
+
+	flags.BoolVar(&rbacFromController, "controller", false, "treat PATH as a Konfiguration manifest and build it with the controller")
+	flags.StringVarP(&rbacKonfig.Namespace, "namespace", "n", "default", "the namespace of the konfiguration, ignored with --controller")
+	flags.StringVar(&rbacKonfig.Spec.ServiceAccountName, "service-account", "default", "the service account to bind the roles to, ignored with --controller")
+	flags.BoolVar(&rbacKonfig.Spec.Prune, "prune", true, "include the permissions needed to garbage collect orphaned resources, ignored with --controller")
+	flags.BoolVar(&rbacKonfig.Spec.Force, "force", false, "include the permissions needed to recreate immutable resources, ignored with --controller")
+	flags.StringToStringVar(&rbacKonfig.Spec.Variables.ExtStr, "ext-str", nil, "external string variables")
+	flags.StringToStringVar(&rbacKonfig.Spec.Variables.ExtCode, "ext-code", nil, "external code variables")
+	flags.StringToStringVar(&rbacKonfig.Spec.Variables.TLAStr, "tla-str", nil, "top-level string variables")
+	flags.StringToStringVar(&rbacKonfig.Spec.Variables.TLACode, "tla-code", nil, "top-level code variables")
+	flags.StringVar(&rbacRoleName, "name", "", "the name of the generated roles and bindings (defaults to konfig-<service-account>)")
+	flags.StringVarP(&rbacOutput, "output", "o", "yaml", "the output format, one of yaml or jsonnet")
+	flags.StringVar(&buildToken, "token", "", "a bearer token to authenticate to the controller with when using --controller")
+	flags.StringVar(&buildCAFile, "certificate-authority", "", "the path to a CA bundle to verify the controller with when using --controller")
+
+	rootCmd.AddCommand(rbacCmd)
+}
+
+var rbacCmd = &cobra.Command{
+	Use:   "rbac [PATH]",
+	Short: "Generate the minimal RBAC a Konfiguration's service account needs to apply a build",
+	Long: `Generate the minimal RBAC a Konfiguration's service account needs to apply a build.
+
+By default PATH is a jsonnet file or path that is evaluated locally, like with "show".
+With --controller, PATH is a Konfiguration manifest that is built by the controller,
+like with "build", and the namespace, service account, and prune settings are taken
+from it.
+
+A Role and RoleBinding is emitted for every namespace objects are rendered into, and
+a ClusterRole and ClusterRoleBinding for cluster-scoped objects. They grant the verbs
+used by the controller to apply and garbage collect the rendered objects.`,
+	Args: cobra.MaximumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if rbacOutput != "yaml" && rbacOutput != "jsonnet" {
+			return fmt.Errorf("invalid output format '%s', must be one of yaml or jsonnet", rbacOutput)
+		}
+		if !rbacFromController && len(args) == 0 {
+			return errors.New("a PATH is required when not building with --controller")
+		}
+		if rbacFromController {
+			return startControllerForward()
+		}
+		return checkClient()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var objs []*unstructured.Unstructured
+		var err error
+		if rbacFromController {
+			defer stopControllerForward()
+			objs, err = rbacControllerBuild(args)
+		} else {
+			objs, err = rbacLocalBuild(args[0])
+		}
+		if err != nil {
+			return err
+		}
+
+		perms := permissions.NewSet()
+		if err := perms.AddApply(k8sClient.RESTMapper(), objs, rbacKonfig.ForceCreate()); err != nil {
+			return err
+		}
+		if rbacKonfig.GCEnabled() {
+			snapshot, err := konfigurationv1.NewSnapshotFromUnstructured(objs)
+			if err != nil {
+				return err
+			}
+			if err := perms.AddPrune(k8sClient.RESTMapper(), snapshot); err != nil {
+				return err
+			}
+		}
+
+		name := rbacRoleName
+		if name == "" {
+			name = "konfig-" + rbacKonfig.GetServiceAccountName()
+		}
+		rbacObjs := rbacObjects(name, rbacKonfig.GetNamespace(), rbacKonfig.GetServiceAccountName(), perms.PolicyRules())
+
+		if rbacOutput == "jsonnet" {
+			return writeJsonnet(os.Stdout, rbacObjs)
+		}
+		for _, obj := range rbacObjs {
+			fmt.Println("---")
+			if err := serializer.Encode(obj, os.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// rbacLocalBuild evaluates the jsonnet at the given path with the konfiguration
+// configured from flags.
+func rbacLocalBuild(path string) ([]*unstructured.Unstructured, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	builder, err := jsonnet.NewBuilder(rbacKonfig, cwd, localBuilderOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := builder.Build(context.Background(), k8sClient.RESTMapper(), path)
+	if err != nil {
+		return nil, err
+	}
+	return out.SortedObjects(), nil
+}
+
+// rbacControllerBuild reads the Konfiguration manifest in args, replaces the flag
+// configured konfiguration with it, and returns the objects the controller built
+// from it.
+func rbacControllerBuild(args []string) ([]*unstructured.Unstructured, error) {
+	data, err := readManifest(args)
+	if err != nil {
+		return nil, err
+	}
+	var konfig konfigurationv1.Konfiguration
+	if err := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 2048).Decode(&konfig); err != nil {
+		return nil, err
+	}
+	if konfig.GetNamespace() == "" {
+		konfig.SetNamespace("default")
+	}
+	if konfig.GetServiceAccountName() == "" {
+		return nil, errors.New("the konfiguration does not set a serviceAccountName")
+	}
+	rbacKonfig = &konfig
+
+	stream, err := controllerBuild(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(stream), 2048)
+	objs := make([]*unstructured.Unstructured, 0)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// rbacObjects returns the roles and bindings granting the given rules, keyed by namespace,
+// to the service account.
+func rbacObjects(name, namespace, serviceAccount string, rules map[string][]rbacv1.PolicyRule) []runtime.Object {
+	subjects := []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      serviceAccount,
+		Namespace: namespace,
+	}}
+
+	namespaces := make([]string, 0, len(rules))
+	for ns := range rules {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	objs := make([]runtime.Object, 0, len(rules)*2)
+	for _, ns := range namespaces {
+		if ns == "" {
+			objs = append(objs,
+				&rbacv1.ClusterRole{
+					TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					Rules:      rules[ns],
+				},
+				&rbacv1.ClusterRoleBinding{
+					TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+					Subjects:   subjects,
+				},
+			)
+			continue
+		}
+		objs = append(objs,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+				Rules:      rules[ns],
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+				Subjects:   subjects,
+			},
+		)
+	}
+	return objs
+}
+
+// writeJsonnet writes the objects as a jsonnet array. The objects are converted to
+// unstructured first to drop empty fields like creationTimestamp.
+func writeJsonnet(w io.Writer, objs []runtime.Object) error {
+	out := make([]map[string]interface{}, len(objs))
+	for i, obj := range objs {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+		out[i] = u
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
@@ -41,9 +41,11 @@ func init() {
 	cobra.OnInitialize(initClient)
 
 	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "The path to a kubernetes kubeconfig, defaults to ~/.kube/config.")
+	rootCmd.PersistentFlags().StringVar(&ociLayout, "oci-layout", "", "The path to an OCI image layout searched for the artifacts of oci:// imports before their registry.")
 }
 
 var kubeconfig string
+var ociLayout string
 var restConfig *rest.Config
 var k8sClient client.Client
 var clientErr error
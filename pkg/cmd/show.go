@@ -59,7 +59,7 @@ var showCommand = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		builder, err := jsonnet.NewBuilder(showKonfig, cwd, "")
+		builder, err := jsonnet.NewBuilder(showKonfig, cwd, localBuilderOptions()...)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/discovery"
+
+	"github.com/pelotech/jsonnet-controller/pkg/bundler"
+	"github.com/pelotech/jsonnet-controller/pkg/jsonnet"
+	"github.com/pelotech/jsonnet-controller/pkg/oci"
+)
+
+func init() {
+	rootCmd.AddCommand(vendorCmd)
+}
+
+var vendorCmd = &cobra.Command{
+	Use:   "vendor [DIR]",
+	Short: "Install the jsonnet-bundler dependencies locked in a directory to its vendor directory",
+	Long: `Install the jsonnet-bundler dependencies locked in a directory to its vendor directory.
+
+The dependencies in jsonnetfile.lock.json are installed to DIR/vendor, defaulting to the
+current directory, the same way the controller installs them for sources that do not
+commit their vendor directory. Git dependencies are checked out at their locked
+commit and verified against their checksum.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		lock, err := bundler.Load(dir)
+		if err != nil {
+			return err
+		}
+		if lock == nil {
+			return errors.New("no dependencies are declared in " + filepath.Join(dir, bundler.FileName))
+		}
+		if err := lock.Install(context.Background(), dir, nil); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Installed %d dependencies to %s\n", len(lock.Dependencies), filepath.Join(dir, bundler.VendorDir))
+		return nil
+	},
+}
+
+// localBuilderOptions returns the options for builders evaluating jsonnet locally,
+// installing the dependencies of projects that did not vendor them and pulling OCI
+// artifacts to temporary directories. ConfigMaps are imported with the configured
+// kubeconfig, if any.
+func localBuilderOptions() []jsonnet.BuilderOption {
+	vendorer := &bundler.Vendorer{}
+	opts := []jsonnet.BuilderOption{
+		jsonnet.WithVendor(func(ctx context.Context, dir string) ([]string, func(), error) {
+			return vendorer.Vendor(ctx, string(filepath.Separator), dir)
+		}),
+		jsonnet.WithImportScheme("oci", true, jsonnet.OCIImporter(&oci.Client{Layout: ociLayout}, nil)),
+	}
+	if k8sClient != nil {
+		opts = append(opts,
+			jsonnet.WithImportScheme("k8s", false, jsonnet.ConfigMapImporter(k8sClient)),
+			jsonnet.WithLookup(k8sClient),
+		)
+		if dc, err := discovery.NewDiscoveryClientForConfig(restConfig); err == nil {
+			opts = append(opts, jsonnet.WithCapabilities(jsonnet.DiscoverCapabilities(dc)))
+		}
+	}
+	return opts
+}
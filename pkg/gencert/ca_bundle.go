@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gencert
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ServiceDNSNames returns the names a service is reachable at from inside the cluster.
+func ServiceDNSNames(name, namespace string) []string {
+	return []string{
+		name,
+		fmt.Sprintf("%s.%s", name, namespace),
+		fmt.Sprintf("%s.%s.svc", name, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace),
+	}
+}
+
+// InjectCABundle sets the certificate in the given path as the CA bundle of every
+// webhook in the mutating and validating webhook configurations with the given name.
+// Configurations that do not exist are skipped.
+func InjectCABundle(ctx context.Context, c client.Client, path, name string) error {
+	caBundle, err := ioutil.ReadFile(filepath.Join(path, "tls.crt"))
+	if err != nil {
+		return err
+	}
+
+	var mutating admissionregistrationv1.MutatingWebhookConfiguration
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, &mutating); client.IgnoreNotFound(err) != nil {
+		return err
+	} else if err == nil {
+		patch := client.MergeFrom(mutating.DeepCopy())
+		for i := range mutating.Webhooks {
+			mutating.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if err := c.Patch(ctx, &mutating, patch); err != nil {
+			return err
+		}
+	}
+
+	var validating admissionregistrationv1.ValidatingWebhookConfiguration
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, &validating); client.IgnoreNotFound(err) != nil {
+		return err
+	} else if err == nil {
+		patch := client.MergeFrom(validating.DeepCopy())
+		for i := range validating.Webhooks {
+			validating.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if err := c.Patch(ctx, &validating, patch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -27,20 +27,25 @@ import (
 	"encoding/pem"
 	"io/ioutil"
 	"math/big"
-	"os"
 	"path/filepath"
 	"time"
 )
 
 // GenerateCert will generate a self-signed certificate and return the path
 // to where the files can be loaded. If no error is returned a tls.crt and
-// tls.key will be present in the path.
-func GenerateCert() (path string, err error) {
-	path, err = ioutil.TempDir("", "")
+// tls.key will be present in the path. The certificate is valid for
+// "jsonnet-controller" and any additional dnsNames.
+func GenerateCert(dnsNames ...string) (path string, err error) {
+	cert, key, err := generateCertPEM(dnsNames...)
 	if err != nil {
 		return
 	}
+	return writeCert(cert, key)
+}
 
+// generateCertPEM generates a self-signed certificate and returns it and its key
+// PEM-encoded.
+func generateCertPEM(dnsNames ...string) (cert, key []byte, err error) {
 	// generate a key
 	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -54,9 +59,7 @@ func GenerateCert() (path string, err error) {
 			CommonName:   "jsonnet-controller",
 			Organization: []string{"pelotech"},
 		},
-		DNSNames: []string{
-			"jsonnet-controller",
-		},
+		DNSNames:              append([]string{"jsonnet-controller"}, dnsNames...),
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().Add(time.Hour * 24 * 365),
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
@@ -70,27 +73,21 @@ func GenerateCert() (path string, err error) {
 		return
 	}
 
-	// Write files to disk
-	certPath := filepath.Join(path, "tls.crt")
-	keyPath := filepath.Join(path, "tls.key")
-
-	cf, err := os.Create(certPath)
-	if err != nil {
-		return
-	}
-	defer cf.Close()
-	if err = pem.Encode(cf, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		return
-	}
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	key = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)})
+	return
+}
 
-	kf, err := os.Create(keyPath)
+// writeCert writes the given PEM-encoded certificate and key to a temp directory as
+// tls.crt and tls.key, and returns its path.
+func writeCert(cert, key []byte) (path string, err error) {
+	path, err = ioutil.TempDir("", "")
 	if err != nil {
 		return
 	}
-	defer kf.Close()
-	if err = pem.Encode(kf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)}); err != nil {
+	if err = ioutil.WriteFile(filepath.Join(path, "tls.crt"), cert, 0644); err != nil {
 		return
 	}
-
+	err = ioutil.WriteFile(filepath.Join(path, "tls.key"), key, 0600)
 	return
 }
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gencert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// renewBefore is how long before it expires a certificate in a secret is replaced.
+const renewBefore = 30 * 24 * time.Hour
+
+// maxSecretAttempts bounds the retries when replicas race to create or renew the
+// same secret.
+const maxSecretAttempts = 5
+
+// SecretCert returns the path to a tls.crt and tls.key read from the given secret.
+// If the secret does not exist, or its certificate is about to expire or does not
+// cover all of the dnsNames, a new self-signed certificate is generated and stored
+// in it. Replicas sharing the secret therefore serve, and inject, the same certificate.
+func SecretCert(ctx context.Context, c client.Client, namespace, name string, dnsNames ...string) (string, error) {
+	for attempt := 0; attempt < maxSecretAttempts; attempt++ {
+		var secret corev1.Secret
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret)
+		if client.IgnoreNotFound(err) != nil {
+			return "", fmt.Errorf("failed to retrieve certificate secret '%s/%s': %w", namespace, name, err)
+		}
+		exists := err == nil
+		if exists && validCert(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], dnsNames) {
+			return writeCert(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		}
+
+		cert, key, err := generateCertPEM(dnsNames...)
+		if err != nil {
+			return "", err
+		}
+		secret.Data = map[string][]byte{corev1.TLSCertKey: cert, corev1.TLSPrivateKeyKey: key}
+		if exists {
+			err = c.Update(ctx, &secret)
+		} else {
+			secret.ObjectMeta = metav1.ObjectMeta{Namespace: namespace, Name: name}
+			secret.Type = corev1.SecretTypeTLS
+			err = c.Create(ctx, &secret)
+		}
+		switch {
+		case err == nil:
+			return writeCert(cert, key)
+		case apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err):
+			// Another replica stored a certificate first, use that one
+			continue
+		default:
+			return "", fmt.Errorf("failed to store certificate secret '%s/%s': %w", namespace, name, err)
+		}
+	}
+	return "", fmt.Errorf("failed to store certificate secret '%s/%s': too many conflicts", namespace, name)
+}
+
+// validCert returns whether the PEM-encoded certificate matches the key, covers all of
+// the dnsNames, and is not about to expire.
+func validCert(certPEM, keyPEM []byte, dnsNames []string) bool {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(renewBefore).After(cert.NotAfter) {
+		return false
+	}
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gencert
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func readCert(t *testing.T, path string) []byte {
+	t.Helper()
+	defer os.RemoveAll(path)
+	cert, err := ioutil.ReadFile(filepath.Join(path, "tls.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSecretCert(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	dnsNames := ServiceDNSNames("jsonnet-controller", "flux-system")
+
+	// The first replica generates the certificate
+	path, err := SecretCert(ctx, c, "flux-system", "tls", dnsNames...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := readCert(t, path)
+
+	var secret corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "tls"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data[corev1.TLSCertKey], first) {
+		t.Error("expected the certificate to be stored in the secret")
+	}
+
+	// Other replicas reuse it
+	path, err = SecretCert(ctx, c, "flux-system", "tls", dnsNames...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readCert(t, path), first) {
+		t.Error("expected the stored certificate to be reused")
+	}
+
+	// It is replaced when it does not cover the service anymore
+	path, err = SecretCert(ctx, c, "flux-system", "tls", ServiceDNSNames("renamed", "flux-system")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(readCert(t, path), first) {
+		t.Error("expected a new certificate for other DNS names")
+	}
+}
+
+func TestSecretCertInvalid(t *testing.T) {
+	ctx := context.Background()
+	secret := &corev1.Secret{
+		Data: map[string][]byte{corev1.TLSCertKey: []byte("garbage"), corev1.TLSPrivateKeyKey: []byte("garbage")},
+	}
+	secret.SetNamespace("flux-system")
+	secret.SetName("tls")
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(secret).Build()
+
+	path, err := SecretCert(ctx, c, "flux-system", "tls", "jsonnet-controller.flux-system.svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := readCert(t, path)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data[corev1.TLSCertKey], cert) {
+		t.Error("expected the invalid certificate to be replaced")
+	}
+	if !validCert(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], []string{"jsonnet-controller.flux-system.svc"}) {
+		t.Error("expected the new certificate to be valid")
+	}
+}
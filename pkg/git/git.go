@@ -0,0 +1,236 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package git checks out git repositories with the git command line.
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Reference is the reference of a repository to check out. At most one of its fields
+// is set, and the remote HEAD is checked out when none are.
+type Reference struct {
+	Branch string
+	Tag    string
+	Commit string
+}
+
+// Auth holds the credentials for a repository, a username and password for HTTPS or
+// an identity and known hosts for SSH.
+type Auth struct {
+	Username   string
+	Password   string
+	Identity   []byte
+	KnownHosts []byte
+}
+
+// Repository is a remote git repository.
+type Repository struct {
+	// URL is the URL of the repository.
+	URL string
+	// Auth holds the credentials for the repository, if any.
+	Auth *Auth
+	// AllowedProtocols restricts the transports git may use, for example to prevent
+	// reading repositories from the local filesystem. All of git's defaults are
+	// allowed when empty.
+	AllowedProtocols []string
+}
+
+// Resolve returns the commit the reference points at, and the revision to report for
+// it, in the form <branch>/<commit> or <tag>/<commit>. A commit reference is returned
+// as is without contacting the remote.
+func (r *Repository) Resolve(ctx context.Context, ref Reference) (commit, revision string, err error) {
+	if ref.Commit != "" {
+		return ref.Commit, ref.Commit, nil
+	}
+	var name string
+	args := []string{"ls-remote", "--", r.URL}
+	switch {
+	case ref.Tag != "":
+		name = ref.Tag
+		// Annotated tags are peeled to the commit they point at
+		args = append(args, "refs/tags/"+ref.Tag, "refs/tags/"+ref.Tag+"^{}")
+	case ref.Branch != "":
+		name = ref.Branch
+		args = append(args, "refs/heads/"+ref.Branch)
+	default:
+		args = []string{"ls-remote", "--symref", "--", r.URL, "HEAD"}
+	}
+
+	out, err := r.run(ctx, "", args...)
+	if err != nil {
+		return "", "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 3 && fields[0] == "ref:":
+			// The branch the remote HEAD points at
+			name = strings.TrimPrefix(fields[1], "refs/heads/")
+		case len(fields) == 2 && (commit == "" || strings.HasSuffix(fields[1], "^{}")):
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		if name == "" {
+			name = "HEAD"
+		}
+		return "", "", fmt.Errorf("reference '%s' not found in %s", name, r.URL)
+	}
+	if name == "" {
+		name = "HEAD"
+	}
+	return commit, fmt.Sprintf("%s/%s", name, commit), nil
+}
+
+// Checkout checks out the given commit of the reference into dir, which must be empty.
+// When sparsePath is set only that path of the repository is checked out. The metadata
+// of the repository is removed, leaving only its files.
+func (r *Repository) Checkout(ctx context.Context, ref Reference, commit, sparsePath, dir string) error {
+	if _, err := r.run(ctx, "", "init", "-q", "--", dir); err != nil {
+		return err
+	}
+	if _, err := r.run(ctx, dir, "remote", "add", "origin", r.URL); err != nil {
+		return err
+	}
+	if sparsePath = strings.Trim(filepath.ToSlash(filepath.Clean("/"+sparsePath)), "/"); sparsePath != "" {
+		if _, err := r.run(ctx, dir, "config", "core.sparseCheckout", "true"); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, ".git", "info", "sparse-checkout"), []byte("/"+sparsePath+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	refspec := "HEAD"
+	switch {
+	case ref.Commit != "":
+		refspec = ref.Commit
+	case ref.Tag != "":
+		refspec = "refs/tags/" + ref.Tag
+	case ref.Branch != "":
+		refspec = "refs/heads/" + ref.Branch
+	}
+	if _, err := r.run(ctx, dir, "fetch", "-q", "--depth", "1", "origin", refspec); err != nil {
+		if ref.Commit == "" {
+			return err
+		}
+		// Not every server allows fetching a commit directly, fall back to fetching
+		// everything
+		if _, err := r.run(ctx, dir, "fetch", "-q", "origin"); err != nil {
+			return err
+		}
+	}
+	if _, err := r.run(ctx, dir, "-c", "advice.detachedHead=false", "checkout", "-q", commit); err != nil {
+		return fmt.Errorf("failed to check out commit %s, the reference may have moved: %w", commit, err)
+	}
+	return os.RemoveAll(filepath.Join(dir, ".git"))
+}
+
+// run runs git with the given arguments in dir and returns its output.
+func (r *Repository) run(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	if strings.HasPrefix(r.URL, "-") {
+		return nil, fmt.Errorf("invalid repository URL '%s'", r.URL)
+	}
+	env, cleanup, err := r.environ()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// environ returns the environment to run git with, and a function removing any
+// files written for it.
+func (r *Repository) environ() ([]string, func(), error) {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_CONFIG_NOSYSTEM=1")
+	if len(r.AllowedProtocols) > 0 {
+		env = append(env, "GIT_ALLOW_PROTOCOL="+strings.Join(r.AllowedProtocols, ":"))
+	}
+	// Configuration is passed through the environment so that credentials do not show
+	// in the arguments of the process
+	config := [][2]string{
+		// Never allow the protocol running arbitrary commands
+		{"protocol.ext.allow", "never"},
+	}
+	cleanup := func() {}
+	withConfig := func() []string {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config)))
+		for i, kv := range config {
+			env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]))
+		}
+		return env
+	}
+
+	auth := r.Auth
+	if auth == nil {
+		return withConfig(), cleanup, nil
+	}
+	if auth.Username != "" || auth.Password != "" {
+		basic := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		config = append(config, [2]string{"http.extraHeader", "Authorization: Basic " + basic})
+	}
+	if len(auth.Identity) > 0 {
+		if len(auth.KnownHosts) == 0 {
+			return nil, nil, errors.New("known_hosts are required with an SSH identity")
+		}
+		dir, err := ioutil.TempDir("", "git-ssh")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+		identity, knownHosts := filepath.Join(dir, "identity"), filepath.Join(dir, "known_hosts")
+		if err := ioutil.WriteFile(identity, auth.Identity, 0600); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if err := ioutil.WriteFile(knownHosts, auth.KnownHosts, 0600); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		env = append(env, fmt.Sprintf(
+			"GIT_SSH_COMMAND=ssh -F /dev/null -i '%s' -o IdentitiesOnly=yes -o UserKnownHostsFile='%s' -o StrictHostKeyChecking=yes",
+			identity, knownHosts,
+		))
+	}
+	return withConfig(), cleanup, nil
+}
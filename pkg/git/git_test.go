@@ -0,0 +1,139 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRepository creates a bare repository with two commits on main, the first of
+// them tagged v1, and returns its URL and commits.
+func testRepository(t *testing.T) (url string, commits []string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	work, bare := filepath.Join(dir, "work"), filepath.Join(dir, "repo.git")
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = work
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, contents string) {
+		path := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q", "-b", "main")
+	write("app/main.jsonnet", "{}")
+	write("other/main.jsonnet", "[]")
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+	git("tag", "-a", "v1", "-m", "v1")
+	commits = append(commits, git("rev-parse", "HEAD"))
+	write("app/main.jsonnet", "{ updated: true }")
+	git("commit", "-q", "-am", "second")
+	commits = append(commits, git("rev-parse", "HEAD"))
+	git("clone", "-q", "--bare", work, bare)
+
+	return "file://" + bare, commits
+}
+
+func TestCheckout(t *testing.T) {
+	url, commits := testRepository(t)
+	repo := &Repository{URL: url}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name       string
+		ref        Reference
+		sparsePath string
+		revision   string
+		contents   string
+	}{
+		{name: "remote HEAD", revision: "main/" + commits[1], contents: "{ updated: true }"},
+		{name: "branch", ref: Reference{Branch: "main"}, revision: "main/" + commits[1], contents: "{ updated: true }"},
+		{name: "annotated tag", ref: Reference{Tag: "v1"}, revision: "v1/" + commits[0], contents: "{}"},
+		{name: "commit", ref: Reference{Commit: commits[0]}, revision: commits[0], contents: "{}"},
+		{name: "sparse path", ref: Reference{Branch: "main"}, sparsePath: "/app/", revision: "main/" + commits[1], contents: "{ updated: true }"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			commit, revision, err := repo.Resolve(ctx, tc.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revision != tc.revision {
+				t.Errorf("expected revision %s, got %s", tc.revision, revision)
+			}
+
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := repo.Checkout(ctx, tc.ref, commit, tc.sparsePath, dir); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := ioutil.ReadFile(filepath.Join(dir, "app", "main.jsonnet")); err != nil || string(data) != tc.contents {
+				t.Errorf("expected %q to be checked out, got %q, %v", tc.contents, data, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".git")); !os.IsNotExist(err) {
+				t.Errorf("expected the repository metadata to be removed, got %v", err)
+			}
+			_, err = os.Stat(filepath.Join(dir, "other"))
+			if sparse := tc.sparsePath != ""; sparse != os.IsNotExist(err) {
+				t.Errorf("expected other paths to be checked out only without a sparse path, got %v", err)
+			}
+		})
+	}
+
+	if _, _, err := repo.Resolve(ctx, Reference{Branch: "missing"}); err == nil {
+		t.Error("expected a missing branch to fail to resolve")
+	}
+	local := &Repository{URL: url, AllowedProtocols: []string{"https", "ssh"}}
+	if _, _, err := local.Resolve(ctx, Reference{}); err == nil {
+		t.Error("expected a disallowed protocol to be rejected")
+	}
+}
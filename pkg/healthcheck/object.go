@@ -0,0 +1,79 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fluxGroupSuffix is the suffix of the API groups of Flux resources.
+const fluxGroupSuffix = ".toolkit.fluxcd.io"
+
+// ObjectReady retrieves the object with the given kind and key, and returns whether
+// it is ready along with a message describing its status. Flux resources are ready
+// when their Ready condition is true for their current generation. All other objects
+// are ready when kstatus considers them current.
+func ObjectReady(ctx context.Context, c client.Reader, gvk schema.GroupVersionKind, key client.ObjectKey) (bool, string, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	if err := c.Get(ctx, key, obj); err != nil {
+		return false, "", err
+	}
+
+	if strings.HasSuffix(gvk.Group, fluxGroupSuffix) {
+		return fluxReady(obj)
+	}
+
+	res, err := status.Compute(obj)
+	if err != nil {
+		return false, "", err
+	}
+	return res.Status == status.CurrentStatus, res.Message, nil
+}
+
+func fluxReady(obj *unstructured.Unstructured) (bool, string, error) {
+	var fluxStatus struct {
+		ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+		Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	}
+	if rawStatus, ok := obj.Object["status"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawStatus, &fluxStatus); err != nil {
+			return false, "", err
+		}
+	}
+
+	if fluxStatus.ObservedGeneration != obj.GetGeneration() {
+		return false, "the latest generation has not been reconciled", nil
+	}
+	cond := apimeta.FindStatusCondition(fluxStatus.Conditions, meta.ReadyCondition)
+	if cond == nil {
+		return false, "no Ready condition", nil
+	}
+	return cond.Status == metav1.ConditionTrue, fmt.Sprintf("%s: %s", cond.Reason, cond.Message), nil
+}
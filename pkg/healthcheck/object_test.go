@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPod(namespace, name string, phase corev1.PodPhase, ready corev1.ConditionStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Status: corev1.PodStatus{
+			Phase:      phase,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+		},
+	}
+}
+
+func newGitRepository(name string, generation, observedGeneration int64, conds ...metav1.Condition) *sourcev1.GitRepository {
+	return &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: name, Generation: generation},
+		Status: sourcev1.GitRepositoryStatus{
+			ObservedGeneration: observedGeneration,
+			Conditions:         conds,
+		},
+	}
+}
+
+func TestObjectReady(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := sourcev1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	ready := metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionTrue, Reason: sourcev1.GitOperationSucceedReason, Message: "fetched"}
+	notReady := metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionFalse, Reason: "GitOperationFailed", Message: "auth failed"}
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(
+		newPod("default", "ready", corev1.PodRunning, corev1.ConditionTrue),
+		newPod("default", "starting", corev1.PodRunning, corev1.ConditionFalse),
+		newPod("other", "ready", corev1.PodRunning, corev1.ConditionTrue),
+		newPod("other", "starting", corev1.PodPending, corev1.ConditionFalse),
+		newGitRepository("ready", 1, 1, ready),
+		newGitRepository("failing", 1, 1, notReady),
+		newGitRepository("stale", 2, 1, ready),
+		newGitRepository("new", 1, 1),
+	).Build()
+
+	pod := corev1.SchemeGroupVersion.WithKind("Pod")
+	repo := sourcev1.GroupVersion.WithKind(sourcev1.GitRepositoryKind)
+	tcs := []struct {
+		name     string
+		gvk      schema.GroupVersionKind
+		key      client.ObjectKey
+		ready    bool
+		message  string
+		notFound bool
+	}{
+		{"ready", pod, client.ObjectKey{Namespace: "default", Name: "ready"}, true, "Pod is Ready", false},
+		{"not ready", pod, client.ObjectKey{Namespace: "default", Name: "starting"}, false, "Pod is running but is not Ready", false},
+		{"not found", pod, client.ObjectKey{Namespace: "default", Name: "missing"}, false, "", true},
+		{"ready in another namespace", pod, client.ObjectKey{Namespace: "other", Name: "ready"}, true, "Pod is Ready", false},
+		{"not ready in another namespace", pod, client.ObjectKey{Namespace: "other", Name: "starting"}, false, "Pod is in the Pending phase", false},
+		{"not found in another namespace", pod, client.ObjectKey{Namespace: "other", Name: "missing"}, false, "", true},
+		{"flux ready", repo, client.ObjectKey{Namespace: "flux-system", Name: "ready"}, true, "GitOperationSucceed: fetched", false},
+		{"flux not ready", repo, client.ObjectKey{Namespace: "flux-system", Name: "failing"}, false, "GitOperationFailed: auth failed", false},
+		{"flux generation not reconciled", repo, client.ObjectKey{Namespace: "flux-system", Name: "stale"}, false, "the latest generation has not been reconciled", false},
+		{"flux without conditions", repo, client.ObjectKey{Namespace: "flux-system", Name: "new"}, false, "no Ready condition", false},
+		{"flux not found", repo, client.ObjectKey{Namespace: "default", Name: "ready"}, false, "", true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ready, msg, err := ObjectReady(context.Background(), c, tc.gvk, tc.key)
+			if tc.notFound {
+				if !apierrors.IsNotFound(err) {
+					t.Fatalf("expected a not found error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready != tc.ready || msg != tc.message {
+				t.Errorf("expected %v %q, got %v %q", tc.ready, tc.message, ready, msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impersonation
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ClientCache holds impersonated clients, along with their REST mappers, across
+// reconciles. Entries are keyed by a hash of the credentials used to build them, so
+// a change to a kubeconfig or service account secret results in a new client. Entries
+// are evicted once they are older than the configured TTL, or when the cache is full,
+// in least-recently-used order.
+//
+// A nil ClientCache is valid and disables caching.
+type ClientCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// sources maps the credential source (e.g. a secret) to the key of the
+	// entry last built from it.
+	sources map[string]string
+}
+
+type cacheEntry struct {
+	key     string
+	source  string
+	client  Client
+	expires time.Time
+}
+
+// NewClientCache returns a new ClientCache. A ttl of zero means entries do not
+// expire, and a maxSize of zero means the number of entries is not bounded.
+func NewClientCache(ttl time.Duration, maxSize int) *ClientCache {
+	return &ClientCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		sources: make(map[string]string),
+	}
+}
+
+// Len returns the number of clients currently in the cache.
+func (c *ClientCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// get returns the client for the given source and credentials, if cached and not expired.
+func (c *ClientCache) get(source string, creds []byte) (Client, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key := cacheKey(source, creds)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.remove(key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.client, true
+}
+
+// add stores the client for the given source and credentials. Any client previously
+// built from the same source is dropped.
+func (c *ClientCache) add(source string, creds []byte, cl Client) {
+	if c == nil {
+		return
+	}
+	key := cacheKey(source, creds)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.sources[source]; ok && old != key {
+		c.remove(old)
+	}
+	c.remove(key)
+	c.sources[source] = key
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		source:  source,
+		client:  cl,
+		expires: time.Now().Add(c.ttl),
+	})
+
+	for c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back().Value.(*cacheEntry).key)
+	}
+}
+
+func (c *ClientCache) remove(key string) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, key)
+	if c.sources[entry.source] == key {
+		delete(c.sources, entry.source)
+	}
+}
+
+func cacheKey(source string, creds []byte) string {
+	h := sha256.New()
+	h.Write([]byte(source))
+	h.Write([]byte{0})
+	h.Write(creds)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impersonation
+
+import (
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	Client
+	name string
+}
+
+func TestClientCacheLRU(t *testing.T) {
+	cache := NewClientCache(0, 2)
+	a, b, c := &testClient{name: "a"}, &testClient{name: "b"}, &testClient{name: "c"}
+
+	cache.add("a", []byte("creds"), a)
+	cache.add("b", []byte("creds"), b)
+	// Use a, so that b is the least recently used
+	if cl, ok := cache.get("a", []byte("creds")); !ok || cl != a {
+		t.Fatalf("expected client a, got %v", cl)
+	}
+	cache.add("c", []byte("creds"), c)
+
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.Len())
+	}
+	if _, ok := cache.get("b", []byte("creds")); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, cl := range []*testClient{a, c} {
+		if got, ok := cache.get(cl.name, []byte("creds")); !ok || got != cl {
+			t.Errorf("expected client %s to be cached, got %v", cl.name, got)
+		}
+	}
+}
+
+func TestClientCacheCredentialChange(t *testing.T) {
+	cache := NewClientCache(0, 0)
+	old, updated := &testClient{name: "old"}, &testClient{name: "new"}
+
+	cache.add("secret", []byte("old"), old)
+	if _, ok := cache.get("secret", []byte("new")); ok {
+		t.Error("expected a miss for changed credentials")
+	}
+	cache.add("secret", []byte("new"), updated)
+
+	if cache.Len() != 1 {
+		t.Errorf("expected the client for the old credentials to be dropped, got %d entries", cache.Len())
+	}
+	if got, ok := cache.get("secret", []byte("new")); !ok || got != updated {
+		t.Errorf("expected the client for the new credentials, got %v", got)
+	}
+}
+
+func TestClientCacheTTL(t *testing.T) {
+	cache := NewClientCache(20*time.Millisecond, 0)
+	cl := &testClient{name: "a"}
+
+	cache.add("a", []byte("creds"), cl)
+	if got, ok := cache.get("a", []byte("creds")); !ok || got != cl {
+		t.Fatalf("expected a cached client, got %v", got)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.get("a", []byte("creds")); ok {
+		t.Error("expected the client to expire")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("expected expired entries to be removed, got %d", cache.Len())
+	}
+}
+
+func TestNilClientCache(t *testing.T) {
+	var cache *ClientCache
+	cache.add("a", []byte("creds"), &testClient{})
+	if _, ok := cache.get("a", []byte("creds")); ok {
+		t.Error("expected a nil cache to never hit")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("expected a nil cache to be empty, got %d", cache.Len())
+	}
+}
@@ -17,8 +17,15 @@ limitations under the License.
 package impersonation
 
 import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 // Client is an extension of the controller-runtime Client with the ability to retrieve
@@ -29,12 +36,62 @@ type Client interface {
 	// StatusPoller returns a polling.StatusPoller using the config from
 	// this client instance.
 	StatusPoller() *polling.StatusPoller
+
+	// Discovery returns a discovery client for the API server of this client
+	// instance, using the same credentials. Discovered resources are cached with
+	// the client and refreshed once older than a minute.
+	Discovery() (discovery.DiscoveryInterface, error)
 }
 
+const (
+	// discoveryTTL is how long discovered resources are reused before being
+	// discovered again.
+	discoveryTTL = time.Minute
+	// discoveryTimeout bounds discovery requests when the config sets no timeout.
+	discoveryTimeout = 30 * time.Second
+)
+
 type clientWithPoller struct {
 	client.Client
+
+	// The config the client was built from, loaded from the environment when nil
+	restConfig *rest.Config
+
+	// The cached discovery client, and when it was last invalidated
+	discoveryMux       sync.Mutex
+	discovery          discovery.CachedDiscoveryInterface
+	discoveryRefreshed time.Time
 }
 
 func (c *clientWithPoller) StatusPoller() *polling.StatusPoller {
 	return polling.NewStatusPoller(c, c.RESTMapper())
 }
+
+func (c *clientWithPoller) Discovery() (discovery.DiscoveryInterface, error) {
+	c.discoveryMux.Lock()
+	defer c.discoveryMux.Unlock()
+	if c.discovery != nil {
+		if time.Since(c.discoveryRefreshed) > discoveryTTL {
+			c.discovery.Invalidate()
+			c.discoveryRefreshed = time.Now()
+		}
+		return c.discovery, nil
+	}
+	restConfig := c.restConfig
+	if restConfig == nil {
+		var err error
+		if restConfig, err = config.GetConfig(); err != nil {
+			return nil, err
+		}
+	}
+	restConfig = rest.CopyConfig(restConfig)
+	if restConfig.Timeout == 0 {
+		restConfig.Timeout = discoveryTimeout
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.discovery, c.discoveryRefreshed = memory.NewMemCacheClient(dc), time.Now()
+	return c.discovery, nil
+}
@@ -15,7 +15,8 @@ limitations under the License.
 
 Copyright 2021 Pelotech - Apache License, Version 2.0.
   - Adaption for Konfigurations from fluxcd/kustomize-controller
-    - Caches kubeconfigs and serviceaccount tokens for subsequent calls
+    - Caches kubeconfigs and clients for subsequent calls
+    - Service accounts assumed via the TokenRequest API or impersonation
 	- Standalone package operating on interfaces
 */
 
@@ -26,14 +27,14 @@ package impersonation
 import (
 	"context"
 	"fmt"
-	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 // Impersonator is an interface to be implemented by CRs that need to assume the credentials
@@ -55,16 +56,46 @@ type Impersonator interface {
 type Impersonation interface {
 	// GetClient creates a controller-runtime client for talking to a Kubernetes API server.
 	// If KubeConfig is set, will use the kubeconfig bytes from the Kubernetes secret.
-	// If ServiceAccountName is set, will use the cluster provided kubeconfig impersonating the SA.
+	// If ServiceAccountName is set, will use the configured ServiceAccountMode to assume the SA.
 	// Otherwise will assume running in cluster and use the cluster provided kubeconfig.
 	GetClient(ctx context.Context) (Client, error)
+	// Impersonating returns true if the clients returned by GetClient assume an
+	// identity other than the controller's own.
+	Impersonating() bool
 }
 
-// NewImpersonation creates a new Impersonation using the given CR and client.
-func NewImpersonation(imp Impersonator, kubeClient client.Client) Impersonation {
+// Options are the options for building impersonated clients.
+type Options struct {
+	// RESTConfig is the configuration the controller itself uses to talk to the
+	// API server. Service account clients are derived from it. When nil, the
+	// configuration is loaded from the environment.
+	RESTConfig *rest.Config
+	// ServiceAccountMode is how service accounts are impersonated. Defaults to
+	// ServiceAccountModeTokenRequest.
+	ServiceAccountMode ServiceAccountMode
+	// TokenExpiration is the lifetime requested for service account tokens.
+	// Defaults to one hour.
+	TokenExpiration time.Duration
+	// DefaultServiceAccount is the service account to assume for CRs that configure
+	// neither a kubeconfig nor a service account. When empty, such CRs use the
+	// controller's own client.
+	DefaultServiceAccount string
+	// Cache holds clients across impersonations. When nil, a new client is built
+	// every time one is requested.
+	Cache *ClientCache
+}
+
+// NewImpersonation creates a new Impersonation using the given CR and client. The options
+// may be nil.
+func NewImpersonation(imp Impersonator, kubeClient client.Client, opts *Options) Impersonation {
+	if opts == nil {
+		opts = &Options{}
+	}
 	return &impersonation{
 		imp:    imp,
 		Client: kubeClient,
+		opts:   opts,
+		cache:  opts.Cache,
 	}
 }
 
@@ -73,50 +104,46 @@ type impersonation struct {
 
 	// The CR backing this impersonation
 	imp Impersonator
+	// Options for building clients
+	opts *Options
+	// Clients shared across impersonations
+	cache *ClientCache
 
 	// cached assets
-	serviceAccountToken string
-	kubeconfigContents  []byte
+	kubeconfigContents []byte
 }
 
 // GetClient creates a controller-runtime client for talking to a Kubernetes API server.
 // If KubeConfig is set, will use the kubeconfig bytes from the Kubernetes secret.
-// If ServiceAccountName is set, will use the cluster provided kubeconfig impersonating the SA.
+// If ServiceAccountName is set, will use the configured ServiceAccountMode to assume the SA.
+// If a DefaultServiceAccount is configured, it is assumed the same way.
 // Otherwise will assume running in cluster and use the cluster provided kubeconfig.
 func (ki *impersonation) GetClient(ctx context.Context) (Client, error) {
 	if kubeconfig := ki.imp.GetKubeConfigSecretName(); kubeconfig != "" {
 		return ki.clientForKubeConfig(ctx)
 	}
 	if svcAccount := ki.imp.GetServiceAccountName(); svcAccount != "" {
-		return ki.clientForServiceAccount(ctx)
+		return ki.clientForServiceAccount(ctx, svcAccount)
 	}
-	return &clientWithPoller{ki.Client}, nil
-}
-
-func (ki *impersonation) clientForServiceAccount(ctx context.Context) (Client, error) {
-	token, err := ki.getServiceAccountToken(ctx)
-	if err != nil {
-		return nil, err
+	if svcAccount := ki.opts.DefaultServiceAccount; svcAccount != "" {
+		return ki.clientForServiceAccount(ctx, svcAccount)
 	}
-	restConfig, err := config.GetConfig()
-	if err != nil {
-		return nil, err
-	}
-	restConfig.BearerToken = token
-	restConfig.BearerTokenFile = "" // Clear, as it overrides BearerToken
-
-	restMapper, err := apiutil.NewDynamicRESTMapper(restConfig)
-	if err != nil {
-		return nil, err
-	}
-
-	client, err := client.New(restConfig, client.Options{Mapper: restMapper})
-	if err != nil {
-		return nil, err
+	// The controller's own client is cached too, to share its discovery cache
+	if cached, ok := ki.cache.get(controllerSource, nil); ok {
+		return cached, nil
 	}
+	cl := &clientWithPoller{Client: ki.Client, restConfig: ki.opts.RESTConfig}
+	ki.cache.add(controllerSource, nil, cl)
+	return cl, nil
+}
 
-	return &clientWithPoller{client}, nil
+// controllerSource is the cache source of the controller's own client.
+const controllerSource = "controller"
 
+func (ki *impersonation) Impersonating() bool {
+	return ki.imp.GetKubeConfigSecretName() != "" ||
+		ki.imp.GetServiceAccountName() != "" ||
+		ki.opts.DefaultServiceAccount != ""
 }
 
 func (ki *impersonation) clientForKubeConfig(ctx context.Context) (Client, error) {
@@ -125,11 +152,21 @@ func (ki *impersonation) clientForKubeConfig(ctx context.Context) (Client, error
 		return nil, err
 	}
 
+	source := fmt.Sprintf("kubeconfig/%s/%s", ki.imp.GetNamespace(), ki.imp.GetKubeConfigSecretName())
+	if cached, ok := ki.cache.get(source, kubeConfigBytes); ok {
+		return cached, nil
+	}
+
 	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeConfigBytes)
 	if err != nil {
 		return nil, err
 	}
 
+	return ki.newClient(source, kubeConfigBytes, restConfig)
+}
+
+// newClient builds a client for the given config and adds it to the cache.
+func (ki *impersonation) newClient(source string, creds []byte, restConfig *rest.Config) (Client, error) {
 	restMapper, err := apiutil.NewDynamicRESTMapper(restConfig)
 	if err != nil {
 		return nil, err
@@ -140,7 +177,9 @@ func (ki *impersonation) clientForKubeConfig(ctx context.Context) (Client, error
 		return nil, err
 	}
 
-	return &clientWithPoller{client}, nil
+	cl := &clientWithPoller{Client: client, restConfig: restConfig}
+	ki.cache.add(source, creds, cl)
+	return cl, nil
 }
 
 func (ki *impersonation) getKubeConfig(ctx context.Context) ([]byte, error) {
@@ -165,51 +204,3 @@ func (ki *impersonation) getKubeConfig(ctx context.Context) ([]byte, error) {
 	ki.kubeconfigContents = kubeConfig
 	return kubeConfig, nil
 }
-
-func (ki *impersonation) getServiceAccountToken(ctx context.Context) (string, error) {
-	// Return an already retrieved serviceAccountToken
-	if ki.serviceAccountToken != "" {
-		return ki.serviceAccountToken, nil
-	}
-
-	namespacedName := types.NamespacedName{
-		Namespace: ki.imp.GetNamespace(),
-		Name:      ki.imp.GetServiceAccountName(),
-	}
-
-	var serviceAccount corev1.ServiceAccount
-	err := ki.Client.Get(ctx, namespacedName, &serviceAccount)
-	if err != nil {
-		return "", err
-	}
-
-	secretName := types.NamespacedName{
-		Namespace: ki.imp.GetNamespace(),
-		Name:      ki.imp.GetServiceAccountName(),
-	}
-
-	for _, secret := range serviceAccount.Secrets {
-		if strings.HasPrefix(secret.Name, fmt.Sprintf("%s-token", serviceAccount.Name)) {
-			secretName.Name = secret.Name
-			break
-		}
-	}
-
-	var secret corev1.Secret
-	err = ki.Client.Get(ctx, secretName, &secret)
-	if err != nil {
-		return "", err
-	}
-
-	var token string
-	if data, ok := secret.Data["token"]; ok {
-		token = string(data)
-	} else {
-		return "", fmt.Errorf("the service account secret '%s' does not containt a token", secretName.String())
-	}
-
-	// Reuse token for the life of this impersonation to avoid continued lookups
-	ki.serviceAccountToken = token
-
-	return ki.serviceAccountToken, nil
-}
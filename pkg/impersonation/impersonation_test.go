@@ -0,0 +1,187 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impersonation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+type testImpersonator struct {
+	*corev1.ConfigMap
+	serviceAccount string
+}
+
+func (t *testImpersonator) GetKubeConfigSecretName() string { return "" }
+func (t *testImpersonator) GetServiceAccountName() string   { return t.serviceAccount }
+
+func TestServiceAccountModes(t *testing.T) {
+	testEnv := &envtest.Environment{}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		t.Skipf("envtest control plane is not available: %s", err)
+	}
+	defer func() {
+		if err := testEnv.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx := context.Background()
+	adminClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A service account that may only read objects in the default namespace
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "viewer", Namespace: "default"}}
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "viewer", Namespace: "default"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "viewer", Namespace: "default"}},
+	}
+	// The controller runs without cluster-admin, with only the rules the manager role
+	// grants for assuming service accounts
+	controllerSA := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "controller", Namespace: "default"}}
+	controllerRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "controller"},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, Verbs: []string{"get", "impersonate"}},
+			{APIGroups: []string{""}, Resources: []string{"serviceaccounts/token"}, Verbs: []string{"create"}},
+		},
+	}
+	controllerBinding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "controller"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "controller"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "controller", Namespace: "default"}},
+	}
+	for _, obj := range []client.Object{sa, binding, controllerSA, controllerRole, controllerBinding} {
+		if err := adminClient.Create(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := clientset.CoreV1().ServiceAccounts("default").CreateToken(ctx, "controller", &authenticationv1.TokenRequest{}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controllerConfig := rest.AnonymousClientConfig(cfg)
+	controllerConfig.BearerToken = token.Status.Token
+	kubeClient, err := client.New(controllerConfig, client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imp := &testImpersonator{
+		ConfigMap:      &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}},
+		serviceAccount: "viewer",
+	}
+
+	for _, mode := range []ServiceAccountMode{ServiceAccountModeTokenRequest, ServiceAccountModeImpersonate} {
+		t.Run(string(mode), func(t *testing.T) {
+			opts := &Options{
+				RESTConfig:         controllerConfig,
+				ServiceAccountMode: mode,
+				TokenExpiration:    10 * time.Minute,
+				Cache:              NewClientCache(time.Minute, 10),
+			}
+
+			cl, err := NewImpersonation(imp, kubeClient, opts).GetClient(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := cl.List(ctx, &corev1.ConfigMapList{}, client.InNamespace("default")); err != nil {
+				t.Errorf("Expected the service account to list configmaps, got: %s", err)
+			}
+			err = cl.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "denied", Namespace: "default"}})
+			if !apierrors.IsForbidden(err) {
+				t.Errorf("Expected the service account to be forbidden from creating configmaps, got: %v", err)
+			}
+
+			cached, err := NewImpersonation(imp, kubeClient, opts).GetClient(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cached != cl {
+				t.Error("Expected the client to be reused from the cache")
+			}
+		})
+	}
+}
+
+func TestControllerClientDiscovery(t *testing.T) {
+	ctx := context.Background()
+	imp := &testImpersonator{
+		ConfigMap: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}},
+	}
+	opts := &Options{
+		RESTConfig: &rest.Config{Host: "https://127.0.0.1:6443"},
+		Cache:      NewClientCache(time.Minute, 10),
+	}
+
+	cl, err := NewImpersonation(imp, fake.NewClientBuilder().Build(), opts).GetClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := NewImpersonation(imp, fake.NewClientBuilder().Build(), opts).GetClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != cl {
+		t.Fatal("Expected the controller's client to be reused from the cache")
+	}
+
+	dc, err := cl.Discovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := cached.Discovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != dc {
+		t.Error("Expected the discovery client to be reused")
+	}
+
+	// Stale discovery caches are invalidated rather than rebuilt
+	cp := cl.(*clientWithPoller)
+	stale := time.Now().Add(-2 * discoveryTTL)
+	cp.discoveryRefreshed = stale
+	if again, err = cl.Discovery(); err != nil || again != dc {
+		t.Errorf("Expected the discovery client to be reused, got %v, %v", again, err)
+	}
+	if !cp.discoveryRefreshed.After(stale) {
+		t.Error("Expected the stale discovery cache to be invalidated")
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impersonation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// ServiceAccountMode determines how the controller assumes the identity of a
+// service account.
+type ServiceAccountMode string
+
+const (
+	// ServiceAccountModeTokenRequest mints short-lived tokens for the service account
+	// using the TokenRequest API.
+	ServiceAccountModeTokenRequest ServiceAccountMode = "token-request"
+	// ServiceAccountModeImpersonate uses the controller's own credentials along with
+	// impersonation headers for the service account.
+	ServiceAccountModeImpersonate ServiceAccountMode = "impersonate"
+)
+
+const (
+	// DefaultTokenExpiration is the lifetime requested for service account tokens
+	// when none is configured.
+	DefaultTokenExpiration = time.Hour
+	// tokenRefreshRatio is the portion of a token's lifetime after which it is
+	// replaced with a new one.
+	tokenRefreshRatio = 0.8
+	// tokenRequestTimeout is the timeout for a single TokenRequest.
+	tokenRequestTimeout = 30 * time.Second
+)
+
+// ServiceAccountUsername returns the username the API server authenticates the given
+// service account as.
+func ServiceAccountUsername(namespace, name string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+}
+
+func (ki *impersonation) clientForServiceAccount(ctx context.Context, name string) (Client, error) {
+	namespacedName := types.NamespacedName{
+		Namespace: ki.imp.GetNamespace(),
+		Name:      name,
+	}
+
+	var serviceAccount corev1.ServiceAccount
+	if err := ki.Client.Get(ctx, namespacedName, &serviceAccount); err != nil {
+		return nil, fmt.Errorf("unable to read service account '%s' error: %w", namespacedName.String(), err)
+	}
+
+	mode := ki.opts.ServiceAccountMode
+	if mode == "" {
+		mode = ServiceAccountModeTokenRequest
+	}
+
+	// Tokens are refreshed by the client itself, so a client only needs to be rebuilt
+	// when the mode changes or the service account is recreated.
+	source := fmt.Sprintf("serviceaccount/%s", namespacedName.String())
+	creds := []byte(fmt.Sprintf("%s/%s", mode, serviceAccount.GetUID()))
+	if cached, ok := ki.cache.get(source, creds); ok {
+		return cached, nil
+	}
+
+	baseConfig := ki.opts.RESTConfig
+	if baseConfig == nil {
+		var err error
+		if baseConfig, err = config.GetConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	var restConfig *rest.Config
+	switch mode {
+	case ServiceAccountModeImpersonate:
+		// Only the user is impersonated, impersonating groups requires the impersonate
+		// verb on groups. The API server adds the service account groups itself.
+		restConfig = rest.CopyConfig(baseConfig)
+		restConfig.Impersonate = rest.ImpersonationConfig{
+			UserName: ServiceAccountUsername(namespacedName.Namespace, namespacedName.Name),
+		}
+	case ServiceAccountModeTokenRequest:
+		tokenSource, err := newTokenRequestSource(baseConfig, namespacedName, ki.opts.TokenExpiration)
+		if err != nil {
+			return nil, err
+		}
+		// Strip the controller's own credentials and authenticate with the minted tokens
+		restConfig = rest.AnonymousClientConfig(baseConfig)
+		restConfig.WrapTransport = transport.ResettableTokenSourceWrapTransport(transport.NewCachedTokenSource(tokenSource))
+	default:
+		return nil, fmt.Errorf("unknown service account mode '%s'", mode)
+	}
+
+	return ki.newClient(source, creds, restConfig)
+}
+
+// tokenRequestSource is an oauth2.TokenSource minting service account tokens with the
+// TokenRequest API. Tokens are reported as expiring once most of their lifetime has
+// passed, so that callers caching them renew them ahead of time.
+type tokenRequestSource struct {
+	client         kubernetes.Interface
+	serviceAccount types.NamespacedName
+	expiration     time.Duration
+}
+
+func newTokenRequestSource(restConfig *rest.Config, serviceAccount types.NamespacedName, expiration time.Duration) (*tokenRequestSource, error) {
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	if expiration <= 0 {
+		expiration = DefaultTokenExpiration
+	}
+	return &tokenRequestSource{
+		client:         clientset,
+		serviceAccount: serviceAccount,
+		expiration:     expiration,
+	}, nil
+}
+
+// Token implements oauth2.TokenSource.
+func (ts *tokenRequestSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenRequestTimeout)
+	defer cancel()
+
+	expirationSeconds := int64(ts.expiration.Seconds())
+	issued := time.Now()
+	req, err := ts.client.CoreV1().ServiceAccounts(ts.serviceAccount.Namespace).CreateToken(ctx, ts.serviceAccount.Name,
+		&authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request a token for service account '%s': %w", ts.serviceAccount.String(), err)
+	}
+
+	// The server may issue a token with a different lifetime than requested
+	lifetime := req.Status.ExpirationTimestamp.Time.Sub(issued)
+	return &oauth2.Token{
+		AccessToken: req.Status.Token,
+		TokenType:   "Bearer",
+		Expiry:      issued.Add(time.Duration(float64(lifetime) * tokenRefreshRatio)),
+	}, nil
+}
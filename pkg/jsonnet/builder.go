@@ -17,12 +17,15 @@ limitations under the License.
 package jsonnet
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
@@ -31,6 +34,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
@@ -49,13 +53,76 @@ type Builder interface {
 	Evaluate(path string) (string, error)
 }
 
+// BuilderOption is a function that configures a builder.
+type BuilderOption func(*builder)
+
+// WithoutRemoteImports disables importing jsonnet over HTTP(S).
+func WithoutRemoteImports() BuilderOption {
+	return func(b *builder) { b.allowRemote = false }
+}
+
+// WithExtCode adds an external variable with a code value. Variables configured on
+// the konfiguration take precedence.
+func WithExtCode(key, code string) BuilderOption {
+	return func(b *builder) { b.vm.ExtCode(key, code) }
+}
+
+// WithRemoteFiles fetches HTTP(S) imports with the given RemoteFiles, which caches
+// them across builds. Without it, they are fetched again on every build.
+func WithRemoteFiles(f *RemoteFiles) BuilderOption {
+	return func(b *builder) { b.remote = f }
+}
+
+// WithImportLock refuses HTTP(S) imports that are not pinned by the given lock, or
+// whose contents do not match their pinned digest.
+func WithImportLock(lock *ImportLock) BuilderOption {
+	return func(b *builder) { b.lock = lock }
+}
+
+// VendorFunc returns the vendor directories to search for the imports of the
+// entrypoint in dir, and a function to release them once the build is done.
+type VendorFunc func(ctx context.Context, dir string) (paths []string, release func(), err error)
+
+// WithVendor adds the vendor directories returned by the given function for the
+// directory of the built path to the search paths, after those configured on the
+// konfiguration.
+func WithVendor(f VendorFunc) BuilderOption {
+	return func(b *builder) { b.vendor = f }
+}
+
+// WithImportScheme imports the URLs of the given scheme with the functions returned
+// by f. Imports of remote schemes are refused with WithoutRemoteImports.
+func WithImportScheme(scheme string, remote bool, f SchemeFunc) BuilderOption {
+	return func(b *builder) {
+		if b.schemes == nil {
+			b.schemes = make(map[string]importScheme)
+		}
+		b.schemes[scheme] = importScheme{remote: remote, new: f}
+	}
+}
+
+// WithLookup reads the objects requested by the lookup native function with the
+// given reader. Without it, lookups return an empty object.
+func WithLookup(c client.Reader) BuilderOption {
+	return func(b *builder) { b.lookupReader = c }
+}
+
+// WithCapabilities exposes the capabilities of the cluster returned by the given
+// function to the capabilities native function and Helm charts. Without it, the
+// defaults of Helm are used.
+func WithCapabilities(f CapabilitiesFunc) BuilderOption {
+	return func(b *builder) { b.capabilities = f }
+}
+
 // NewBuilder constructs a jsonnet builder according to the konfiguration.
-// Assets fetched over HTTP will be cached to the cacheDir.
-func NewBuilder(konfig *konfigurationv1.Konfiguration, workdir, cacheDir string) (Builder, error) {
-	b := &builder{vm: jsonnet.MakeVM(), cacheDir: cacheDir, konfig: konfig}
+func NewBuilder(konfig *konfigurationv1.Konfiguration, workdir string, opts ...BuilderOption) (Builder, error) {
+	b := &builder{vm: jsonnet.MakeVM(), konfig: konfig, allowRemote: true}
+	for _, opt := range opts {
+		opt(b)
+	}
 
 	// Register native functions
-	registerNativeFuncs(b.vm)
+	registerNativeFuncs(b.vm, b.recordUntracked, b.lookup, b.clusterCapabilities)
 
 	// Special URL scheme for embedded content
 	searchURLs := []*url.URL{
@@ -102,6 +169,17 @@ func NewBuilder(konfig *konfigurationv1.Konfiguration, workdir, cacheDir string)
 			if err := vars.InjectIntoVM(b.vm); err != nil {
 				return nil, err
 			}
+			// Top-level arguments are also passed as external variables, so that
+			// Build can call a top-level function before looking for outputs
+			tlas, err := vars.TLACodes()
+			if err != nil {
+				return nil, err
+			}
+			for name, code := range tlas {
+				b.vm.ExtCode(tlaExtVarPrefix+name, code)
+				b.tlas = append(b.tlas, name)
+			}
+			sort.Strings(b.tlas)
 		}
 
 	}
@@ -113,33 +191,103 @@ func NewBuilder(konfig *konfigurationv1.Konfiguration, workdir, cacheDir string)
 
 // builder implements the builder interface.
 type builder struct {
-	konfig     *konfigurationv1.Konfiguration
-	searchURLs []*url.URL
-	cacheDir   string
-	vm         *jsonnet.VM
+	konfig      *konfigurationv1.Konfiguration
+	searchURLs  []*url.URL
+	allowRemote bool
+	remote      *RemoteFiles
+	lock        *ImportLock
+	vendor      VendorFunc
+	schemes     map[string]importScheme
+	vm          *jsonnet.VM
+	// names of the top-level arguments
+	tlas []string
+	// reader for the lookup native function
+	lookupReader client.Reader
+	// capabilities of the cluster, and those discovered for the current evaluation
+	capabilities CapabilitiesFunc
+	caps         *Capabilities
+	// context of the current evaluation, for native functions
+	ctx context.Context
+	// checksums of the imports of the last evaluation, keyed by URL
+	imports map[string]string
+	// candidates that did not exist while resolving the imports of the last evaluation
+	misses map[string]bool
+	// objects read by lookups during the last evaluation
+	lookups map[string]Lookup
 }
 
+// tlaExtVarPrefix prefixes the external variables holding top-level arguments.
+const tlaExtVarPrefix = "__tla__"
+
+// outputsSnippet wraps an expression so the reserved outputs field, visible or hidden,
+// is evaluated separately from the objects. A top-level function is called with the
+// top-level arguments first, as the VM only applies them to the wrapping expression.
+const outputsSnippet = `local entrypoint = %s;
+local root = if std.isFunction(entrypoint) then entrypoint(%s) else entrypoint;
+local hasOutputs = std.isObject(root) && std.objectHasAll(root, '%s');
+{
+  objects: if hasOutputs then { [k]: root[k] for k in std.objectFields(root) if k != '%s' } else root,
+  outputs: if hasOutputs then root['%s'] else null,
+}`
+
 func (b *builder) Build(ctx context.Context, restMapper meta.RESTMapper, path string) (*BuildOutput, error) {
+	expr, err := b.expression(path)
+	if err != nil {
+		return nil, err
+	}
+
 	// Evaluate the jsonnet
-	evaluated, err := b.evaluateJsonnet(ctx, path)
+	field := konfigurationv1.OutputsField
+	args := make([]string, len(b.tlas))
+	for i, name := range b.tlas {
+		args[i] = fmt.Sprintf("%s=std.extVar('%s%s')", name, tlaExtVarPrefix, name)
+	}
+	evaluated, err := b.evaluate(ctx, path, fmt.Sprintf(outputsSnippet, expr, strings.Join(args, ", "), field, field, field))
 	if err != nil {
 		return nil, err
 	}
 
 	// Unmarshal the output
-	var root interface{}
+	var root struct {
+		Objects interface{}     `json:"objects"`
+		Outputs json.RawMessage `json:"outputs"`
+	}
 	if err := json.Unmarshal([]byte(evaluated), &root); err != nil {
 		return nil, err
 	}
 
+	output := newBuildOutput()
+	output.imports = b.imports
+	output.misses = make([]string, 0, len(b.misses))
+	for u := range b.misses {
+		output.misses = append(output.misses, u)
+	}
+	sort.Strings(output.misses)
+	output.lookups = make([]Lookup, 0, len(b.lookups))
+	for _, l := range b.lookups {
+		output.lookups = append(output.lookups, l)
+	}
+	sort.Slice(output.lookups, func(i, j int) bool { return output.lookups[i].key() < output.lookups[j].key() })
+
+	// Outputs must be an object so dependants can address them by key
+	if len(root.Outputs) > 0 && string(root.Outputs) != "null" {
+		var outputs map[string]interface{}
+		if err := json.Unmarshal(root.Outputs, &outputs); err != nil {
+			return nil, fmt.Errorf("the %s field must be an object: %w", field, err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, root.Outputs); err != nil {
+			return nil, err
+		}
+		output.outputs = compact.Bytes()
+	}
+
 	// Walk the output for kubernetes objects
-	objs, err := jsonWalk(&walkContext{label: "<top>"}, root)
+	objs, err := jsonWalk(&walkContext{label: "<top>"}, root.Objects)
 	if err != nil {
 		return nil, err
 	}
 
-	output := newBuildOutput()
-
 	// Build the output, taking care to ensure namespaces are properly set on
 	// namespaced objects.
 	for _, v := range objs {
@@ -167,14 +315,16 @@ func (b *builder) Build(ctx context.Context, restMapper meta.RESTMapper, path st
 }
 
 func (b *builder) Evaluate(path string) (string, error) {
-	return b.evaluateJsonnet(context.Background(), path)
+	expr, err := b.expression(path)
+	if err != nil {
+		return "", err
+	}
+	return b.evaluate(context.Background(), path, expr)
 }
 
-func (b *builder) evaluateJsonnet(ctx context.Context, path string) (string, error) {
-	// Configure the importer
-	log := log.FromContext(ctx)
-	b.vm.Importer(MakeUniversalImporter(log, b.searchURLs, b.cacheDir))
-
+// expression returns the jsonnet expression that imports the given path, with any
+// user-defined injections.
+func (b *builder) expression(path string) (string, error) {
 	u, err := url.Parse(path)
 	if err != nil {
 		return "", err
@@ -211,6 +361,36 @@ func (b *builder) evaluateJsonnet(ctx context.Context, path string) (string, err
 		expr += b.konfig.GetInjectSnippet()
 	}
 
+	return expr, nil
+}
+
+// evaluate configures the importer for the given path and evaluates the given
+// expression.
+func (b *builder) evaluate(ctx context.Context, path, expr string) (string, error) {
+	log := log.FromContext(ctx)
+	searchURLs, release, err := b.vendorSearchURLs(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to vendor dependencies: %w", err)
+	}
+	defer release()
+	importer := newUniversalImporter(log, searchURLs, b.allowRemote)
+	b.imports = make(map[string]string)
+	b.misses = make(map[string]bool)
+	b.lookups = make(map[string]Lookup)
+	b.caps = nil
+	b.ctx = ctx
+	importer.onImport = b.recordImport
+	importer.onMiss = b.recordMiss
+	importer.remote = b.remote
+	importer.lock = b.lock
+	importer.ctx = ctx
+	for scheme, s := range b.schemes {
+		f, release := s.new()
+		defer release()
+		importer.addScheme(scheme, s.remote, f)
+	}
+	b.vm.Importer(importer)
+
 	output, err := b.vm.EvaluateAnonymousSnippet("", expr)
 	if err != nil {
 		return "", errors.New(strings.TrimSpace(err.Error()))
@@ -218,6 +398,28 @@ func (b *builder) evaluateJsonnet(ctx context.Context, path string) (string, err
 	return output, nil
 }
 
+// vendorSearchURLs returns the search URLs for building the given path, with the
+// vendor directories of a local path appended.
+func (b *builder) vendorSearchURLs(ctx context.Context, path string) ([]*url.URL, func(), error) {
+	u, err := url.Parse(path)
+	if b.vendor == nil || err != nil || (u.Scheme != "" && u.Scheme != "file") {
+		return b.searchURLs, func() {}, nil
+	}
+	abs, err := filepath.Abs(u.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths, release, err := b.vendor(ctx, filepath.Dir(abs))
+	if err != nil {
+		return nil, nil, err
+	}
+	searchURLs := append([]*url.URL{}, b.searchURLs...)
+	for _, p := range paths {
+		searchURLs = append(searchURLs, &url.URL{Scheme: "file", Path: filepath.ToSlash(p) + "/"})
+	}
+	return searchURLs, release, nil
+}
+
 func (b *builder) checkNamespace(restMapper meta.RESTMapper, obj *unstructured.Unstructured) error {
 	if restMapper == nil {
 		return nil
@@ -239,3 +441,54 @@ func (b *builder) checkNamespace(restMapper meta.RESTMapper, obj *unstructured.U
 	}
 	return nil
 }
+
+// recordImport records the checksum of an imported file.
+func (b *builder) recordImport(foundAt string, data []byte) {
+	b.imports[foundAt] = fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// recordMiss records a candidate path that did not exist when resolving an import.
+// Creating it would change what the import resolves to.
+func (b *builder) recordMiss(triedAt string) {
+	b.misses[triedAt] = true
+}
+
+// lookup reads the objects of a lookup and records it.
+func (b *builder) lookup(l *Lookup) (interface{}, error) {
+	obj, err := lookupObject(b.ctx, b.lookupReader, l)
+	if err != nil {
+		return nil, err
+	}
+	if b.lookupReader != nil {
+		b.lookups[l.key()] = *l
+	}
+	return obj, nil
+}
+
+// clusterCapabilities returns the capabilities of the cluster, discovering them on
+// first use in an evaluation.
+func (b *builder) clusterCapabilities() (*Capabilities, error) {
+	if b.caps != nil {
+		return b.caps, nil
+	}
+	if b.capabilities == nil {
+		b.caps = DefaultCapabilities()
+		return b.caps, nil
+	}
+	caps, err := b.capabilities(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	b.caps = caps
+	return caps, nil
+}
+
+// recordUntracked records a path read outside of the importer. Its contents are
+// not tracked, so it is recorded without a checksum.
+func (b *builder) recordUntracked(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	b.imports[u.String()] = ""
+}
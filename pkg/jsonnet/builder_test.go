@@ -0,0 +1,172 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+const testConfigMap = `{ apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: 'test' } }`
+
+func TestBuildOutputs(t *testing.T) {
+	tcs := []struct {
+		name    string
+		code    string
+		tlas    map[string]string
+		objects int
+		outputs string
+		wantErr bool
+	}{
+		{
+			name:    "no outputs",
+			code:    `{ cm: ` + testConfigMap + ` }`,
+			objects: 1,
+		},
+		{
+			name:    "hidden outputs",
+			code:    `{ cm: ` + testConfigMap + `, __outputs__:: { name: $.cm.metadata.name } }`,
+			objects: 1,
+			outputs: `{"name":"test"}`,
+		},
+		{
+			name:    "visible outputs",
+			code:    `{ cm: ` + testConfigMap + `, __outputs__: { ext: std.extVar('ext') } }`,
+			objects: 1,
+			outputs: `{"ext":1}`,
+		},
+		{
+			name:    "top-level array",
+			code:    `[` + testConfigMap + `]`,
+			objects: 1,
+		},
+		{
+			name:    "outputs not an object",
+			code:    `{ __outputs__:: 'test' }`,
+			wantErr: true,
+		},
+		{
+			name:    "field named outputs",
+			code:    `{ cm: ` + testConfigMap + `, outputs: ` + testConfigMap + ` }`,
+			objects: 2,
+		},
+		{
+			name:    "top-level function",
+			code:    `function(name, replicas=1) { cm: ` + testConfigMap + ` + { metadata+: { name: name } }, __outputs__:: { name: name, replicas: replicas } }`,
+			tlas:    map[string]string{"name": "test"},
+			objects: 1,
+			outputs: `{"name":"test","replicas":1}`,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+".jsonnet")
+			if err := ioutil.WriteFile(path, []byte(tc.code), 0644); err != nil {
+				t.Fatal(err)
+			}
+			konfig := &konfigurationv1.Konfiguration{}
+			if tc.tlas != nil {
+				konfig.Spec.Variables = &konfigurationv1.Variables{TLAStr: tc.tlas}
+			}
+			builder, err := NewBuilder(konfig, dir, WithExtCode("ext", "1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := builder.Build(context.Background(), nil, path)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(out.SortedObjects()); got != tc.objects {
+				t.Errorf("expected %d objects, got %d", tc.objects, got)
+			}
+			if got := string(out.Outputs()); got != tc.outputs {
+				t.Errorf("expected outputs %q, got %q", tc.outputs, got)
+			}
+			if _, ok := out.Imports()["file://"+path]; !ok {
+				t.Errorf("expected %s to be recorded as an import, got %v", path, out.Imports())
+			}
+		})
+	}
+}
+
+func TestBuildMisses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"main.jsonnet":       `(import 'util.libsonnet') + (import 'local.libsonnet')`,
+		"local.libsonnet":    `{}`,
+		"lib/util.libsonnet": `{ cm: ` + testConfigMap + ` }`,
+	}
+	for name, code := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	konfig := &konfigurationv1.Konfiguration{}
+	konfig.Spec.JsonnetPaths = []string{"lib"}
+	builder, err := NewBuilder(konfig, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := builder.Build(context.Background(), nil, filepath.Join(dir, "main.jsonnet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// util.libsonnet was looked for next to main.jsonnet, and in the embedded library,
+	// before it was found in lib
+	var fileMisses []string
+	for _, u := range out.Misses() {
+		if strings.HasPrefix(u, "file://") {
+			fileMisses = append(fileMisses, u)
+		}
+	}
+	expected := []string{"file://" + filepath.ToSlash(filepath.Join(dir, "util.libsonnet"))}
+	if !reflect.DeepEqual(fileMisses, expected) {
+		t.Errorf("expected misses %v, got %v", expected, out.Misses())
+	}
+	if _, ok := out.Imports()["file://"+filepath.ToSlash(filepath.Join(dir, "lib", "util.libsonnet"))]; !ok {
+		t.Errorf("expected lib/util.libsonnet to be recorded as an import, got %v", out.Imports())
+	}
+}
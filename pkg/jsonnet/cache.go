@@ -21,8 +21,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -32,20 +30,18 @@ import (
 
 var errNotFound = errors.New("not found")
 
-// cache implements a dumb local cache for files fetched remotely.
-type httpCache struct {
-	// The location of the cache directory
-	cacheDir string
+// httpFetcher fetches imports over the protocols registered with its transport. Files
+// fetched over HTTP(S) are only cached when the builder is configured with RemoteFiles.
+type httpFetcher struct {
 	// The http client used for requests
 	httpClient *http.Client
-	// The logger for the cache
+	// The logger for the fetcher
 	log logr.Logger
 }
 
-// NewHTTPCache creates a new http cache using the given transport and cache directory.
-func NewHTTPCache(log logr.Logger, t *http.Transport, cacheDir string) *httpCache {
-	return &httpCache{
-		cacheDir: cacheDir,
+// NewHTTPFetcher creates a new http fetcher using the given transport.
+func NewHTTPFetcher(log logr.Logger, t *http.Transport) *httpFetcher {
+	return &httpFetcher{
 		httpClient: &http.Client{
 			Transport: t,
 		},
@@ -56,55 +52,10 @@ func NewHTTPCache(log logr.Logger, t *http.Transport, cacheDir string) *httpCach
 var httpRegex = regexp.MustCompile("^(https?)://")
 var internalRegex = regexp.MustCompile("^internal:///?(.*)$")
 
-func (h *httpCache) getLocalPath(url string) string {
-	return filepath.Join(h.cacheDir, httpRegex.ReplaceAllString(url, ""))
-}
-
-func (h *httpCache) tryLocalCache(url string) (jsonnet.Contents, error) {
-	if h.cacheDir == "" {
-		return jsonnet.Contents{}, errors.New("cache is disabled")
-	}
-	localPath := h.getLocalPath(url)
-	bytes, err := ioutil.ReadFile(localPath)
-	if err != nil {
-		return jsonnet.Contents{}, err
-	}
-	return jsonnet.MakeContents(string(bytes)), nil
-}
-
-func (h *httpCache) writeToCache(url string, contents []byte) error {
-	if h.cacheDir == "" {
-		return nil
-	}
-	localPath := h.getLocalPath(url)
-	localPathDir := filepath.Dir(localPath)
-	finfo, err := os.Stat(localPathDir)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-		if err := os.MkdirAll(localPathDir, 0755); err != nil {
-			return err
-		}
-	}
-	if err == nil && !finfo.IsDir() {
-		return fmt.Errorf("%q is not a directory, it cannot be used for caching", localPathDir)
-	}
-	return ioutil.WriteFile(localPath, contents, 0644)
-}
-
-func (h *httpCache) Get(url string) (jsonnet.Contents, error) {
+func (h *httpFetcher) Get(url string) (jsonnet.Contents, error) {
 	isHTTP := httpRegex.MatchString(url)
 	isInternal := internalRegex.MatchString(url)
 
-	// If this is an http url, try the local cache first
-	if isHTTP {
-		contents, err := h.tryLocalCache(url)
-		if err == nil {
-			return contents, nil
-		}
-	}
-
 	// If this is an internal URL make sure it is rooted at /lib
 	if isInternal {
 		match := internalRegex.FindStringSubmatch(url)
@@ -138,12 +89,5 @@ func (h *httpCache) Get(url string) (jsonnet.Contents, error) {
 		return jsonnet.Contents{}, err
 	}
 
-	// If it was an http url, write the contents to the local cache
-	if isHTTP {
-		if err := h.writeToCache(url, bodyBytes); err != nil {
-			h.log.Error(err, fmt.Sprintf("Error writing %q to the local cache", url))
-		}
-	}
-
 	return jsonnet.MakeContents(string(bodyBytes)), nil
 }
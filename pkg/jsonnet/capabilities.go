@@ -0,0 +1,144 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/client-go/discovery"
+)
+
+// Capabilities describe the API server a build targets.
+type Capabilities struct {
+	// KubeVersion is the version of the API server.
+	KubeVersion chartutil.KubeVersion
+	// APIVersions are the group/versions served by the API server, along with the
+	// group/version/kind of every resource they serve.
+	APIVersions []string
+}
+
+// CapabilitiesFunc returns the capabilities of the cluster a build targets. It is
+// only called when a build uses them, and at most once per build.
+type CapabilitiesFunc func(ctx context.Context) (*Capabilities, error)
+
+// DefaultCapabilities returns the capabilities Helm assumes without a cluster.
+func DefaultCapabilities() *Capabilities {
+	return &Capabilities{
+		KubeVersion: chartutil.DefaultCapabilities.KubeVersion,
+		APIVersions: chartutil.DefaultCapabilities.APIVersions,
+	}
+}
+
+// DiscoverCapabilities returns a CapabilitiesFunc discovering the capabilities of the
+// API server with the given discovery client. Groups that fail discovery are left
+// out rather than failing the build. Discovery clients do not take contexts, so the
+// build stops waiting for discovery when its context is done, and clients should
+// bound their requests with a timeout. Pass a cached discovery client to avoid full
+// discovery on every build.
+func DiscoverCapabilities(dc discovery.DiscoveryInterface) CapabilitiesFunc {
+	return func(ctx context.Context) (*Capabilities, error) {
+		type result struct {
+			caps *Capabilities
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			caps, err := discoverCapabilities(dc)
+			done <- result{caps, err}
+		}()
+		select {
+		case res := <-done:
+			return res.caps, res.err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to discover the server capabilities: %w", ctx.Err())
+		}
+	}
+}
+
+// discoverCapabilities discovers the capabilities of the API server with the given
+// discovery client.
+func discoverCapabilities(dc discovery.DiscoveryInterface) (*Capabilities, error) {
+	info, err := dc.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the server version: %w", err)
+	}
+	groups, resources, err := dc.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover the server resources: %w", err)
+	}
+	seen := make(map[string]struct{})
+	for _, g := range groups {
+		for _, gv := range g.Versions {
+			seen[gv.GroupVersion] = struct{}{}
+		}
+	}
+	for _, list := range resources {
+		for _, r := range list.APIResources {
+			seen[path.Join(list.GroupVersion, r.Kind)] = struct{}{}
+		}
+	}
+	apiVersions := make([]string, 0, len(seen))
+	for v := range seen {
+		apiVersions = append(apiVersions, v)
+	}
+	sort.Strings(apiVersions)
+	return &Capabilities{
+		KubeVersion: chartutil.KubeVersion{Version: info.GitVersion, Major: info.Major, Minor: info.Minor},
+		APIVersions: apiVersions,
+	}, nil
+}
+
+// helm returns the capabilities as passed to Helm templates.
+func (c *Capabilities) helm() *chartutil.Capabilities {
+	caps := chartutil.DefaultCapabilities.Copy()
+	caps.KubeVersion = c.KubeVersion
+	caps.APIVersions = chartutil.VersionSet(c.APIVersions)
+	return caps
+}
+
+// capabilitiesNativeFunc returns the capabilities native function, which returns the
+// version of the API server and the API versions and kinds it serves.
+func capabilitiesNativeFunc(capabilities func() (*Capabilities, error)) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "capabilities",
+		Params: []ast.Identifier{},
+		Func: func(data []interface{}) (interface{}, error) {
+			caps, err := capabilities()
+			if err != nil {
+				return nil, err
+			}
+			apiVersions := make([]interface{}, len(caps.APIVersions))
+			for i, v := range caps.APIVersions {
+				apiVersions[i] = v
+			}
+			return map[string]interface{}{
+				"kubeVersion": map[string]interface{}{
+					"version": caps.KubeVersion.Version,
+					"major":   caps.KubeVersion.Major,
+					"minor":   caps.KubeVersion.Minor,
+				},
+				"apiVersions": apiVersions,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	dc := &fakediscovery.FakeDiscovery{
+		Fake:               &k8stesting.Fake{},
+		FakedServerVersion: &version.Info{GitVersion: "v1.21.3", Major: "1", Minor: "21"},
+	}
+	dc.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "policy/v1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets", Kind: "PodDisruptionBudget"}}},
+		{GroupVersion: "monitoring.coreos.com/v1", APIResources: []metav1.APIResource{{Name: "servicemonitors", Kind: "ServiceMonitor"}}},
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A chart emitting a ServiceMonitor only when the CRD is served
+	chart := filepath.Join(dir, "chart")
+	files := map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: test\nversion: 0.1.0\n",
+		"templates/monitor.yaml": `{{- if .Capabilities.APIVersions.Has "monitoring.coreos.com/v1/ServiceMonitor" }}
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: test
+  labels:
+    minor: "{{ .Capabilities.KubeVersion.Minor }}"
+{{- end }}
+`,
+	}
+	for name, data := range files {
+		path := filepath.Join(chart, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tcs := []struct {
+		name string
+		opts []BuilderOption
+		code string
+		want string
+	}{
+		{
+			name: "kube version",
+			opts: []BuilderOption{WithCapabilities(DiscoverCapabilities(dc))},
+			code: `std.native('capabilities')().kubeVersion.version`,
+			want: "\"v1.21.3\"\n",
+		},
+		{
+			name: "api versions",
+			opts: []BuilderOption{WithCapabilities(DiscoverCapabilities(dc))},
+			code: `local caps = std.native('capabilities')();
+[std.member(caps.apiVersions, v) for v in ['policy/v1', 'policy/v1/PodDisruptionBudget', 'policy/v1beta1']]`,
+			want: "[\n   true,\n   true,\n   false\n]\n",
+		},
+		{
+			name: "helm capabilities",
+			opts: []BuilderOption{WithCapabilities(DiscoverCapabilities(dc))},
+			code: `[o.metadata.labels.minor for o in std.objectValues(std.parseJson(std.native('helmTemplate')('test', '` + chart + `', {})))]`,
+			want: "[\n   \"21\"\n]\n",
+		},
+		{
+			name: "helm defaults",
+			code: `std.length(std.parseJson(std.native('helmTemplate')('test', '` + chart + `', {})))`,
+			want: "0\n",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, "main.jsonnet")
+			if err := ioutil.WriteFile(path, []byte(tc.code), 0644); err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewBuilder(nil, dir, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := b.Evaluate(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, out)
+			}
+		})
+	}
+}
+
+// blockingDiscovery is a discovery client whose requests never complete.
+type blockingDiscovery struct {
+	discovery.DiscoveryInterface
+	block chan struct{}
+}
+
+func (b *blockingDiscovery) ServerVersion() (*version.Info, error) {
+	<-b.block
+	return nil, errors.New("unblocked")
+}
+
+func TestDiscoverCapabilitiesContext(t *testing.T) {
+	dc := &blockingDiscovery{block: make(chan struct{})}
+	defer close(dc.block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := DiscoverCapabilities(dc)(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected discovery to stop with the context, got %v", err)
+	}
+}
@@ -50,7 +50,7 @@ type HelmTemplateOpts struct {
 	NameFormat string `json:"nameFormat"`
 }
 
-func helmTemplateNativeFunc() *jsonnet.NativeFunction {
+func helmTemplateNativeFunc(onRead func(path string), capabilities func() (*Capabilities, error)) *jsonnet.NativeFunction {
 	return &jsonnet.NativeFunction{
 		Name:   "helmTemplate",
 		Params: []ast.Identifier{"name", "chart", "opts"},
@@ -70,6 +70,13 @@ func helmTemplateNativeFunc() *jsonnet.NativeFunction {
 				return nil, err
 			}
 
+			if onRead != nil {
+				onRead(chartPath)
+				for _, f := range opts.ValuesFiles {
+					onRead(f)
+				}
+			}
+
 			chart, err := loader.Load(chartPath)
 			if err != nil {
 				return nil, err
@@ -110,7 +117,11 @@ func helmTemplateNativeFunc() *jsonnet.NativeFunction {
 				IsInstall: true,
 				IsUpgrade: false,
 			}
-			valuesToRender, err := chartutil.ToRenderValues(chart, helmVals, options, nil)
+			caps, err := capabilities()
+			if err != nil {
+				return nil, err
+			}
+			valuesToRender, err := chartutil.ToRenderValues(chart, helmVals, options, caps.helm())
 			if err != nil {
 				return nil, err
 			}
@@ -21,11 +21,14 @@ Copyright 2021 Pelotech - Apache License, Version 2.0.
 package jsonnet
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -37,7 +40,12 @@ import (
 var internalLib embed.FS
 
 // MakeUniversalImporter returns an importer that can handle filepaths, HTTP urls, and internal paths.
-func MakeUniversalImporter(log logr.Logger, searchURLs []*url.URL, cacheDir string) jsonnet.Importer {
+// When allowRemote is false, imports over HTTP(S) are refused.
+func MakeUniversalImporter(log logr.Logger, searchURLs []*url.URL, allowRemote bool) jsonnet.Importer {
+	return newUniversalImporter(log, searchURLs, allowRemote)
+}
+
+func newUniversalImporter(log logr.Logger, searchURLs []*url.URL, allowRemote bool) *universalImporter {
 	// Reconstructed copy of http.DefaultTransport (to avoid
 	// modifying the default)
 	t := &http.Transport{
@@ -58,17 +66,47 @@ func MakeUniversalImporter(log logr.Logger, searchURLs []*url.URL, cacheDir stri
 
 	return &universalImporter{
 		BaseSearchURLs: searchURLs,
-		HTTPCache:      NewHTTPCache(log, t, cacheDir),
+		HTTPFetcher:    NewHTTPFetcher(log, t),
+		allowRemote:    allowRemote,
 		cache:          map[string]jsonnet.Contents{},
 	}
 }
 
 type universalImporter struct {
 	BaseSearchURLs []*url.URL
-	HTTPCache      *httpCache
+	HTTPFetcher    *httpFetcher
+	allowRemote    bool
 	cache          map[string]jsonnet.Contents
+	// onImport is called with the URL and data of every resolved import, if set
+	onImport func(foundAt string, data []byte)
+	// onMiss is called with the URL of every candidate that did not exist while
+	// resolving an import, if set
+	onMiss func(triedAt string)
+	// remote fetches and caches HTTP(S) imports in place of the HTTPFetcher, if set
+	remote *RemoteFiles
+	// lock pins the contents of HTTP(S) imports, if set
+	lock *ImportLock
+	// schemes import the URLs of custom schemes, keyed by scheme
+	schemes map[string]ImportFunc
+	// remoteSchemes are the custom schemes refused when remote imports are disabled
+	remoteSchemes map[string]bool
+	ctx           context.Context
+}
+
+// addScheme imports the URLs of the given scheme with f.
+func (importer *universalImporter) addScheme(scheme string, remote bool, f ImportFunc) {
+	if importer.schemes == nil {
+		importer.schemes = make(map[string]ImportFunc)
+		importer.remoteSchemes = make(map[string]bool)
+	}
+	importer.schemes[scheme] = f
+	importer.remoteSchemes[scheme] = remote
 }
 
+// ErrRemoteImportsDisabled is returned when importing over HTTP(S) with remote imports
+// disabled.
+var ErrRemoteImportsDisabled = errors.New("remote imports are disabled")
+
 func (importer *universalImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
 	candidateURLs, err := importer.expandImportToCandidateURLs(importedFrom, importedPath)
 	if err != nil {
@@ -82,14 +120,29 @@ func (importer *universalImporter) Import(importedFrom, importedPath string) (js
 			return c, foundAt, nil
 		}
 
+		if !importer.allowRemote && (u.Scheme == "http" || u.Scheme == "https" || importer.remoteSchemes[u.Scheme]) {
+			return jsonnet.Contents{}, "", fmt.Errorf("could not import %s: %w", foundAt, ErrRemoteImportsDisabled)
+		}
+
 		tried = append(tried, foundAt)
-		importedData, err := importer.HTTPCache.Get(foundAt)
+		importedData, err := importer.get(u)
+		if err == nil && importer.lock != nil && (u.Scheme == "http" || u.Scheme == "https") {
+			if err := importer.lock.Verify(foundAt, []byte(importedData.String())); err != nil {
+				return jsonnet.Contents{}, "", err
+			}
+		}
 		if err == nil {
 			importer.cache[foundAt] = importedData
+			if importer.onImport != nil {
+				importer.onImport(foundAt, []byte(importedData.String()))
+			}
 			return importedData, foundAt, nil
-		} else if err != errNotFound {
+		} else if err != errNotFound && !errors.Is(err, os.ErrNotExist) {
 			return jsonnet.Contents{}, "", err
 		}
+		if importer.onMiss != nil {
+			importer.onMiss(foundAt)
+		}
 	}
 
 	return jsonnet.Contents{}, "", fmt.Errorf("couldn't open import %q, no match locally or in library search paths. Tried: %s",
@@ -98,6 +151,29 @@ func (importer *universalImporter) Import(importedFrom, importedPath string) (js
 	)
 }
 
+// get fetches the contents at the given URL.
+func (importer *universalImporter) get(u *url.URL) (jsonnet.Contents, error) {
+	ctx := importer.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if f, ok := importer.schemes[u.Scheme]; ok {
+		data, err := f(ctx, u)
+		if err != nil {
+			return jsonnet.Contents{}, err
+		}
+		return jsonnet.MakeContents(string(data)), nil
+	}
+	if importer.remote == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return importer.HTTPFetcher.Get(u.String())
+	}
+	data, _, err := importer.remote.Get(ctx, u.String())
+	if err != nil {
+		return jsonnet.Contents{}, err
+	}
+	return jsonnet.MakeContents(string(data)), nil
+}
+
 func (importer *universalImporter) expandImportToCandidateURLs(importedFrom, importedPath string) ([]*url.URL, error) {
 	importedPathURL, err := url.Parse(importedPath)
 	if err != nil {
@@ -0,0 +1,122 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// LockFileName is the name of the lockfile read from the root of a source when no
+// other lockfile is configured.
+const LockFileName = "jsonnet.lock.json"
+
+// importLockVersion is the version of the lockfile format.
+const importLockVersion = 1
+
+// ErrImportNotLocked is returned when a remote import is not pinned by the lock.
+var ErrImportNotLocked = errors.New("remote import is not pinned by the import lock")
+
+// ImportMismatchError is returned when the contents of a remote import do not match
+// the digest pinned by the lock.
+type ImportMismatchError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ImportMismatchError) Error() string {
+	return fmt.Sprintf("contents of %s do not match the locked digest %s, got %s", e.URL, e.Expected, e.Actual)
+}
+
+// lockDigestRegex matches the digests accepted in a lock, with or without prefix.
+var lockDigestRegex = regexp.MustCompile("^(sha256:)?[0-9a-f]{64}$")
+
+// ImportLock pins the contents of remote imports to the sha256 digests of their
+// contents, keyed by URL. An empty lock refuses every remote import.
+type ImportLock struct {
+	Version int               `json:"version"`
+	Imports map[string]string `json:"imports"`
+}
+
+// NewImportLock returns a lock pinning the given URLs to the given digests, with or
+// without 'sha256:' prefix.
+func NewImportLock(imports map[string]string) (*ImportLock, error) {
+	lock := &ImportLock{Version: importLockVersion, Imports: make(map[string]string, len(imports))}
+	if err := lock.Add(imports); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// ReadImportLock reads the lockfile at the given path.
+func ReadImportLock(path string) (*ImportLock, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file ImportLock
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile %s: %w", path, err)
+	}
+	if file.Version != importLockVersion {
+		return nil, fmt.Errorf("unsupported lockfile version %d in %s", file.Version, path)
+	}
+	lock, err := NewImportLock(file.Imports)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+	return lock, nil
+}
+
+// Add pins the given URLs to the given digests, replacing any previous digests.
+func (l *ImportLock) Add(imports map[string]string) error {
+	for url, digest := range imports {
+		if !lockDigestRegex.MatchString(digest) {
+			return fmt.Errorf("digest of %s must be a sha256 hex digest, got '%s'", url, digest)
+		}
+		l.Imports[url] = "sha256:" + strings.TrimPrefix(digest, "sha256:")
+	}
+	return nil
+}
+
+// Marshal returns the lock in the lockfile format.
+func (l *ImportLock) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Verify returns an error if the given contents of the remote import at the URL are
+// not pinned by the lock.
+func (l *ImportLock) Verify(url string, data []byte) error {
+	expected, ok := l.Imports[url]
+	if !ok {
+		return fmt.Errorf("%s: %w", url, ErrImportNotLocked)
+	}
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); actual != expected {
+		return &ImportMismatchError{URL: url, Expected: expected, Actual: actual}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportLock(t *testing.T) {
+	contents := "{ apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: 'locked' } }"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(contents))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, LockFileName)
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"version": 1, "imports": {%q: "%x"}}`,
+		srv.URL+"/cm.libsonnet", sha256.Sum256([]byte(contents)))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := ReadImportLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(url string) error {
+		builder, err := NewBuilder(nil, dir, WithImportLock(lock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = builder.Build(context.Background(), nil, url)
+		return err
+	}
+
+	if err := build(srv.URL + "/cm.libsonnet"); err != nil {
+		t.Errorf("expected the locked import to build, got %v", err)
+	}
+	if err := build(srv.URL + "/other.libsonnet"); err == nil || !strings.Contains(err.Error(), ErrImportNotLocked.Error()) {
+		t.Errorf("expected an import missing from the lock to be refused, got %v", err)
+	}
+	contents = "{}"
+	if err := build(srv.URL + "/cm.libsonnet"); err == nil || !strings.Contains(err.Error(), "do not match the locked digest") {
+		t.Errorf("expected modified contents to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// lookupKeySecretKey is the key the lookup key is stored under in its secret.
+const lookupKeySecretKey = "lookup.key"
+
+// maxLookupKeyAttempts bounds the retries when replicas race to create the same secret.
+const maxLookupKeyAttempts = 5
+
+// lookupKey keys the digests of the contents of looked up objects, so that the
+// checksums recorded in statuses cannot be used to guess them. When SetLookupKey is
+// not called it is random, so checksums change at every restart, which triggers one
+// more build of each Konfiguration doing lookups.
+var lookupKey = newLookupKey()
+
+func newLookupKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// SetLookupKey sets the key the checksums of looked up objects are computed with. It
+// must be called before any build.
+func SetLookupKey(key []byte) {
+	lookupKey = key
+}
+
+// SecretLookupKey returns the lookup key stored in the given secret, generating and
+// storing a new one if the secret does not exist or holds no key. Replicas and
+// restarts sharing the secret therefore compute the same checksums.
+func SecretLookupKey(ctx context.Context, c client.Client, namespace, name string) ([]byte, error) {
+	for attempt := 0; attempt < maxLookupKeyAttempts; attempt++ {
+		var secret corev1.Secret
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("failed to retrieve lookup key secret '%s/%s': %w", namespace, name, err)
+		}
+		exists := err == nil
+		if key := secret.Data[lookupKeySecretKey]; exists && len(key) > 0 {
+			return key, nil
+		}
+
+		key := newLookupKey()
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[lookupKeySecretKey] = key
+		if exists {
+			err = c.Update(ctx, &secret)
+		} else {
+			secret.ObjectMeta = metav1.ObjectMeta{Namespace: namespace, Name: name}
+			secret.Type = corev1.SecretTypeOpaque
+			err = c.Create(ctx, &secret)
+		}
+		switch {
+		case err == nil:
+			return key, nil
+		case apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err):
+			// Another replica stored a key first, use that one
+			continue
+		default:
+			return nil, fmt.Errorf("failed to store lookup key secret '%s/%s': %w", namespace, name, err)
+		}
+	}
+	return nil, fmt.Errorf("failed to store lookup key secret '%s/%s': too many conflicts", namespace, name)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSecretLookupKey(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+
+	// The first replica generates the key
+	first, err := SecretLookupKey(ctx, c, "flux-system", "lookup-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != sha256.Size {
+		t.Fatalf("expected a %d bytes key, got %d", sha256.Size, len(first))
+	}
+
+	// Other replicas and restarts read it back
+	second, err := SecretLookupKey(ctx, c, "flux-system", "lookup-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("expected the stored key to be reused")
+	}
+
+	// A secret without a key gets one
+	if err := c.Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "empty"}}); err != nil {
+		t.Fatal(err)
+	}
+	key, err := SecretLookupKey(ctx, c, "flux-system", "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "flux-system", Name: "empty"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data[lookupKeySecretKey], key) {
+		t.Error("expected the generated key to be stored")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Lookup is an object, or a list of objects when Name is empty, read by the lookup
+// native function.
+type Lookup struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	// Checksum is the sha256 checksum of the UIDs and contents of what was read,
+	// ignoring their status, resource versions and managed fields. Contents are
+	// hashed with the lookup key, so that it reveals nothing of them. It is empty
+	// when the object was not found.
+	Checksum string
+}
+
+// key returns the key the lookup is recorded under in a build.
+func (l Lookup) key() string {
+	return strings.Join([]string{l.APIVersion, l.Kind, l.Namespace, l.Name}, "\x00")
+}
+
+// lookupNativeFunc returns the lookup native function, which reads live objects like
+// Helm's lookup. It returns the object with the given name, or the list of objects
+// of the kind when the name is empty, and an empty object when it is not found.
+func lookupNativeFunc(lookup func(l *Lookup) (interface{}, error)) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "lookup",
+		Params: []ast.Identifier{"apiVersion", "kind", "namespace", "name"},
+		Func: func(data []interface{}) (interface{}, error) {
+			args := make([]string, len(data))
+			for i, arg := range data {
+				s, ok := arg.(string)
+				if !ok {
+					return nil, fmt.Errorf("lookup: argument %d must be of 'string' type, got '%T' instead", i+1, arg)
+				}
+				args[i] = s
+			}
+			if args[0] == "" || args[1] == "" {
+				return nil, fmt.Errorf("lookup: apiVersion and kind are required")
+			}
+			return lookup(&Lookup{APIVersion: args[0], Kind: args[1], Namespace: args[2], Name: args[3]})
+		},
+	}
+}
+
+// lookupObject reads the object, or list of objects, described by the lookup with the
+// given reader, and sets its checksum. Without a reader, lookups always return an
+// empty object, like with helm template.
+func lookupObject(ctx context.Context, c client.Reader, l *Lookup) (interface{}, error) {
+	if c == nil {
+		return map[string]interface{}{}, nil
+	}
+	gvk := schema.FromAPIVersionAndKind(l.APIVersion, l.Kind)
+	var obj map[string]interface{}
+	var versions []string
+	if l.Name == "" {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := c.List(ctx, list, client.InNamespace(l.Namespace)); err != nil {
+			return nil, fmt.Errorf("lookup: failed to list %s: %w", l.Kind, err)
+		}
+		sort.Slice(list.Items, func(i, j int) bool {
+			if list.Items[i].GetNamespace() != list.Items[j].GetNamespace() {
+				return list.Items[i].GetNamespace() < list.Items[j].GetNamespace()
+			}
+			return list.Items[i].GetName() < list.Items[j].GetName()
+		})
+		items := make([]interface{}, len(list.Items))
+		for i := range list.Items {
+			items[i] = list.Items[i].Object
+			version, err := objectVersion(&list.Items[i])
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, version)
+		}
+		list.UnstructuredContent()["items"] = items
+		obj = list.Object
+	} else {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		if err := c.Get(ctx, client.ObjectKey{Namespace: l.Namespace, Name: l.Name}, u); err != nil {
+			if apierrors.IsNotFound(err) {
+				return map[string]interface{}{}, nil
+			}
+			return nil, fmt.Errorf("lookup: failed to get %s %s: %w", l.Kind, client.ObjectKey{Namespace: l.Namespace, Name: l.Name}, err)
+		}
+		obj = u.Object
+		version, err := objectVersion(u)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	// Round-trip through JSON so that the VM only sees the types it can convert
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	l.Checksum = fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(versions, "\n"))))
+	return out, nil
+}
+
+// objectVersion identifies the version of an object without revealing its contents.
+// The resource version and managed fields are left out, so that updates which do
+// not change the object, such as no-op applies, do not trigger builds. So is the
+// status, so that objects whose status is regularly refreshed, like the heartbeats
+// of Nodes, do not trigger builds at that pace.
+func objectVersion(u *unstructured.Unstructured) (string, error) {
+	content := u.DeepCopy()
+	unstructured.RemoveNestedField(content.Object, "status")
+	unstructured.RemoveNestedField(content.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(content.Object, "metadata", "managedFields")
+	data, err := json.Marshal(content.Object)
+	if err != nil {
+		return "", fmt.Errorf("lookup: failed to encode %s %s/%s: %w", u.GetKind(), u.GetNamespace(), u.GetName(), err)
+	}
+	mac := hmac.New(sha256.New, lookupKey)
+	mac.Write(data)
+	return fmt.Sprintf("%s/%s %s %x", u.GetNamespace(), u.GetName(), u.GetUID(), mac.Sum(nil)), nil
+}
+
+// LookupChecksum reads the object, or list of objects, of a lookup recorded by a
+// previous build with the given reader, and returns its current checksum, empty if
+// it is not found.
+func LookupChecksum(ctx context.Context, c client.Reader, l Lookup) (string, error) {
+	if _, err := lookupObject(ctx, c, &l); err != nil {
+		return "", err
+	}
+	return l.Checksum, nil
+}
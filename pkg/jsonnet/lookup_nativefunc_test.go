@@ -0,0 +1,160 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLookup(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "creds"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(secret, &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"zone": "a"}},
+	}).Build()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tcs := []struct {
+		name        string
+		code        string
+		want        string
+		wantLookups int
+	}{
+		{
+			name:        "existing object",
+			code:        `std.base64Decode(std.native('lookup')('v1', 'Secret', 'default', 'creds').data.password)`,
+			want:        "\"hunter2\"\n",
+			wantLookups: 1,
+		},
+		{
+			name:        "missing object",
+			code:        `std.native('lookup')('v1', 'Secret', 'default', 'missing')`,
+			want:        "{ }\n",
+			wantLookups: 1,
+		},
+		{
+			name:        "list",
+			code:        `[n.metadata.labels.zone for n in std.native('lookup')('v1', 'Node', '', '').items]`,
+			want:        "[\n   \"a\"\n]\n",
+			wantLookups: 1,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, "main.jsonnet")
+			if err := ioutil.WriteFile(path, []byte(tc.code), 0644); err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewBuilder(nil, dir, WithLookup(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := b.Evaluate(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, out)
+			}
+			if got := len(b.(*builder).lookups); got != tc.wantLookups {
+				t.Errorf("expected %d lookups, got %d", tc.wantLookups, got)
+			}
+		})
+	}
+
+	// Lookups without a reader return empty objects
+	path := filepath.Join(dir, "main.jsonnet")
+	if err := ioutil.WriteFile(path, []byte(`std.native('lookup')('v1', 'Secret', 'default', 'creds')`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	builder, err := NewBuilder(nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := builder.Evaluate(path); err != nil || out != "{ }\n" {
+		t.Errorf("expected an empty object, got %q, %v", out, err)
+	}
+
+	// Changes are detected from the recorded checksum
+	ctx := context.Background()
+	l := &Lookup{APIVersion: "v1", Kind: "Secret", Namespace: "default", Name: "creds"}
+	if _, err := lookupObject(ctx, c, l); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := LookupChecksum(ctx, c, *l); err != nil || sum != l.Checksum {
+		t.Errorf("expected the checksum to be unchanged, got %q, %v", sum, err)
+	}
+	// Updates that only bump the resource version are ignored
+	rv := secret.GetResourceVersion()
+	if err := c.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.GetResourceVersion() == rv {
+		t.Fatal("expected the resource version to change")
+	}
+	if sum, err := LookupChecksum(ctx, c, *l); err != nil || sum != l.Checksum {
+		t.Errorf("expected the checksum to ignore the resource version, got %q, %v", sum, err)
+	}
+	secret.Data["password"] = []byte("changed")
+	if err := c.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := LookupChecksum(ctx, c, *l); err != nil || sum == l.Checksum || sum == "" {
+		t.Errorf("expected the checksum to change, got %q, %v", sum, err)
+	}
+
+	// Status is ignored, so heartbeats do not trigger builds
+	nl := &Lookup{APIVersion: "v1", Kind: "Node", Name: "node-1"}
+	if _, err := lookupObject(ctx, c, nl); err != nil {
+		t.Fatal(err)
+	}
+	node := &corev1.Node{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "node-1"}, node); err != nil {
+		t.Fatal(err)
+	}
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue, LastHeartbeatTime: metav1.Now()}}
+	if err := c.Update(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := LookupChecksum(ctx, c, *nl); err != nil || sum != nl.Checksum {
+		t.Errorf("expected the checksum to ignore the status, got %q, %v", sum, err)
+	}
+	node.Labels["zone"] = "b"
+	if err := c.Update(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := LookupChecksum(ctx, c, *nl); err != nil || sum == nl.Checksum {
+		t.Errorf("expected the checksum to change, got %q, %v", sum, err)
+	}
+}
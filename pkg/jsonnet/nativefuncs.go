@@ -30,11 +30,18 @@ import (
 	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
-// registerNativeFuncs adds kubecfg's native jsonnet functions to the provided VM
-func registerNativeFuncs(vm *jsonnet.VM) {
+// registerNativeFuncs adds kubecfg's native jsonnet functions to the provided VM.
+// onRead, if not nil, is called with the paths native functions read from disk,
+// lookup reads the objects requested by the lookup function, and capabilities returns
+// those of the cluster.
+func registerNativeFuncs(vm *jsonnet.VM, onRead func(path string), lookup func(l *Lookup) (interface{}, error), capabilities func() (*Capabilities, error)) {
 
 	// Helm Template
-	vm.NativeFunction(helmTemplateNativeFunc())
+	vm.NativeFunction(helmTemplateNativeFunc(onRead, capabilities))
+
+	// Cluster Lookups and Capabilities
+	vm.NativeFunction(lookupNativeFunc(lookup))
+	vm.NativeFunction(capabilitiesNativeFunc(capabilities))
 
 	// JSON/YAML Parsing
 
@@ -27,6 +27,14 @@ import (
 // BuildOutput contains the output from a build operation.
 type BuildOutput struct {
 	objects ObjectSorter
+	// raw json of the outputs field
+	outputs []byte
+	// checksums of imported files keyed by URL
+	imports map[string]string
+	// candidates that did not exist while resolving imports, sorted
+	misses []string
+	// objects read by lookups, sorted
+	lookups []Lookup
 
 	// whether we sorted already
 	sorted bool
@@ -44,6 +52,32 @@ func (b *BuildOutput) append(obj *unstructured.Unstructured) {
 	b.objects = append(b.objects, obj)
 }
 
+// Outputs returns the JSON object published in the reserved outputs field of the
+// build, or nil if there was none.
+func (b *BuildOutput) Outputs() []byte {
+	return b.outputs
+}
+
+// Imports returns the sha256 checksums of every file the build imported, keyed by
+// the URL it was found at. Files read by native functions, whose contents are not
+// tracked, have an empty checksum.
+func (b *BuildOutput) Imports() map[string]string {
+	return b.imports
+}
+
+// Misses returns the URLs of the candidates that were tried and did not exist while
+// resolving imports found later in the search path. A file created at any of them
+// would shadow the import.
+func (b *BuildOutput) Misses() []string {
+	return b.misses
+}
+
+// Lookups returns the objects, and lists of objects, read by the lookup native
+// function during the build.
+func (b *BuildOutput) Lookups() []Lookup {
+	return b.lookups
+}
+
 // YAMLStream produces a yaml stream of the objects in this build output. The stream
 // is cached internally so modifications to this output will not affect the produced
 // stream from the first call.
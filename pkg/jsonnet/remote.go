@@ -0,0 +1,434 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrRemoteFileTooLarge is returned when a remote file exceeds the size limit of
+// RemoteFiles.
+var ErrRemoteFileTooLarge = errors.New("remote file exceeds the size limit")
+
+var (
+	remoteFilesRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jsonnet_controller_remote_cache_requests_total",
+		Help: "The number of remote files requested from the cache, by result: hit, revalidated or miss.",
+	}, []string{"result"})
+	remoteFilesEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "jsonnet_controller_remote_cache_evictions_total",
+		Help: "The number of remote files evicted from the cache to keep it under its size limit.",
+	})
+	remoteFilesSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "jsonnet_controller_remote_cache_size_bytes",
+		Help: "The total size of the remote files in the cache.",
+	})
+)
+
+// RemoteFilesCollectors returns the metrics collectors of RemoteFiles, to be
+// registered with a prometheus registry.
+func RemoteFilesCollectors() []prometheus.Collector {
+	return []prometheus.Collector{remoteFilesRequests, remoteFilesEvictions, remoteFilesSize}
+}
+
+// RemoteFilesOptions are the options for caching remote files.
+type RemoteFilesOptions struct {
+	// Dir is the directory the files are kept in across restarts, empty to only keep
+	// them in memory.
+	Dir string
+	// TTL is how long a file is served from the cache before it is revalidated with
+	// the server. Zero revalidates it on every request.
+	TTL time.Duration
+	// Immutable matches the URLs of files that never change, such as those pinned to
+	// a tag or a commit. They are never revalidated once cached.
+	Immutable []*regexp.Regexp
+	// MaxSize is the total size in bytes above which the least recently used files
+	// are evicted, zero for no limit.
+	MaxSize int64
+	// MaxFileSize is the size in bytes above which a file is refused, zero for no
+	// limit.
+	MaxFileSize int64
+}
+
+// RemoteFileInfo describes a file in the cache of RemoteFiles.
+type RemoteFileInfo struct {
+	URL          string    `json:"url"`
+	Checksum     string    `json:"checksum"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+	LastUsed     time.Time `json:"lastUsed"`
+	Immutable    bool      `json:"immutable,omitempty"`
+}
+
+// RemoteFiles fetches files over HTTP(S) and caches them, along with their validators
+// and checksum, for the configured TTL. Expired files are revalidated with conditional
+// requests when the server supports them. Files kept on disk are checked against their
+// checksum whenever they are read, and fetched again if they do not match. It is safe
+// for concurrent use.
+type RemoteFiles struct {
+	client *http.Client
+	opts   RemoteFilesOptions
+	// dir is the directory files are kept in, if any
+	dir string
+
+	mu    sync.Mutex
+	size  int64
+	files map[string]*remoteFile
+}
+
+type remoteFile struct {
+	info RemoteFileInfo
+	// data holds the contents of the file when it is not kept on disk
+	data []byte
+}
+
+// remoteFileKeyRegex matches the names of the files kept on disk.
+var remoteFileKeyRegex = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// NewRemoteFiles returns RemoteFiles fetching with the given client, or the default
+// client when nil. When a directory is configured, the files cached there by a
+// previous process are loaded.
+func NewRemoteFiles(client *http.Client, opts RemoteFilesOptions) (*RemoteFiles, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	f := &RemoteFiles{client: client, opts: opts, files: make(map[string]*remoteFile)}
+	if opts.Dir != "" {
+		f.dir = filepath.Join(opts.Dir, "http")
+		if err := f.load(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// load reads the metadata of the files in the cache directory, and removes anything
+// that does not belong to a complete entry, such as partial writes.
+func (f *RemoteFiles) load() error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	finfos, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	sizes := make(map[string]int64, len(finfos))
+	for _, finfo := range finfos {
+		if !finfo.IsDir() {
+			sizes[finfo.Name()] = finfo.Size()
+		}
+	}
+
+	loaded := make(map[string]bool)
+	for name := range sizes {
+		key := strings.TrimSuffix(name, ".json")
+		size, ok := sizes[key]
+		if key == name || !ok || !remoteFileKeyRegex.MatchString(key) {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(f.dir, name))
+		if err != nil {
+			return err
+		}
+		var info RemoteFileInfo
+		if err := json.Unmarshal(raw, &info); err != nil || remoteFileKey(info.URL) != key || info.Size != size {
+			continue
+		}
+		f.files[info.URL] = &remoteFile{info: info}
+		f.size += info.Size
+		loaded[key] = true
+	}
+	for name := range sizes {
+		if !loaded[strings.TrimSuffix(name, ".json")] {
+			if err := os.Remove(filepath.Join(f.dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	f.evict("")
+	remoteFilesSize.Set(float64(f.size))
+	return nil
+}
+
+// Get returns the contents of the file at the given URL and their sha256 checksum,
+// from the cache if they did not expire. Expired files are revalidated, and their
+// cached contents returned if they were not modified.
+func (f *RemoteFiles) Get(ctx context.Context, url string) (data []byte, checksum string, err error) {
+	now := time.Now()
+	f.mu.Lock()
+	prev := f.files[url]
+	var info RemoteFileInfo
+	if prev != nil {
+		prev.info.LastUsed = now
+		info = prev.info
+	}
+	f.mu.Unlock()
+
+	if prev != nil && (f.isImmutable(url) || now.Sub(info.FetchedAt) < f.opts.TTL) {
+		if data, err := f.read(prev, &info); err == nil {
+			remoteFilesRequests.WithLabelValues("hit").Inc()
+			return data, info.Checksum, nil
+		}
+		f.remove(prev)
+		prev = nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	if prev != nil {
+		if info.ETag != "" {
+			req.Header.Set("If-None-Match", info.ETag)
+		}
+		if info.LastModified != "" {
+			req.Header.Set("If-Modified-Since", info.LastModified)
+		}
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && prev != nil:
+		data, err := f.read(prev, &info)
+		if err != nil {
+			// Fetch the file in full again
+			f.remove(prev)
+			return f.Get(ctx, url)
+		}
+		info.FetchedAt = now
+		if err := f.store(prev, &info, nil); err != nil {
+			return nil, "", err
+		}
+		remoteFilesRequests.WithLabelValues("revalidated").Inc()
+		return data, info.Checksum, nil
+	case res.StatusCode == http.StatusNotFound:
+		return nil, "", errNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("error reading %s: %s", url, res.Status)
+	}
+
+	var body io.Reader = res.Body
+	if f.opts.MaxFileSize > 0 {
+		body = io.LimitReader(res.Body, f.opts.MaxFileSize+1)
+	}
+	data, err = ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.opts.MaxFileSize > 0 && int64(len(data)) > f.opts.MaxFileSize {
+		return nil, "", fmt.Errorf("%s: %w", url, ErrRemoteFileTooLarge)
+	}
+
+	info = RemoteFileInfo{
+		URL:          url,
+		Checksum:     fmt.Sprintf("%x", sha256.Sum256(data)),
+		Size:         int64(len(data)),
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		FetchedAt:    now,
+		LastUsed:     now,
+	}
+	if err := f.store(&remoteFile{}, &info, data); err != nil {
+		return nil, "", err
+	}
+	remoteFilesRequests.WithLabelValues("miss").Inc()
+	return data, info.Checksum, nil
+}
+
+// Entries returns the files in the cache, sorted by URL.
+func (f *RemoteFiles) Entries() []RemoteFileInfo {
+	f.mu.Lock()
+	entries := make([]RemoteFileInfo, 0, len(f.files))
+	for _, file := range f.files {
+		info := file.info
+		info.Immutable = f.isImmutable(info.URL)
+		entries = append(entries, info)
+	}
+	f.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+	return entries
+}
+
+// Purge removes the files whose URL starts with the given prefix from the cache, and
+// returns how many were removed. An empty prefix removes all of them.
+func (f *RemoteFiles) Purge(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var purged int
+	for url, file := range f.files {
+		if strings.HasPrefix(url, prefix) {
+			f.removeLocked(file)
+			purged++
+		}
+	}
+	remoteFilesSize.Set(float64(f.size))
+	return purged
+}
+
+func (f *RemoteFiles) isImmutable(url string) bool {
+	for _, re := range f.opts.Immutable {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// read returns the contents of the file, after checking them against its checksum.
+func (f *RemoteFiles) read(file *remoteFile, info *RemoteFileInfo) ([]byte, error) {
+	if f.dir == "" {
+		return file.data, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(f.dir, remoteFileKey(info.URL)))
+	if err != nil {
+		return nil, err
+	}
+	if sum := fmt.Sprintf("%x", sha256.Sum256(data)); sum != info.Checksum {
+		return nil, fmt.Errorf("cached contents of %s do not match checksum %s", info.URL, info.Checksum)
+	}
+	return data, nil
+}
+
+// store writes the contents, if any, and the metadata of the file and adds it to the
+// cache, evicting other files if it grew past its size limit.
+func (f *RemoteFiles) store(file *remoteFile, info *RemoteFileInfo, data []byte) error {
+	if f.dir != "" {
+		key := remoteFileKey(info.URL)
+		if data != nil {
+			if err := writeFileAtomic(filepath.Join(f.dir, key), data); err != nil {
+				return err
+			}
+		}
+		meta, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(f.dir, key+".json"), meta); err != nil {
+			return err
+		}
+	} else if data != nil {
+		file.data = data
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if prev, ok := f.files[info.URL]; ok {
+		f.size -= prev.info.Size
+	}
+	file.info = *info
+	f.files[info.URL] = file
+	f.size += info.Size
+	f.evict(info.URL)
+	remoteFilesSize.Set(float64(f.size))
+	return nil
+}
+
+// remove removes the file from the cache, unless it was replaced in the meantime.
+func (f *RemoteFiles) remove(file *remoteFile) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files[file.info.URL] == file {
+		f.removeLocked(file)
+		remoteFilesSize.Set(float64(f.size))
+	}
+}
+
+func (f *RemoteFiles) removeLocked(file *remoteFile) {
+	delete(f.files, file.info.URL)
+	f.size -= file.info.Size
+	if f.dir != "" {
+		key := remoteFileKey(file.info.URL)
+		os.Remove(filepath.Join(f.dir, key+".json"))
+		os.Remove(filepath.Join(f.dir, key))
+	}
+}
+
+// evict removes the least recently used files, other than the one at the given URL,
+// until the cache is under its size limit. It must be called with the lock held.
+func (f *RemoteFiles) evict(keep string) {
+	if f.opts.MaxSize <= 0 || f.size <= f.opts.MaxSize {
+		return
+	}
+	lru := make([]*remoteFile, 0, len(f.files))
+	for url, file := range f.files {
+		if url != keep {
+			lru = append(lru, file)
+		}
+	}
+	sort.Slice(lru, func(i, j int) bool { return lru[i].info.LastUsed.Before(lru[j].info.LastUsed) })
+	for _, file := range lru {
+		if f.size <= f.opts.MaxSize {
+			break
+		}
+		f.removeLocked(file)
+		remoteFilesEvictions.Inc()
+	}
+}
+
+// remoteFileKey returns the name of the file the contents at the given URL are kept
+// in.
+func remoteFileKey(url string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(url)))
+}
+
+// writeFileAtomic writes data to a temporary file renamed to path, so that a partial
+// write is never read.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRemoteFiles(t *testing.T) {
+	contents := "{ version: 1 }"
+	var full, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(contents)))
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(contents))
+	}))
+	defer srv.Close()
+
+	files, err := NewRemoteFiles(srv.Client(), RemoteFilesOptions{MaxFileSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	get := func() string {
+		t.Helper()
+		data, sum, err := files.Get(ctx, srv.URL+"/main.jsonnet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != fmt.Sprintf("%x", sha256.Sum256(data)) {
+			t.Errorf("expected the checksum of %q, got %s", data, sum)
+		}
+		return string(data)
+	}
+
+	if data := get(); data != contents {
+		t.Errorf("expected %q, got %q", contents, data)
+	}
+	// Revalidated without transferring the contents again
+	if data := get(); data != contents || full != 1 || notModified != 1 {
+		t.Errorf("expected a revalidated %q, got %q after %d full and %d conditional responses", contents, data, full, notModified)
+	}
+	// Changes are picked up
+	contents = "{ version: 2 }"
+	if data := get(); data != contents || full != 2 {
+		t.Errorf("expected %q, got %q after %d full responses", contents, data, full)
+	}
+
+	contents = string(make([]byte, 65))
+	if _, _, err := files.Get(ctx, srv.URL+"/main.jsonnet"); !errors.Is(err, ErrRemoteFileTooLarge) {
+		t.Errorf("expected a file over the limit to be refused, got %v", err)
+	}
+}
+
+func TestRemoteFilesCache(t *testing.T) {
+	contents := map[string]string{"/a.libsonnet": "'a'", "/b.libsonnet": "'b'", "/v1/c.libsonnet": "'c'"}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(contents[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := RemoteFilesOptions{
+		Dir:       dir,
+		TTL:       time.Hour,
+		Immutable: []*regexp.Regexp{regexp.MustCompile("/v1/")},
+		MaxSize:   6,
+	}
+	files, err := NewRemoteFiles(srv.Client(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	get := func(files *RemoteFiles, path string) {
+		t.Helper()
+		data, _, err := files.Get(ctx, srv.URL+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents[path] {
+			t.Errorf("expected %q, got %q", contents[path], data)
+		}
+	}
+
+	// Served from the cache until the TTL expires
+	get(files, "/a.libsonnet")
+	get(files, "/a.libsonnet")
+	if requests != 1 {
+		t.Errorf("expected a single request within the TTL, got %d", requests)
+	}
+
+	// The least recently used file is evicted past the size limit
+	get(files, "/b.libsonnet")
+	get(files, "/v1/c.libsonnet")
+	if entries := files.Entries(); len(entries) != 2 || entries[0].URL != srv.URL+"/b.libsonnet" || !entries[1].Immutable {
+		t.Errorf("expected b and an immutable c to be cached, got %+v", entries)
+	}
+
+	// Files on disk are loaded by a new process, and fetched again if they were
+	// corrupted
+	opts.TTL = 0
+	reloaded, err := NewRemoteFiles(srv.Client(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests = 0
+	get(reloaded, "/v1/c.libsonnet")
+	if requests != 0 {
+		t.Errorf("expected an immutable file to never be revalidated, got %d requests", requests)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "http", remoteFileKey(srv.URL+"/v1/c.libsonnet")), []byte("'x'"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	get(reloaded, "/v1/c.libsonnet")
+	if requests != 1 {
+		t.Errorf("expected a corrupted file to be fetched again, got %d requests", requests)
+	}
+
+	if purged := reloaded.Purge(srv.URL + "/v1/"); purged != 1 || len(reloaded.Entries()) != 1 {
+		t.Errorf("expected c to be purged, purged %d and kept %+v", purged, reloaded.Entries())
+	}
+}
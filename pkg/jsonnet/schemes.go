@@ -0,0 +1,120 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
+	"github.com/pelotech/jsonnet-controller/pkg/oci"
+)
+
+// ImportFunc reads the file at a URL of a custom import scheme. It returns an error
+// wrapping os.ErrNotExist when there is no file at the URL, so that the next
+// candidate is tried.
+type ImportFunc func(ctx context.Context, u *url.URL) ([]byte, error)
+
+// SchemeFunc returns the ImportFunc for a single evaluation, and a function to
+// release what it holds once the evaluation is done.
+type SchemeFunc func() (f ImportFunc, release func())
+
+// importScheme is a custom import scheme registered on a builder.
+type importScheme struct {
+	remote bool
+	new    SchemeFunc
+}
+
+// ConfigMapImporter imports k8s://namespace/name/key URLs from the data of ConfigMaps
+// read with the given client, so that they are only readable by its identity.
+func ConfigMapImporter(c client.Reader) SchemeFunc {
+	f := func(ctx context.Context, u *url.URL) ([]byte, error) {
+		parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+		if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s is not of the form k8s://namespace/configmap/key", u)
+		}
+		var cm corev1.ConfigMap
+		if err := c.Get(ctx, types.NamespacedName{Namespace: u.Host, Name: parts[0]}, &cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("configmap %s/%s: %w", u.Host, parts[0], os.ErrNotExist)
+			}
+			return nil, fmt.Errorf("failed to get configmap %s/%s: %w", u.Host, parts[0], err)
+		}
+		if data, ok := cm.Data[parts[1]]; ok {
+			return []byte(data), nil
+		}
+		if data, ok := cm.BinaryData[parts[1]]; ok {
+			return data, nil
+		}
+		return nil, fmt.Errorf("key %s of configmap %s/%s: %w", parts[1], u.Host, parts[0], os.ErrNotExist)
+	}
+	return func() (ImportFunc, func()) { return f, func() {} }
+}
+
+// OCIImporter imports oci://registry/repository:tag/path URLs from the files of OCI
+// artifacts pulled with the given client. Artifacts are extracted once into the given
+// cache, keyed by manifest digest, and tags are resolved once per evaluation.
+func OCIImporter(c *oci.Client, cache *artifacts.Cache) SchemeFunc {
+	return func() (ImportFunc, func()) {
+		dirs := make(map[string]string)
+		var releases []func()
+		f := func(ctx context.Context, u *url.URL) ([]byte, error) {
+			ref, path, err := oci.SplitURL(u)
+			if err != nil {
+				return nil, err
+			}
+			dir, ok := dirs[ref.String()]
+			if !ok {
+				digest, err := c.Resolve(ctx, ref)
+				if err != nil {
+					return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+				}
+				id := fmt.Sprintf("oci\x00%s/%s@%s", ref.Registry, ref.Repository, digest)
+				var release func()
+				dir, release, err = cache.GetFunc(ctx, id, func(ctx context.Context, dir string) error {
+					return c.Pull(ctx, ref, digest, dir)
+				})
+				if err != nil {
+					return nil, fmt.Errorf("failed to pull %s: %w", ref, err)
+				}
+				releases = append(releases, release)
+				dirs[ref.String()] = dir
+			}
+			file, err := securejoin.SecureJoin(dir, path)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.ReadFile(file)
+		}
+		release := func() {
+			for _, r := range releases {
+				r()
+			}
+		}
+		return f, release
+	}
+}
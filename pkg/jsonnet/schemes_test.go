@@ -0,0 +1,107 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConfigMapImporter(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "libs", Name: "shared"},
+		Data: map[string]string{
+			"main.libsonnet": `{ name: (import 'name.libsonnet') }`,
+			"name.libsonnet": `'test'`,
+		},
+	}).Build()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tcs := []struct {
+		name    string
+		code    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "relative imports",
+			code: `(import 'k8s://libs/shared/main.libsonnet').name`,
+			want: "\"test\"\n",
+		},
+		{
+			name:    "missing key",
+			code:    `import 'k8s://libs/shared/missing.libsonnet'`,
+			wantErr: true,
+		},
+		{
+			name:    "missing configmap",
+			code:    `import 'k8s://libs/missing/main.libsonnet'`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, "main.jsonnet")
+			if err := ioutil.WriteFile(path, []byte(tc.code), 0644); err != nil {
+				t.Fatal(err)
+			}
+			builder, err := NewBuilder(nil, dir, WithImportScheme("k8s", false, ConfigMapImporter(c)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := builder.Evaluate(path)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, out)
+			}
+		})
+	}
+
+	// Remote schemes are refused with remote imports disabled
+	path := filepath.Join(dir, "main.jsonnet")
+	if err := ioutil.WriteFile(path, []byte(`import 'k8s://libs/shared/name.libsonnet'`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	builder, err := NewBuilder(nil, dir, WithImportScheme("k8s", true, ConfigMapImporter(c)), WithoutRemoteImports())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Evaluate(path); err == nil || !strings.Contains(err.Error(), ErrRemoteImportsDisabled.Error()) {
+		t.Errorf("expected remote imports to be disabled, got %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oci pulls artifacts, such as jsonnet libraries, from OCI registries and
+// local OCI image layouts.
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pelotech/jsonnet-controller/pkg/artifacts"
+)
+
+const (
+	// RefNameAnnotation is the annotation of the manifests of an OCI image layout
+	// index used to find artifacts in it.
+	RefNameAnnotation = "org.opencontainers.image.ref.name"
+
+	manifestMediaType       = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	// maxManifestSize is the maximum size of a manifest or layout index.
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+var challengeParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Client pulls artifacts from OCI registries, or from a local OCI image layout.
+// Registries are accessed anonymously, using bearer tokens when they ask for them.
+type Client struct {
+	// HTTPClient is used for requests to registries, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Layout is the directory of an OCI image layout searched before registries, for
+	// use offline. Artifacts are found by the RefNameAnnotation of their manifest in
+	// its index, which is either their full reference or their tag.
+	Layout string
+	// MaxSize is the maximum size of each layer once uncompressed, zero for no limit.
+	MaxSize int64
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// descriptor describes a manifest or a blob.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest is an image manifest, whose layers are the files of the artifact.
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// index is the index of an OCI image layout.
+type index struct {
+	Manifests []descriptor `json:"manifests"`
+}
+
+// Resolve returns the digest of the manifest of the referenced artifact.
+func (c *Client) Resolve(ctx context.Context, ref *Reference) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	if digest, err := c.layoutDigest(ref); err != nil || digest != "" {
+		return digest, err
+	}
+	_, digest, err := c.registryManifest(ctx, ref, ref.Tag)
+	return digest, err
+}
+
+// Pull writes the files of the layers of the artifact with the given manifest digest
+// into dir. Every manifest and blob is verified against its digest.
+func (c *Client) Pull(ctx context.Context, ref *Reference, digest string, dir string) error {
+	data, err := c.manifest(ctx, ref, digest)
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid manifest for %s: %w", ref, err)
+	}
+
+	var extracted int
+	for _, layer := range m.Layers {
+		// Artifacts of other tools, like flux or oras, publish their files as
+		// gzip-compressed tarballs under media types of their own
+		if !strings.HasSuffix(layer.MediaType, "tar+gzip") && !strings.HasSuffix(layer.MediaType, "tar.gzip") {
+			continue
+		}
+		if err := c.pullLayer(ctx, ref, layer.Digest, dir); err != nil {
+			return err
+		}
+		extracted++
+	}
+	if extracted == 0 {
+		return fmt.Errorf("%s has no gzip-compressed tarball layer", ref)
+	}
+	return nil
+}
+
+// pullLayer extracts the layer with the given digest into dir.
+func (c *Client) pullLayer(ctx context.Context, ref *Reference, digest, dir string) error {
+	if !digestRegex.MatchString(digest) {
+		return fmt.Errorf("unsupported layer digest %q in %s", digest, ref)
+	}
+	blob, err := c.blob(ctx, ref, digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	hasher := sha256.New()
+	body := io.TeeReader(blob, hasher)
+	if err := artifacts.Untar(body, dir, c.MaxSize); err != nil {
+		return fmt.Errorf("failed to extract layer %s of %s: %w", digest, ref, err)
+	}
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return fmt.Errorf("failed to read layer %s of %s: %w", digest, ref, err)
+	}
+	if sum := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); sum != digest {
+		return fmt.Errorf("layer %s of %s has digest %s", digest, ref, sum)
+	}
+	return nil
+}
+
+// manifest returns the manifest with the given digest, from the layout if it holds
+// it or else from the registry.
+func (c *Client) manifest(ctx context.Context, ref *Reference, digest string) ([]byte, error) {
+	if !digestRegex.MatchString(digest) {
+		return nil, fmt.Errorf("unsupported manifest digest %q for %s", digest, ref)
+	}
+	var data []byte
+	f, err := c.layoutBlob(digest)
+	switch {
+	case err == nil:
+		defer f.Close()
+		data, err = ioutil.ReadAll(io.LimitReader(f, maxManifestSize))
+		if err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		if data, _, err = c.registryManifest(ctx, ref, digest); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	if sum := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); sum != digest {
+		return nil, fmt.Errorf("manifest %s of %s has digest %s", digest, ref, sum)
+	}
+	return data, nil
+}
+
+// blob opens the blob with the given digest, from the layout if it holds it or else
+// from the registry.
+func (c *Client) blob(ctx context.Context, ref *Reference, digest string) (io.ReadCloser, error) {
+	f, err := c.layoutBlob(digest)
+	if err == nil || !os.IsNotExist(err) {
+		return f, err
+	}
+	resp, err := c.get(ctx, ref, "blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// layoutDigest returns the digest of the manifest of the referenced artifact in the
+// layout, or an empty string if it is not in it.
+func (c *Client) layoutDigest(ref *Reference) (string, error) {
+	if c.Layout == "" {
+		return "", nil
+	}
+	f, err := os.Open(filepath.Join(c.Layout, "index.json"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the OCI layout index: %w", err)
+	}
+	defer f.Close()
+	var idx index
+	if err := json.NewDecoder(io.LimitReader(f, maxManifestSize)).Decode(&idx); err != nil {
+		return "", fmt.Errorf("invalid OCI layout index: %w", err)
+	}
+	for _, m := range idx.Manifests {
+		if name := m.Annotations[RefNameAnnotation]; name != "" && (name == ref.String() || name == ref.Tag) {
+			return m.Digest, nil
+		}
+	}
+	return "", nil
+}
+
+// layoutBlob opens the blob with the given digest in the layout.
+func (c *Client) layoutBlob(digest string) (*os.File, error) {
+	if c.Layout == "" {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(c.Layout, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")))
+}
+
+// registryManifest fetches the manifest with the given tag or digest from the
+// registry, and returns it with its digest.
+func (c *Client) registryManifest(ctx context.Context, ref *Reference, reference string) ([]byte, string, error) {
+	resp, err := c.get(ctx, ref, "manifests/"+reference, manifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if mediaType != manifestMediaType && mediaType != dockerManifestMediaType {
+		return nil, "", fmt.Errorf("unsupported manifest type %q for %s", mediaType, ref)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// get requests the given path of the API of the repository of ref, fetching a bearer
+// token when the registry asks for one.
+func (c *Client) get(ctx context.Context, ref *Reference, path, accept string) (*http.Response, error) {
+	registry, repository := ref.Registry, ref.Repository
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	u := fmt.Sprintf("https://%s/v2/%s/%s", registry, repository, path)
+	tokenKey := registry + "/" + repository
+
+	c.mu.Lock()
+	token := c.tokens[tokenKey]
+	c.mu.Unlock()
+
+	resp, err := c.do(ctx, u, accept, token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if token, err = c.fetchToken(ctx, challenge, repository); err != nil {
+			return nil, fmt.Errorf("failed to authenticate to %s: %w", registry, err)
+		}
+		c.mu.Lock()
+		if c.tokens == nil {
+			c.tokens = make(map[string]string)
+		}
+		c.tokens[tokenKey] = token
+		c.mu.Unlock()
+		if resp, err = c.do(ctx, u, accept, token); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s, status: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+func (c *Client) do(ctx context.Context, u, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient().Do(req)
+}
+
+// fetchToken requests an anonymous pull token for the repository from the realm of
+// the given bearer challenge.
+func (c *Client) fetchToken(ctx context.Context, challenge, repository string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("unsupported challenge %q, only anonymous bearer tokens are supported", challenge)
+	}
+	params := make(map[string]string)
+	for _, match := range challengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme != "https" && realm.Scheme != "http" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	resp, err := c.do(ctx, realm.String(), "", "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed, status: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("the token response holds no token")
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testArtifact returns the manifest and blobs, keyed by digest, of an artifact
+// holding the given files.
+func testArtifact(t *testing.T, files map[string]string) (manifestData []byte, blobs map[string][]byte) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer := buf.Bytes()
+	layerDigest := digestOf(layer)
+	manifestData, err := json.Marshal(manifest{
+		MediaType: manifestMediaType,
+		Layers: []descriptor{{
+			MediaType: "application/vnd.cncf.flux.content.v1.tar+gzip",
+			Digest:    layerDigest,
+			Size:      int64(len(layer)),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifestData, map[string][]byte{layerDigest: layer}
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func TestSplitURL(t *testing.T) {
+	tcs := []struct {
+		url, ref, path string
+		wantErr        bool
+	}{
+		{url: "oci://ghcr.io/org/libs:v1/lib/k.libsonnet", ref: "ghcr.io/org/libs:v1", path: "lib/k.libsonnet"},
+		{url: "oci://localhost:5000/libs@sha256:" + strings.Repeat("a", 64) + "/main.libsonnet", ref: "localhost:5000/libs@sha256:" + strings.Repeat("a", 64), path: "main.libsonnet"},
+		{url: "oci://ghcr.io/org/libs/main.libsonnet", wantErr: true},
+		{url: "oci://ghcr.io/org/Libs:v1/main.libsonnet", wantErr: true},
+		{url: "oci://ghcr.io/org/libs@md5:abc/main.libsonnet", wantErr: true},
+	}
+	for _, tc := range tcs {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, path, err := SplitURL(u)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.url, err)
+			continue
+		}
+		if ref.String() != tc.ref || path != tc.path {
+			t.Errorf("%s: got %s and %s, expected %s and %s", tc.url, ref, path, tc.ref, tc.path)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	manifestData, blobs := testArtifact(t, map[string]string{"lib/k.libsonnet": "{}"})
+	manifestDigest := digestOf(manifestData)
+
+	var tokens int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokens++
+			if r.URL.Query().Get("scope") != "repository:org/libs:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"token":"secret"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="https://%s/token",service="test"`, r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/org/libs/manifests/v1", "/v2/org/libs/manifests/" + manifestDigest:
+			w.Header().Set("Content-Type", manifestMediaType)
+			w.Write(manifestData)
+		default:
+			digest := strings.TrimPrefix(r.URL.Path, "/v2/org/libs/blobs/")
+			if blob, ok := blobs[digest]; ok {
+				w.Write(blob)
+				return
+			}
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	pull := func(c *Client, ref *Reference) (string, error) {
+		digest, err := c.Resolve(context.Background(), ref)
+		if err != nil {
+			return "", err
+		}
+		dir, err := ioutil.TempDir(tmp, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Pull(context.Background(), ref, digest, dir); err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "lib", "k.libsonnet"))
+		return string(data), err
+	}
+
+	// From the registry, authenticating once
+	c := &Client{HTTPClient: srv.Client()}
+	ref, err := ParseReference(host + "/org/libs:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := pull(c, ref); err != nil || data != "{}" {
+		t.Fatalf("expected the library from the registry, got %q, %v", data, err)
+	}
+	if tokens != 1 {
+		t.Errorf("expected a single token request, got %d", tokens)
+	}
+
+	// A corrupted blob is refused
+	for digest := range blobs {
+		blobs[digest] = append([]byte{}, blobs[digest]...)
+		blobs[digest][len(blobs[digest])-1] ^= 0xff
+	}
+	if _, err := pull(c, ref); err == nil {
+		t.Error("expected a corrupted layer to be refused")
+	}
+
+	// From a layout, offline
+	manifestData, blobs = testArtifact(t, map[string]string{"lib/k.libsonnet": "{}"})
+	manifestDigest = digestOf(manifestData)
+	layout := filepath.Join(tmp, "layout")
+	if err := os.MkdirAll(filepath.Join(layout, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	blobs[manifestDigest] = manifestData
+	for digest, data := range blobs {
+		if err := ioutil.WriteFile(filepath.Join(layout, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := json.Marshal(index{Manifests: []descriptor{{
+		MediaType:   manifestMediaType,
+		Digest:      manifestDigest,
+		Size:        int64(len(manifestData)),
+		Annotations: map[string]string{RefNameAnnotation: "v1"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(layout, "index.json"), idx, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = &Client{Layout: layout}
+	ref, err = ParseReference("ghcr.invalid/org/libs:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := pull(c, ref); err != nil || data != "{}" {
+		t.Fatalf("expected the library from the layout, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	repositoryRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegex        = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegex     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Reference is a reference to an artifact in an OCI registry.
+type Reference struct {
+	// Registry is the host of the registry, with an optional port.
+	Registry string
+	// Repository is the path of the repository in the registry.
+	Repository string
+	// Tag is the tag of the artifact, if any.
+	Tag string
+	// Digest is the digest of the manifest of the artifact, if any. It takes
+	// precedence over the tag.
+	Digest string
+}
+
+// ParseReference parses a reference of the form registry/repository:tag or
+// registry/repository@digest. The registry is always explicit.
+func ParseReference(s string) (*Reference, error) {
+	ref := &Reference{}
+	rest := s
+	if i := strings.Index(rest, "@"); i >= 0 {
+		ref.Digest = rest[i+1:]
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ref.Tag = rest[i+1:]
+		rest = rest[:i]
+	}
+	i := strings.Index(rest, "/")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid OCI reference %q: the registry is required", s)
+	}
+	ref.Registry, ref.Repository = rest[:i], rest[i+1:]
+
+	if !repositoryRegex.MatchString(ref.Repository) {
+		return nil, fmt.Errorf("invalid OCI reference %q: invalid repository %q", s, ref.Repository)
+	}
+	if ref.Tag != "" && !tagRegex.MatchString(ref.Tag) {
+		return nil, fmt.Errorf("invalid OCI reference %q: invalid tag %q", s, ref.Tag)
+	}
+	if ref.Digest != "" && !digestRegex.MatchString(ref.Digest) {
+		return nil, fmt.Errorf("invalid OCI reference %q: only sha256 digests are supported", s)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		return nil, fmt.Errorf("invalid OCI reference %q: a tag or digest is required", s)
+	}
+	return ref, nil
+}
+
+// String returns the reference in the form parsed by ParseReference.
+func (r *Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// SplitURL splits an oci:// URL into the reference of an artifact and the path of a
+// file in it. The reference ends at the first path segment with a tag or digest, for
+// example oci://ghcr.io/org/libs:v1/lib/k.libsonnet is the file lib/k.libsonnet of
+// ghcr.io/org/libs:v1.
+func SplitURL(u *url.URL) (*Reference, string, error) {
+	if u.Scheme != "oci" {
+		return nil, "", fmt.Errorf("%s is not an oci:// URL", u)
+	}
+	segments := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	for i, segment := range segments {
+		if !strings.ContainsAny(segment, ":@") {
+			continue
+		}
+		ref, err := ParseReference(u.Host + "/" + strings.Join(segments[:i+1], "/"))
+		if err != nil {
+			return nil, "", err
+		}
+		return ref, strings.Join(segments[i+1:], "/"), nil
+	}
+	return nil, "", fmt.Errorf("%s does not reference an OCI artifact by tag or digest", u)
+}
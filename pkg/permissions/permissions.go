@@ -0,0 +1,336 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package permissions computes the API permissions needed to reconcile the output of
+// a build, and checks them against the identity of a client.
+package permissions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// Permission is a single verb on a resource. Namespace is empty for cluster-scoped
+// resources, and Name is empty when the permission applies to all objects of the resource.
+type Permission struct {
+	Verb      string
+	Group     string
+	Resource  string
+	Namespace string
+	Name      string
+}
+
+// String returns a human readable representation of the permission.
+func (p Permission) String() string {
+	resource := schema.GroupResource{Group: p.Group, Resource: p.Resource}.String()
+	if p.Name != "" {
+		resource += "/" + p.Name
+	}
+	if p.Namespace != "" {
+		return fmt.Sprintf("%s %s in namespace %s", p.Verb, resource, p.Namespace)
+	}
+	return fmt.Sprintf("%s %s", p.Verb, resource)
+}
+
+// MissingError is returned when an identity lacks some of the permissions it needs.
+type MissingError struct {
+	Missing []Permission
+}
+
+// Error implements the error interface.
+func (m *MissingError) Error() string {
+	perms := make([]string, len(m.Missing))
+	for i, p := range m.Missing {
+		perms[i] = p.String()
+	}
+	return fmt.Sprintf("missing %d permission(s): %s", len(m.Missing), strings.Join(perms, "; "))
+}
+
+// Set is a set of permissions.
+type Set map[Permission]struct{}
+
+// NewSet returns a new empty set of permissions.
+func NewSet() Set { return make(Set) }
+
+// Add adds the given permissions to the set.
+func (s Set) Add(perms ...Permission) {
+	for _, p := range perms {
+		s[p] = struct{}{}
+	}
+}
+
+// List returns the permissions in the set, sorted by namespace, group, resource,
+// name, and verb.
+func (s Set) List() []Permission {
+	out := make([]Permission, 0, len(s))
+	for p := range s {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Verb < b.Verb
+	})
+	return out
+}
+
+// WithoutNames returns a copy of the set with all object names dropped, which is
+// the granularity of most RBAC rules.
+func (s Set) WithoutNames() Set {
+	out := make(Set, len(s))
+	for p := range s {
+		p.Name = ""
+		out[p] = struct{}{}
+	}
+	return out
+}
+
+// PolicyRules returns the RBAC rules granting the permissions in the set, keyed by
+// namespace. Cluster-scoped permissions are keyed by the empty string. Resources in
+// the same group that need the same verbs share a rule.
+func (s Set) PolicyRules() map[string][]rbacv1.PolicyRule {
+	type groupResource struct{ namespace, group, resource string }
+	verbs := make(map[groupResource][]string)
+	for _, p := range s.WithoutNames().List() {
+		gr := groupResource{p.Namespace, p.Group, p.Resource}
+		verbs[gr] = append(verbs[gr], p.Verb)
+	}
+
+	type ruleKey struct{ namespace, group, verbs string }
+	byKey := make(map[ruleKey]*rbacv1.PolicyRule)
+	keys := make([]ruleKey, 0)
+	for gr, v := range verbs {
+		sort.Strings(v)
+		key := ruleKey{gr.namespace, gr.group, strings.Join(v, ",")}
+		rule, ok := byKey[key]
+		if !ok {
+			rule = &rbacv1.PolicyRule{APIGroups: []string{gr.group}, Verbs: v}
+			byKey[key] = rule
+			keys = append(keys, key)
+		}
+		rule.Resources = append(rule.Resources, gr.resource)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].verbs < keys[j].verbs
+	})
+
+	out := make(map[string][]rbacv1.PolicyRule)
+	for _, key := range keys {
+		rule := byKey[key]
+		sort.Strings(rule.Resources)
+		out[key.namespace] = append(out[key.namespace], *rule)
+	}
+	return out
+}
+
+// AddApply adds the permissions needed by the resources manager to apply the given
+// objects. Objects are read and server-side applied, which creates them when missing.
+// When force is true, objects may also be deleted and recreated. Kinds not yet served
+// by the API are mapped with the CustomResourceDefinitions among the objects, and
+// skipped when none defines them.
+func (s Set) AddApply(restMapper meta.RESTMapper, objs []*unstructured.Unstructured, force bool) error {
+	verbs := []string{"get", "create", "patch"}
+	if force {
+		verbs = append(verbs, "delete")
+	}
+	crds := crdMappings(objs)
+	for _, obj := range objs {
+		mapping, err := restMapping(restMapper, obj.GroupVersionKind())
+		if err != nil {
+			if !meta.IsNoMatchError(errors.Unwrap(err)) {
+				return err
+			}
+			var ok bool
+			if mapping, ok = crds[obj.GroupVersionKind().GroupKind()]; !ok {
+				continue
+			}
+		}
+		var namespace string
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			namespace = obj.GetNamespace()
+		}
+		for _, verb := range verbs {
+			p := Permission{
+				Verb:      verb,
+				Group:     mapping.Resource.Group,
+				Resource:  mapping.Resource.Resource,
+				Namespace: namespace,
+			}
+			// Creation can not be restricted to object names
+			if verb != "create" {
+				p.Name = obj.GetName()
+			}
+			s.Add(p)
+		}
+	}
+	return nil
+}
+
+// crdMappings returns the mappings of the kinds defined by the CustomResourceDefinitions
+// among the given objects.
+func crdMappings(objs []*unstructured.Unstructured) map[schema.GroupKind]*meta.RESTMapping {
+	mappings := make(map[schema.GroupKind]*meta.RESTMapping)
+	for _, obj := range objs {
+		if obj.GroupVersionKind().GroupKind() != (schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}) {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		plural, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "plural")
+		scope, _, _ := unstructured.NestedString(obj.Object, "spec", "scope")
+		if kind == "" || plural == "" {
+			continue
+		}
+		mapping := &meta.RESTMapping{
+			Resource: schema.GroupVersionResource{Group: group, Resource: plural},
+			Scope:    meta.RESTScopeRoot,
+		}
+		if scope == "Namespaced" {
+			mapping.Scope = meta.RESTScopeNamespace
+		}
+		mappings[schema.GroupKind{Group: group, Kind: kind}] = mapping
+	}
+	return mappings
+}
+
+// AddPrune adds the permissions needed to garbage-collect the kinds recorded in the
+// given snapshot.
+func (s Set) AddPrune(restMapper meta.RESTMapper, snapshot *konfigurationv1.Snapshot) error {
+	if snapshot == nil {
+		return nil
+	}
+	add := func(gvk schema.GroupVersionKind, namespace string) error {
+		mapping, err := restMapping(restMapper, gvk)
+		if err != nil {
+			// Kinds no longer served by the API can not be pruned anyway
+			if meta.IsNoMatchError(errors.Unwrap(err)) {
+				return nil
+			}
+			return err
+		}
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			namespace = ""
+		}
+		for _, verb := range []string{"list", "delete"} {
+			s.Add(Permission{
+				Verb:      verb,
+				Group:     mapping.Resource.Group,
+				Resource:  mapping.Resource.Resource,
+				Namespace: namespace,
+			})
+		}
+		return nil
+	}
+	for ns, gvks := range snapshot.NamespacedKinds() {
+		for _, gvk := range gvks {
+			if err := add(gvk, ns); err != nil {
+				return err
+			}
+		}
+	}
+	for _, gvk := range snapshot.NonNamespacedKinds() {
+		if err := add(gvk, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Missing checks every permission in the set with a SelfSubjectAccessReview using the
+// given client, and returns the ones that are not granted to its identity. Permissions
+// on named objects are first reviewed for all objects of their resource, and only
+// reviewed one by one when that is denied.
+func (s Set) Missing(ctx context.Context, cl client.Client) ([]Permission, error) {
+	reviewed := make(map[Permission]bool)
+	allowed := func(p Permission) (bool, error) {
+		if ok, seen := reviewed[p]; seen {
+			return ok, nil
+		}
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:      p.Verb,
+					Group:     p.Group,
+					Resource:  p.Resource,
+					Namespace: p.Namespace,
+					Name:      p.Name,
+				},
+			},
+		}
+		if err := cl.Create(ctx, review); err != nil {
+			return false, fmt.Errorf("failed to review access to %s: %w", p.String(), err)
+		}
+		reviewed[p] = review.Status.Allowed
+		return review.Status.Allowed, nil
+	}
+
+	var missing []Permission
+	for _, p := range s.List() {
+		if p.Name != "" {
+			all := p
+			all.Name = ""
+			ok, err := allowed(all)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				continue
+			}
+		}
+		ok, err := allowed(p)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+func restMapping(restMapper meta.RESTMapper, gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s to a resource: %w", gvk.String(), err)
+	}
+	return mapping, nil
+}
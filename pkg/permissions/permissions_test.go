@@ -0,0 +1,168 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package permissions
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newObj(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func TestPolicyRules(t *testing.T) {
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+
+	perms := NewSet()
+	if err := perms.AddApply(restMapper, []*unstructured.Unstructured{
+		newObj("v1", "Namespace", "", "app"),
+		newObj("v1", "ConfigMap", "app", "config"),
+		newObj("v1", "Secret", "app", "creds"),
+		newObj("apps/v1", "Deployment", "app", "app"),
+		newObj("v1", "ConfigMap", "other", "config"),
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	applyVerbs := []string{"create", "get", "patch"}
+	expected := map[string][]rbacv1.PolicyRule{
+		"": {
+			{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: applyVerbs},
+		},
+		"app": {
+			{APIGroups: []string{""}, Resources: []string{"configmaps", "secrets"}, Verbs: applyVerbs},
+			{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: applyVerbs},
+		},
+		"other": {
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: applyVerbs},
+		},
+	}
+	if rules := perms.PolicyRules(); !reflect.DeepEqual(rules, expected) {
+		t.Errorf("unexpected rules:\n got: %+v\nwant: %+v", rules, expected)
+	}
+}
+
+func TestAddApplyCRDs(t *testing.T) {
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}, meta.RESTScopeRoot)
+
+	crd := newObj("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "widgets.example.com")
+	crd.Object["spec"] = map[string]interface{}{
+		"group": "example.com",
+		"scope": "Namespaced",
+		"names": map[string]interface{}{"kind": "Widget", "plural": "widgets"},
+	}
+
+	perms := NewSet()
+	if err := perms.AddApply(restMapper, []*unstructured.Unstructured{
+		crd,
+		newObj("example.com/v1", "Widget", "app", "widget"),
+		// Kinds neither served nor defined by the build are left to the apply
+		newObj("other.com/v1", "Gadget", "app", "gadget"),
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	applyVerbs := []string{"create", "get", "patch"}
+	expected := map[string][]rbacv1.PolicyRule{
+		"": {
+			{APIGroups: []string{"apiextensions.k8s.io"}, Resources: []string{"customresourcedefinitions"}, Verbs: applyVerbs},
+		},
+		"app": {
+			{APIGroups: []string{"example.com"}, Resources: []string{"widgets"}, Verbs: applyVerbs},
+		},
+	}
+	if rules := perms.PolicyRules(); !reflect.DeepEqual(rules, expected) {
+		t.Errorf("unexpected rules:\n got: %+v\nwant: %+v", rules, expected)
+	}
+}
+
+// reviewClient answers SelfSubjectAccessReviews with the given function and counts them.
+type reviewClient struct {
+	client.Client
+	allowed func(attrs *authorizationv1.ResourceAttributes) bool
+	reviews int
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	review := obj.(*authorizationv1.SelfSubjectAccessReview)
+	c.reviews++
+	review.Status.Allowed = c.allowed(review.Spec.ResourceAttributes)
+	return nil
+}
+
+func TestMissing(t *testing.T) {
+	ctx := context.Background()
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+
+	var objs []*unstructured.Unstructured
+	for _, name := range []string{"a", "b", "c"} {
+		objs = append(objs, newObj("v1", "ConfigMap", "app", name), newObj("v1", "Secret", "app", name))
+	}
+	perms := NewSet()
+	if err := perms.AddApply(restMapper, objs, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Access granted on whole resources is reviewed once per verb
+	cl := &reviewClient{allowed: func(*authorizationv1.ResourceAttributes) bool { return true }}
+	if missing, err := perms.Missing(ctx, cl); err != nil || len(missing) != 0 {
+		t.Fatalf("expected no missing permissions, got %v, %v", missing, err)
+	}
+	if cl.reviews != 6 {
+		t.Errorf("expected 6 reviews, got %d", cl.reviews)
+	}
+
+	// Secrets may only be read by name, and patched for "a"
+	cl = &reviewClient{allowed: func(attrs *authorizationv1.ResourceAttributes) bool {
+		if attrs.Resource != "secrets" {
+			return true
+		}
+		return attrs.Verb == "create" || (attrs.Name != "" && (attrs.Verb == "get" || attrs.Name == "a"))
+	}}
+	missing, err := perms.Missing(ctx, cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Permission{
+		{Verb: "patch", Resource: "secrets", Namespace: "app", Name: "b"},
+		{Verb: "patch", Resource: "secrets", Namespace: "app", Name: "c"},
+	}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("unexpected missing permissions:\n got: %+v\nwant: %+v", missing, expected)
+	}
+}
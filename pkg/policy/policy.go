@@ -0,0 +1,185 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy evaluates KonfigurationPolicies against the objects rendered by
+// a Konfiguration.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+// ViolationError is returned when rendered objects violate one or more policies.
+type ViolationError struct {
+	Violations []string
+}
+
+// Error implements the error interface.
+func (v *ViolationError) Error() string {
+	return fmt.Sprintf("%d policy violation(s): %s", len(v.Violations), strings.Join(v.Violations, "; "))
+}
+
+// ForNamespace returns the policies that apply to Konfigurations in the given namespace.
+func ForNamespace(ctx context.Context, c client.Client, namespace string) ([]konfigurationv1.KonfigurationPolicy, error) {
+	var policies konfigurationv1.KonfigurationPolicyList
+	if err := c.List(ctx, &policies); err != nil {
+		return nil, fmt.Errorf("failed to list konfiguration policies: %w", err)
+	}
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+
+	var ns corev1.Namespace
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return nil, fmt.Errorf("failed to retrieve namespace '%s': %w", namespace, err)
+	}
+
+	matched := make([]konfigurationv1.KonfigurationPolicy, 0, len(policies.Items))
+	for _, p := range policies.Items {
+		if p.Spec.NamespaceSelector == nil {
+			matched = append(matched, p)
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("policy '%s' has an invalid namespace selector: %w", p.GetName(), err)
+		}
+		if selector.Matches(labels.Set(ns.GetLabels())) {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+// Check evaluates the given policies against the objects rendered by a Konfiguration
+// in the given namespace. The restMapper is used to determine the scope of each object.
+// If any object violates a policy, a *ViolationError listing every violation is returned.
+func Check(policies []konfigurationv1.KonfigurationPolicy, namespace string, restMapper meta.RESTMapper, objs []*unstructured.Unstructured) error {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	var violations []string
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		// Kinds unknown to the cluster, e.g. of CRDs created by the same build, may
+		// still be judged by their kind, but their scope cannot be determined.
+		scope := scopeUnknown
+		mapping, err := restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		switch {
+		case err == nil && mapping.Scope.Name() == meta.RESTScopeNameNamespace:
+			scope = scopeNamespaced
+		case err == nil:
+			scope = scopeCluster
+		case !meta.IsNoMatchError(err):
+			return err
+		}
+
+		for _, p := range policies {
+			if msg := checkObject(&p.Spec, namespace, obj, scope); msg != "" {
+				violations = append(violations, fmt.Sprintf("policy '%s': %s %s", p.GetName(), describe(obj), msg))
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+	return nil
+}
+
+// objectScope is the scope of a rendered object, as known to the REST mapper.
+type objectScope int
+
+const (
+	scopeNamespaced objectScope = iota
+	scopeCluster
+	scopeUnknown
+)
+
+// checkObject returns a description of how the object violates the policy, or an
+// empty string if it does not. Objects of an unknown scope must pass the checks for
+// both cluster-scoped and namespaced objects.
+func checkObject(spec *konfigurationv1.KonfigurationPolicySpec, namespace string, obj *unstructured.Unstructured, scope objectScope) string {
+	gk := obj.GroupVersionKind().GroupKind()
+
+	if matchesAny(spec.DeniedKinds, gk.Group, gk.Kind) {
+		return "is of a denied kind"
+	}
+	if len(spec.AllowedKinds) > 0 && !matchesAny(spec.AllowedKinds, gk.Group, gk.Kind) {
+		return "is not of an allowed kind"
+	}
+
+	switch scope {
+	case scopeCluster:
+		if !spec.AllowClusterScoped {
+			return "is cluster-scoped"
+		}
+		return ""
+	case scopeUnknown:
+		if !spec.AllowClusterScoped {
+			return "is of a kind unknown to the cluster and may be cluster-scoped"
+		}
+		if obj.GetNamespace() == "" {
+			return ""
+		}
+	}
+
+	if len(spec.AllowedNamespaces) > 0 && obj.GetNamespace() != namespace {
+		for _, pattern := range spec.AllowedNamespaces {
+			if ok, _ := path.Match(pattern, obj.GetNamespace()); ok {
+				return ""
+			}
+		}
+		return "targets a namespace that is not allowed"
+	}
+
+	return ""
+}
+
+func matchesAny(kinds []metav1.GroupKind, group, kind string) bool {
+	for _, gk := range kinds {
+		g := gk.Group
+		if g == "core" {
+			g = ""
+		}
+		if g == group && (gk.Kind == "*" || gk.Kind == kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(obj *unstructured.Unstructured) string {
+	gk := obj.GroupVersionKind().GroupKind()
+	if obj.GetNamespace() != "" {
+		return fmt.Sprintf("%s '%s/%s'", gk.String(), obj.GetNamespace(), obj.GetName())
+	}
+	return fmt.Sprintf("%s '%s'", gk.String(), obj.GetName())
+}
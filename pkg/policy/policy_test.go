@@ -0,0 +1,278 @@
+/*
+Copyright 2021 Pelotech.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	konfigurationv1 "github.com/pelotech/jsonnet-controller/api/v1beta1"
+)
+
+func newPolicy(name string, selector *metav1.LabelSelector) *konfigurationv1.KonfigurationPolicy {
+	return &konfigurationv1.KonfigurationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       konfigurationv1.KonfigurationPolicySpec{NamespaceSelector: selector},
+	}
+}
+
+func TestForNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := konfigurationv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	tenantNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Labels: map[string]string{"tenant": "true"}}}
+	systemNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "system"}}
+
+	tcs := []struct {
+		name      string
+		policies  []*konfigurationv1.KonfigurationPolicy
+		namespace string
+		expected  []string
+		wantErr   bool
+	}{
+		{
+			name:      "no policies",
+			namespace: "missing",
+		},
+		{
+			name: "selectors",
+			policies: []*konfigurationv1.KonfigurationPolicy{
+				newPolicy("all", nil),
+				newPolicy("tenants", &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}}),
+				newPolicy("others", &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "false"}}),
+			},
+			namespace: "tenant",
+			expected:  []string{"all", "tenants"},
+		},
+		{
+			name: "unlabeled namespace",
+			policies: []*konfigurationv1.KonfigurationPolicy{
+				newPolicy("all", nil),
+				newPolicy("tenants", &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}}),
+			},
+			namespace: "system",
+			expected:  []string{"all"},
+		},
+		{
+			name: "invalid selector",
+			policies: []*konfigurationv1.KonfigurationPolicy{
+				newPolicy("invalid", &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tenant", Operator: "Bogus"}}}),
+			},
+			namespace: "tenant",
+			wantErr:   true,
+		},
+		{
+			name:      "missing namespace",
+			policies:  []*konfigurationv1.KonfigurationPolicy{newPolicy("all", nil)},
+			namespace: "missing",
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tenantNamespace, systemNamespace)
+			for _, p := range tc.policies {
+				builder = builder.WithObjects(p)
+			}
+			policies, err := ForNamespace(context.Background(), builder.Build(), tc.namespace)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, p := range policies {
+				names = append(names, p.GetName())
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("expected policies %v, got %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+
+	newObj := func(apiVersion, kind, namespace string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetNamespace(namespace)
+		obj.SetName("test")
+		return obj
+	}
+
+	tcs := []struct {
+		name       string
+		spec       konfigurationv1.KonfigurationPolicySpec
+		obj        *unstructured.Unstructured
+		violation  string
+		wantErr    bool
+		restMapper meta.RESTMapper
+	}{
+		{
+			name: "allowed kind",
+			spec: konfigurationv1.KonfigurationPolicySpec{AllowedKinds: []metav1.GroupKind{{Group: "core", Kind: "ConfigMap"}}},
+			obj:  newObj("v1", "ConfigMap", "tenant"),
+		},
+		{
+			name:      "kind not allowed",
+			spec:      konfigurationv1.KonfigurationPolicySpec{AllowedKinds: []metav1.GroupKind{{Group: "core", Kind: "ConfigMap"}}},
+			obj:       newObj("v1", "Secret", "tenant"),
+			violation: "is not of an allowed kind",
+		},
+		{
+			name: "wildcard kind",
+			spec: konfigurationv1.KonfigurationPolicySpec{AllowedKinds: []metav1.GroupKind{{Group: "apps", Kind: "*"}}},
+			obj:  newObj("apps/v1", "Deployment", "tenant"),
+		},
+		{
+			name: "denied kind",
+			spec: konfigurationv1.KonfigurationPolicySpec{
+				AllowedKinds: []metav1.GroupKind{{Group: "core", Kind: "*"}},
+				DeniedKinds:  []metav1.GroupKind{{Group: "", Kind: "Secret"}},
+			},
+			obj:       newObj("v1", "Secret", "tenant"),
+			violation: "is of a denied kind",
+		},
+		{
+			name:      "cluster-scoped",
+			obj:       newObj("rbac.authorization.k8s.io/v1", "ClusterRole", ""),
+			violation: "is cluster-scoped",
+		},
+		{
+			name: "cluster-scoped allowed",
+			spec: konfigurationv1.KonfigurationPolicySpec{AllowClusterScoped: true},
+			obj:  newObj("rbac.authorization.k8s.io/v1", "ClusterRole", ""),
+		},
+		{
+			name: "own namespace",
+			spec: konfigurationv1.KonfigurationPolicySpec{AllowedNamespaces: []string{"shared"}},
+			obj:  newObj("v1", "ConfigMap", "tenant"),
+		},
+		{
+			name: "allowed namespace pattern",
+			spec: konfigurationv1.KonfigurationPolicySpec{AllowedNamespaces: []string{"tenant-*"}},
+			obj:  newObj("v1", "ConfigMap", "tenant-dev"),
+		},
+		{
+			name:      "namespace not allowed",
+			spec:      konfigurationv1.KonfigurationPolicySpec{AllowedNamespaces: []string{"tenant-*"}},
+			obj:       newObj("v1", "ConfigMap", "kube-system"),
+			violation: "targets a namespace that is not allowed",
+		},
+		{
+			name:      "unknown kind",
+			obj:       newObj("example.com/v1", "Widget", "tenant"),
+			violation: "is of a kind unknown to the cluster",
+		},
+		{
+			name:      "unknown kind of a denied group",
+			spec:      konfigurationv1.KonfigurationPolicySpec{AllowClusterScoped: true, DeniedKinds: []metav1.GroupKind{{Group: "example.com", Kind: "*"}}},
+			obj:       newObj("example.com/v1", "Widget", "tenant"),
+			violation: "is of a denied kind",
+		},
+		{
+			name: "unknown kind with cluster-scoped allowed",
+			spec: konfigurationv1.KonfigurationPolicySpec{AllowClusterScoped: true},
+			obj:  newObj("example.com/v1", "Widget", ""),
+		},
+		{
+			name:      "unknown kind in a namespace that is not allowed",
+			spec:      konfigurationv1.KonfigurationPolicySpec{AllowClusterScoped: true, AllowedNamespaces: []string{"tenant-*"}},
+			obj:       newObj("example.com/v1", "Widget", "kube-system"),
+			violation: "targets a namespace that is not allowed",
+		},
+		{
+			name:       "mapping failure",
+			obj:        newObj("v1", "ConfigMap", "tenant"),
+			restMapper: &failingRESTMapper{restMapper},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			mapper := tc.restMapper
+			if mapper == nil {
+				mapper = restMapper
+			}
+			policy := konfigurationv1.KonfigurationPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy"}, Spec: tc.spec}
+			err := Check([]konfigurationv1.KonfigurationPolicy{policy}, "tenant", mapper, []*unstructured.Unstructured{tc.obj})
+
+			var violation *ViolationError
+			switch {
+			case tc.wantErr:
+				if err == nil || errors.As(err, &violation) {
+					t.Errorf("expected a mapping error, got %v", err)
+				}
+			case tc.violation == "":
+				if err != nil {
+					t.Errorf("expected no violations, got %v", err)
+				}
+			case !errors.As(err, &violation):
+				t.Errorf("expected a violation, got %v", err)
+			case len(violation.Violations) != 1 || !strings.Contains(violation.Violations[0], tc.violation):
+				t.Errorf("expected a violation containing %q, got %v", tc.violation, violation.Violations)
+			}
+		})
+	}
+}
+
+func TestCheckWithoutPolicies(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("example.com/v1")
+	obj.SetKind("Widget")
+	if err := Check(nil, "tenant", &failingRESTMapper{}, []*unstructured.Unstructured{obj}); err != nil {
+		t.Errorf("expected no error without policies, got %v", err)
+	}
+}
+
+// failingRESTMapper fails every mapping with an error other than a missing match.
+type failingRESTMapper struct {
+	meta.RESTMapper
+}
+
+func (f *failingRESTMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	return nil, errors.New("discovery failed")
+}